@@ -0,0 +1,244 @@
+package simple_png
+
+import (
+	"bytes"
+	"compress/zlib"
+	"image"
+	"image/color"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// nrgba64At returns the non-premultiplied color at (x, y), without the precision lost by
+// converting through the premultiplied RGBA method for the types ToImage produces.
+func nrgba64At(img image.Image, x, y int) color.NRGBA64 {
+	switch m := img.(type) {
+	case *image.NRGBA:
+		c := m.NRGBAAt(x, y)
+		return color.NRGBA64{R: uint16(c.R) * 0x101, G: uint16(c.G) * 0x101, B: uint16(c.B) * 0x101, A: uint16(c.A) * 0x101}
+	case *image.NRGBA64:
+		return m.NRGBA64At(x, y)
+	case *image.Paletted:
+		if c, ok := m.Palette[m.ColorIndexAt(x, y)].(color.NRGBA); ok {
+			return color.NRGBA64{R: uint16(c.R) * 0x101, G: uint16(c.G) * 0x101, B: uint16(c.B) * 0x101, A: uint16(c.A) * 0x101}
+		}
+	}
+	return color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+}
+
+// ActualProperties scans the decoded pixels, isGray reports every pixel has equal red, green and blue,
+// hasTransparency reports any pixel isn't fully opaque and distinctColors counts the unique colors.
+// Fully transparent pixels still count toward isGray and distinctColors with their stored color.
+func (p *Png) ActualProperties() (isGray, hasTransparency bool, distinctColors int, err error) {
+	img, err := p.ToImage()
+	if err != nil {
+		return false, false, 0, err
+	}
+	var b = img.Bounds()
+	var colors = map[color.NRGBA64]struct{}{}
+	isGray = true
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := nrgba64At(img, x, y)
+			if c.R != c.G || c.G != c.B {
+				isGray = false
+			}
+			if c.A != 0xffff {
+				hasTransparency = true
+			}
+			colors[c] = struct{}{}
+		}
+	}
+	return isGray, hasTransparency, len(colors), nil
+}
+
+// HasTransparency reports whether any pixel isn't fully opaque. Images without an alpha
+// channel or tRNS chunk are opaque without decoding, otherwise the pixels are scanned up
+// to the first transparent one.
+func (p *Png) HasTransparency() (bool, error) {
+	p.RLock()
+	if p.IHDR == nil {
+		p.RUnlock()
+		return false, errors.New("no IHDR found")
+	}
+	var ct, trns = p.IHDR.ColorType, p.TRNS
+	p.RUnlock()
+	if ct != GrayscaleAlpha && ct != TruecolorAlpha && trns == nil {
+		return false, nil
+	}
+	img, err := p.ToImage()
+	if err != nil {
+		return false, err
+	}
+	var b = img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if nrgba64At(img, x, y).A != 0xffff {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+type averageConfig struct {
+	ignoreTransparent bool
+}
+
+type AverageOption func(*averageConfig)
+
+// IgnoreTransparent makes AverageColor average the colors of the pixels that aren't fully
+// transparent, each counting the same whatever its alpha, and return the result opaque.
+func IgnoreTransparent() AverageOption {
+	return func(c *averageConfig) {
+		c.ignoreTransparent = true
+	}
+}
+
+// AverageColor decodes p and averages its pixels, for placeholder or dominant color swatches.
+// By default colors are weighted by their alpha and the alpha is averaged too, so mostly
+// transparent images give a mostly transparent color; IgnoreTransparent changes that. An image
+// without any visible pixel averages to transparent black.
+func (p *Png) AverageColor(opts ...AverageOption) (color.RGBA, error) {
+	var conf = &averageConfig{}
+	for _, opt := range opts {
+		opt(conf)
+	}
+	img, err := p.ToImage()
+	if err != nil {
+		return color.RGBA{}, err
+	}
+	// sums of the 16 bit samples, premultiplied unless transparent pixels are ignored
+	var r, g, b, a, n uint64
+	var bounds = img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := nrgba64At(img, x, y)
+			switch {
+			case c.A == 0:
+				if !conf.ignoreTransparent {
+					n++
+				}
+			case conf.ignoreTransparent:
+				r, g, b, a, n = r+uint64(c.R), g+uint64(c.G), b+uint64(c.B), a+0xffff, n+1
+			default:
+				var ca = uint64(c.A)
+				r, g, b, a = r+uint64(c.R)*ca/0xffff, g+uint64(c.G)*ca/0xffff, b+uint64(c.B)*ca/0xffff, a+ca
+				n++
+			}
+		}
+	}
+	if a == 0 {
+		return color.RGBA{}, nil
+	}
+	var avg = func(sum uint64) uint8 {
+		return uint8((sum/n + 0x80) / 0x101)
+	}
+	return color.RGBA{R: avg(r), G: avg(g), B: avg(b), A: avg(a)}, nil
+}
+
+// CompressionRatio is the summed IDAT length fields divided by RawSize, lower means better
+// compressed. Filter type bytes aren't counted in the raw size. The lengths survive
+// ParseMetadata, so the ratio is available without reading the image data.
+func (p *Png) CompressionRatio() (float64, error) {
+	raw, err := p.RawSize()
+	if err != nil {
+		return 0, err
+	}
+	p.RLock()
+	defer p.RUnlock()
+	var compressed int
+	for _, c := range p.chunks {
+		if ChunkName(c.code[:]) == IDATChunk {
+			compressed += int(by.Uint32(c.len[:]))
+		}
+	}
+	return float64(compressed) / float64(raw), nil
+}
+
+// ZlibInfo decodes the two byte zlib header (CMF and FLG) opening the IDAT datastream, without
+// inflating anything: the compression method, 8 for deflate, the base-2 logarithm of the LZ77
+// window size and the compression level, from 0 (fastest) to 3 (maximum compression). The level
+// is only the encoder's hint of what it aimed for.
+func (p *Png) ZlibInfo() (method, windowBits, level int, err error) {
+	p.RLock()
+	defer p.RUnlock()
+	var header [2]byte
+	if _, err = io.ReadFull(p.idatReader(), header[:]); err != nil {
+		return 0, 0, 0, errors.Wrap(err, "read zlib header")
+	}
+	var cmf, flg = header[0], header[1]
+	if (uint16(cmf)<<8|uint16(flg))%31 != 0 {
+		return 0, 0, 0, errors.New("invalid zlib header check bits")
+	}
+	return int(cmf & 0x0f), int(cmf>>4) + 8, int(flg >> 6), nil
+}
+
+// FilterHistogram counts the scanlines using each filter type, indexed None, Sub, Up, Average and
+// Paeth, summed across the passes of interlaced images. Only the filter type bytes are read, the
+// scanlines aren't unfiltered.
+func (p *Png) FilterHistogram() ([5]int, error) {
+	var hist [5]int
+	p.RLock()
+	defer p.RUnlock()
+	if p.IHDR == nil {
+		return hist, errors.New("no IHDR found")
+	}
+	if err := p.IHDR.checkDecodable(); err != nil {
+		return hist, err
+	}
+	zr, err := zlib.NewReader(p.idatReader())
+	if err != nil {
+		return hist, errors.WithStack(err)
+	}
+	defer zr.Close()
+	for _, ps := range p.IHDR.passes() {
+		var row = make([]byte, 1+p.IHDR.rowBytes(ps.width))
+		for y := 0; y < ps.height; y++ {
+			if _, err = io.ReadFull(zr, row); err != nil {
+				return hist, errors.WithStack(err)
+			}
+			if int(row[0]) >= len(hist) {
+				return hist, errors.Errorf("invalid filter type %d", row[0])
+			}
+			hist[row[0]]++
+		}
+	}
+	return hist, nil
+}
+
+// VerifyLosslessReencode decodes the image, encodes it again the way Encode does, decodes that
+// and returns an error naming the first pixel that differs. Fully transparent pixels compare
+// equal whatever their color.
+func (p *Png) VerifyLosslessReencode() error {
+	img, err := p.ToImage()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err = Encode(&buf, img); err != nil {
+		return err
+	}
+	q, err := ParsePng(&buf)
+	if err != nil {
+		return errors.Wrap(err, "parsing the re-encoded png")
+	}
+	out, err := q.ToImage()
+	if err != nil {
+		return errors.Wrap(err, "decoding the re-encoded png")
+	}
+	var b = img.Bounds()
+	if out.Bounds() != b {
+		return errors.Errorf("bounds changed from %v to %v", b, out.Bounds())
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			var c, d = nrgba64At(img, x, y), nrgba64At(out, x, y)
+			if c != d && (c.A != 0 || d.A != 0) {
+				return errors.Errorf("pixel (%d,%d) changed from %v to %v", x, y, c, d)
+			}
+		}
+	}
+	return nil
+}
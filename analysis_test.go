@@ -0,0 +1,236 @@
+package simple_png
+
+import (
+	"bytes"
+	"compress/zlib"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func TestActualProperties(t *testing.T) {
+	var gray = image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for i := 0; i < 16; i++ {
+		gray.SetNRGBA(i%4, i/4, color.NRGBA{R: uint8(i % 3), G: uint8(i % 3), B: uint8(i % 3), A: 0xff})
+	}
+	var alpha = image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	alpha.SetNRGBA(0, 0, color.NRGBA{R: 10, G: 20, B: 30, A: 0xff})
+	alpha.SetNRGBA(1, 0, color.NRGBA{R: 10, G: 20, B: 30, A: 0x80})
+	var cases = []struct {
+		img             image.Image
+		isGray, hasAlph bool
+		distinct        int
+	}{
+		{gray, true, false, 3},
+		{alpha, false, true, 2},
+	}
+	for i, c := range cases {
+		var buf bytes.Buffer
+		if err := Encode(&buf, c.img); err != nil {
+			t.Fatal(err)
+		}
+		p, err := ParsePng(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		isGray, hasAlpha, distinct, err := p.ActualProperties()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isGray != c.isGray || hasAlpha != c.hasAlph || distinct != c.distinct {
+			t.Fatalf("case %d: got %v %v %d", i, isGray, hasAlpha, distinct)
+		}
+	}
+}
+
+func TestHasTransparency(t *testing.T) {
+	var rgba = &IHDR{Width: 2, Height: 1, BitDepth: 8, ColorType: TruecolorAlpha}
+	var indexed = &IHDR{Width: 2, Height: 1, BitDepth: 8, ColorType: Indexed}
+	var truecolor = &IHDR{Width: 2, Height: 1, BitDepth: 8, ColorType: Truecolor}
+	var plte = newChunk(PLTEChunk, []byte{0, 0, 0, 1, 1, 1, 2, 2, 2})
+	var cases = []struct {
+		chunks []*chunk
+		want   bool
+	}{
+		{[]*chunk{ihdrChunk(rgba), newChunk(IDATChunk, encodeSamples(rgba, [][]uint16{{1, 2, 3, 255, 4, 5, 6, 255}}))}, false},
+		{[]*chunk{ihdrChunk(rgba), newChunk(IDATChunk, encodeSamples(rgba, [][]uint16{{1, 2, 3, 255, 4, 5, 6, 254}}))}, true},
+		{[]*chunk{ihdrChunk(indexed), plte, newChunk(TRNSChunk, []byte{255, 255, 0}), newChunk(IDATChunk, encodeSamples(indexed, [][]uint16{{0, 1}}))}, false},
+		{[]*chunk{ihdrChunk(indexed), plte, newChunk(TRNSChunk, []byte{255, 255, 0}), newChunk(IDATChunk, encodeSamples(indexed, [][]uint16{{0, 2}}))}, true},
+		{[]*chunk{ihdrChunk(truecolor), newChunk(TRNSChunk, []byte{0, 4, 0, 5, 0, 6}), newChunk(IDATChunk, encodeSamples(truecolor, [][]uint16{{1, 2, 3, 4, 5, 6}}))}, true},
+		{[]*chunk{ihdrChunk(truecolor), newChunk(IDATChunk, encodeSamples(truecolor, [][]uint16{{1, 2, 3, 4, 5, 6}}))}, false},
+	}
+	for i, c := range cases {
+		p, err := ParsePng(bytes.NewReader(buildPng(append(c.chunks, newChunk(IENDChunk, nil))...)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := p.HasTransparency()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Fatalf("case %d: HasTransparency() = %v", i, got)
+		}
+	}
+}
+
+func TestAverageColor(t *testing.T) {
+	var rgba = &IHDR{Width: 2, Height: 2, BitDepth: 8, ColorType: TruecolorAlpha}
+	var gray = &IHDR{Width: 2, Height: 1, BitDepth: 16, ColorType: Grayscale}
+	var cases = []struct {
+		ihdr     *IHDR
+		samples  [][]uint16
+		weighted color.RGBA
+		ignored  color.RGBA
+	}{
+		{rgba, [][]uint16{{200, 0, 0, 255, 0, 0, 200, 255}, {0, 100, 0, 0, 0, 100, 0, 0}},
+			color.RGBA{R: 50, B: 50, A: 127}, color.RGBA{R: 100, B: 100, A: 255}},
+		{rgba, [][]uint16{{255, 255, 255, 51, 0, 0, 0, 0}, {0, 0, 0, 0, 0, 0, 0, 0}},
+			color.RGBA{R: 13, G: 13, B: 13, A: 13}, color.RGBA{R: 255, G: 255, B: 255, A: 255}},
+		{rgba, [][]uint16{{9, 9, 9, 0, 9, 9, 9, 0}, {9, 9, 9, 0, 9, 9, 9, 0}}, color.RGBA{}, color.RGBA{}},
+		{gray, [][]uint16{{0, 0xffff}}, color.RGBA{R: 127, G: 127, B: 127, A: 255}, color.RGBA{R: 127, G: 127, B: 127, A: 255}},
+	}
+	for i, c := range cases {
+		p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(c.ihdr), newChunk(IDATChunk, encodeSamples(c.ihdr, c.samples)), newChunk(IENDChunk, nil))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		weighted, err := p.AverageColor()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ignored, err := p.AverageColor(IgnoreTransparent())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if weighted != c.weighted || ignored != c.ignored {
+			t.Fatalf("case %d: weighted %v, ignoring transparent %v", i, weighted, ignored)
+		}
+	}
+}
+
+func TestCompressionRatio(t *testing.T) {
+	var ihdr = &IHDR{Width: 100, Height: 100, BitDepth: 8, ColorType: Truecolor}
+	var idat = blankIDAT(ihdr)
+	p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), idat, newChunk(IENDChunk, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ratio, err := p.CompressionRatio()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := float64(len(idat.data)) / 30000; ratio != want || ratio >= 0.01 {
+		t.Fatalf("ratio %v, want %v", ratio, want)
+	}
+
+	m, err := ParseMetadata(bytes.NewReader(buildPng(ihdrChunk(ihdr), idat, newChunk(IENDChunk, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := m.CompressionRatio(); err != nil || got != ratio {
+		t.Fatalf("metadata only ratio %v, %v, want %v", got, err, ratio)
+	}
+}
+
+func TestZlibInfo(t *testing.T) {
+	var ihdr = &IHDR{Width: 8, Height: 8, BitDepth: 8, ColorType: Grayscale}
+	for zlevel, want := range map[int]int{zlib.BestSpeed: 0, 4: 1, zlib.DefaultCompression: 2, zlib.BestCompression: 3} {
+		var buf bytes.Buffer
+		zw, _ := zlib.NewWriterLevel(&buf, zlevel)
+		zw.Write(make([]byte, 9*8))
+		zw.Close()
+		var stream = buf.Bytes()
+		p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), newChunk(IDATChunk, stream[:1]), newChunk(IDATChunk, stream[1:]), newChunk(IENDChunk, nil))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		method, windowBits, level, err := p.ZlibInfo()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if method != 8 || windowBits != 15 || level != want {
+			t.Fatalf("zlib level %d: method %d, window bits %d, level %d", zlevel, method, windowBits, level)
+		}
+	}
+
+	p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), newChunk(IDATChunk, []byte{0x78, 0x9d}), newChunk(IENDChunk, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err = p.ZlibInfo(); err == nil {
+		t.Fatal("bad check bits accepted")
+	}
+}
+
+func TestFilterHistogram(t *testing.T) {
+	// stream gives every scanline of ihdr the filter type filter(y), y counting across the passes
+	var stream = func(ihdr *IHDR, filter func(y int) byte) []byte {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		var y int
+		for _, ps := range ihdr.passes() {
+			for i := 0; i < ps.height; i++ {
+				zw.Write(append([]byte{filter(y)}, make([]byte, ihdr.rowBytes(ps.width))...))
+				y++
+			}
+		}
+		zw.Close()
+		return buf.Bytes()
+	}
+	var gray = &IHDR{Width: 2, Height: 5, BitDepth: 8, ColorType: Grayscale}
+	var interlaced = &IHDR{Width: 10, Height: 9, BitDepth: 8, ColorType: Truecolor, InterlaceMethod: 1}
+	var cases = []struct {
+		ihdr   *IHDR
+		stream []byte
+		want   [5]int
+	}{
+		{gray, stream(gray, func(y int) byte { return []byte{0, 1, 2, 4, 4}[y] }), [5]int{1, 1, 1, 0, 2}},
+		// 19 scanlines over the 7 passes of a 10x9 image
+		{interlaced, stream(interlaced, func(int) byte { return 3 }), [5]int{0, 0, 0, 19, 0}},
+	}
+	for i, c := range cases {
+		p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(c.ihdr), newChunk(IDATChunk, c.stream), newChunk(IENDChunk, nil))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := p.FilterHistogram()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Fatalf("case %d: %v, want %v", i, got, c.want)
+		}
+	}
+
+	p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(gray), newChunk(IDATChunk, stream(gray, func(int) byte { return 5 })), newChunk(IENDChunk, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.FilterHistogram(); err == nil {
+		t.Fatal("filter type 5 accepted")
+	}
+}
+
+func TestVerifyLosslessReencode(t *testing.T) {
+	var rnd = rand.New(rand.NewSource(3))
+	for _, ihdr := range []*IHDR{
+		{Width: 9, Height: 7, BitDepth: 1, ColorType: Grayscale},
+		{Width: 9, Height: 7, BitDepth: 16, ColorType: Grayscale},
+		{Width: 9, Height: 7, BitDepth: 8, ColorType: Truecolor},
+		{Width: 9, Height: 7, BitDepth: 16, ColorType: TruecolorAlpha, InterlaceMethod: 1},
+		{Width: 9, Height: 7, BitDepth: 4, ColorType: Indexed},
+		{Width: 9, Height: 7, BitDepth: 8, ColorType: GrayscaleAlpha},
+	} {
+		for _, trns := range []bool{false, true} {
+			p, err := ParsePng(bytes.NewReader(randomPng(rnd, ihdr, trns)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = p.VerifyLosslessReencode(); err != nil {
+				t.Fatalf("%+v, tRNS %v: %v", ihdr, trns, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,450 @@
+package simple_png
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// APNG  https://wiki.mozilla.org/APNG_Specification
+//
+// An animated png adds an acTL chunk before the first IDAT, then describes every frame with an fcTL
+// chunk followed by its image data. The data of the first frame may be the IDAT chunks, in which
+// case the default image is part of the animation, the data of every other frame is carried by
+// fdAT chunks: a sequence number followed by what an IDAT would hold. fcTL and fdAT chunks share
+// one sequence, starting from 0 with no gaps.
+const (
+	ACTLChunk ChunkName = "acTL"
+	FCTLChunk ChunkName = "fcTL"
+	FDATChunk ChunkName = "fdAT"
+)
+
+// Frame disposal, applied to the frame region once the frame has been displayed.
+const (
+	DisposeOpNone uint8 = iota
+	DisposeOpBackground
+	DisposeOpPrevious
+)
+
+// Frame blending, how the frame region is drawn onto the output buffer.
+const (
+	BlendOpSource uint8 = iota
+	BlendOpOver
+)
+
+// ACTL
+// The animation control chunk declares the png as animated:
+//
+//	num_frames: 4 bytes, number of frames
+//	num_plays:  4 bytes, number of times to loop this APNG, 0 indicates infinite looping
+type ACTL struct {
+	NumFrames uint32
+	NumPlays  uint32
+}
+
+func (a *ACTL) ChunkName() ChunkName {
+	return ACTLChunk
+}
+
+func (a *ACTL) Parse(chunk *chunk) error {
+	if len(chunk.data) < 8 {
+		return errors.New("invalid actl chunk data")
+	}
+	a.NumFrames = by.Uint32(chunk.data[:4])
+	a.NumPlays = by.Uint32(chunk.data[4:8])
+	if a.NumFrames == 0 {
+		return errors.New("actl declares no frame")
+	}
+	return nil
+}
+
+func (a *ACTL) Serialize() ([]byte, error) {
+	var data = make([]byte, 8)
+	by.PutUint32(data[:4], a.NumFrames)
+	by.PutUint32(data[4:], a.NumPlays)
+	return data, nil
+}
+
+// FCTL
+// The frame control chunk precedes the data of the frame it describes:
+//
+//	sequence_number: 4 bytes, sequence number of the animation chunk, starting from 0
+//	width:           4 bytes, width of the following frame
+//	height:          4 bytes, height of the following frame
+//	x_offset:        4 bytes, x position at which to render the following frame
+//	y_offset:        4 bytes, y position at which to render the following frame
+//	delay_num:       2 bytes, frame delay fraction numerator
+//	delay_den:       2 bytes, frame delay fraction denominator
+//	dispose_op:      1 byte, type of frame area disposal to be done after rendering this frame
+//	blend_op:        1 byte, type of frame area rendering for this frame
+type FCTL struct {
+	SequenceNumber uint32
+	Width          uint32
+	Height         uint32
+	XOffset        uint32
+	YOffset        uint32
+	DelayNum       uint16
+	DelayDen       uint16
+	DisposeOp      uint8
+	BlendOp        uint8
+}
+
+func (f *FCTL) ChunkName() ChunkName {
+	return FCTLChunk
+}
+
+func (f *FCTL) Parse(chunk *chunk) error {
+	if len(chunk.data) < 26 {
+		return errors.New("invalid fctl chunk data")
+	}
+	var d = chunk.data
+	f.SequenceNumber = by.Uint32(d[:4])
+	f.Width = by.Uint32(d[4:8])
+	f.Height = by.Uint32(d[8:12])
+	f.XOffset = by.Uint32(d[12:16])
+	f.YOffset = by.Uint32(d[16:20])
+	f.DelayNum = by.Uint16(d[20:22])
+	f.DelayDen = by.Uint16(d[22:24])
+	f.DisposeOp = d[24]
+	f.BlendOp = d[25]
+	if f.DisposeOp > DisposeOpPrevious || f.BlendOp > BlendOpOver {
+		return errors.Errorf("invalid fctl dispose op %d or blend op %d", f.DisposeOp, f.BlendOp)
+	}
+	return nil
+}
+
+func (f *FCTL) Serialize() ([]byte, error) {
+	var data = make([]byte, 26)
+	by.PutUint32(data[:4], f.SequenceNumber)
+	by.PutUint32(data[4:8], f.Width)
+	by.PutUint32(data[8:12], f.Height)
+	by.PutUint32(data[12:16], f.XOffset)
+	by.PutUint32(data[16:20], f.YOffset)
+	by.PutUint16(data[20:22], f.DelayNum)
+	by.PutUint16(data[22:24], f.DelayDen)
+	data[24] = f.DisposeOp
+	data[25] = f.BlendOp
+	return data, nil
+}
+
+// AnimationFrame is one frame of an APNG, Image only covers the frame region and its bounds give
+// the position of the frame on the canvas.
+type AnimationFrame struct {
+	Image     image.Image
+	DelayNum  uint16
+	DelayDen  uint16
+	DisposeOp uint8
+	BlendOp   uint8
+}
+
+// Delay is the time the frame is displayed, a zero denominator stands for 1/100 second.
+func (f *AnimationFrame) Delay() time.Duration {
+	var den = time.Duration(f.DelayDen)
+	if den == 0 {
+		den = 100
+	}
+	return time.Duration(f.DelayNum) * time.Second / den
+}
+
+// IsAnimated reports whether the png is an APNG, that is whether it has an acTL chunk.
+func (p *Png) IsAnimated() bool {
+	p.RLock()
+	defer p.RUnlock()
+	return p.ACTL != nil
+}
+
+// FrameCount returns the number of frames the acTL chunk declares.
+func (p *Png) FrameCount() (int, error) {
+	p.RLock()
+	defer p.RUnlock()
+	if p.ACTL == nil {
+		return 0, errors.New("no acTL found")
+	}
+	return int(p.ACTL.NumFrames), nil
+}
+
+// Frames decodes the animation frames of an APNG in display order. The default image is the first
+// frame only when its fcTL precedes the IDAT chunks, it must then cover the canvas, otherwise the
+// first frame comes from fdAT chunks and may be any region. The number of frames must match acTL.
+// A png without acTL has no frame.
+func (p *Png) Frames() ([]AnimationFrame, error) {
+	p.RLock()
+	defer p.RUnlock()
+	if p.IHDR == nil {
+		return nil, errors.New("no IHDR found")
+	}
+	if err := p.IHDR.checkDecodable(); err != nil {
+		return nil, err
+	}
+	if p.chunkIndex(ACTLChunk) < 0 {
+		return nil, nil
+	}
+	var frames []AnimationFrame
+	var fctl *FCTL
+	var stream []byte
+	// fromIDAT is set when the frame's data is the IDAT chunks, making the default image a frame
+	var fromIDAT bool
+	var seq uint32
+	var flush = func() error {
+		if fctl == nil {
+			return nil
+		}
+		frame, err := p.decodeFrame(fctl, stream, len(frames) == 0, fromIDAT)
+		if err != nil {
+			return err
+		}
+		frames = append(frames, frame)
+		fctl, stream, fromIDAT = nil, nil, false
+		return nil
+	}
+	for _, c := range p.chunks {
+		switch ChunkName(c.code[:]) {
+		case FCTLChunk:
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			fctl = &FCTL{}
+			if err := fctl.Parse(c); err != nil {
+				return nil, err
+			}
+			if fctl.SequenceNumber != seq {
+				return nil, errors.Errorf("fctl sequence number %d, want %d", fctl.SequenceNumber, seq)
+			}
+			seq++
+		case IDATChunk:
+			if fctl != nil {
+				stream = append(stream, c.data...)
+				fromIDAT = true
+			}
+		case FDATChunk:
+			if len(c.data) < 4 {
+				return nil, errors.New("invalid fdat chunk data")
+			}
+			if n := by.Uint32(c.data[:4]); n != seq {
+				return nil, errors.Errorf("fdat sequence number %d, want %d", n, seq)
+			}
+			seq++
+			if fctl == nil {
+				return nil, errors.New("fdat without fctl")
+			}
+			stream = append(stream, c.data[4:]...)
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	if p.ACTL != nil && uint32(len(frames)) != p.ACTL.NumFrames {
+		return nil, errors.Errorf("actl declares %d frames, found %d", p.ACTL.NumFrames, len(frames))
+	}
+	return frames, nil
+}
+
+func (p *Png) decodeFrame(fctl *FCTL, stream []byte, first, fromIDAT bool) (AnimationFrame, error) {
+	var rect = image.Rect(0, 0, int(fctl.Width), int(fctl.Height)).Add(image.Pt(int(fctl.XOffset), int(fctl.YOffset)))
+	var canvas = image.Rect(0, 0, int(p.IHDR.Width), int(p.IHDR.Height))
+	if rect.Empty() || !rect.In(canvas) || uint64(fctl.XOffset)+uint64(fctl.Width) > uint64(p.IHDR.Width) ||
+		uint64(fctl.YOffset)+uint64(fctl.Height) > uint64(p.IHDR.Height) {
+		return AnimationFrame{}, errors.Errorf("frame %v outside the canvas %v", rect, canvas)
+	}
+	if fromIDAT && rect != canvas {
+		return AnimationFrame{}, errors.New("the frame of the default image must cover the canvas")
+	}
+	var ihdr = *p.IHDR
+	ihdr.Width, ihdr.Height = fctl.Width, fctl.Height
+	img, err := p.decodeStream(&ihdr, bytes.NewReader(stream), false)
+	if err != nil {
+		return AnimationFrame{}, err
+	}
+	var dispose = fctl.DisposeOp
+	if first && dispose == DisposeOpPrevious {
+		// there is no previous output for the first frame, the spec treats it as background
+		dispose = DisposeOpBackground
+	}
+	return AnimationFrame{
+		Image:     translate(img, rect.Min),
+		DelayNum:  fctl.DelayNum,
+		DelayDen:  fctl.DelayDen,
+		DisposeOp: dispose,
+		BlendOp:   fctl.BlendOp,
+	}, nil
+}
+
+// translate moves the bounds of an image ToImage produced by pt, without copying the pixels.
+func translate(img image.Image, pt image.Point) image.Image {
+	switch m := img.(type) {
+	case *image.Gray:
+		m.Rect = m.Rect.Add(pt)
+	case *image.Gray16:
+		m.Rect = m.Rect.Add(pt)
+	case *image.RGBA:
+		m.Rect = m.Rect.Add(pt)
+	case *image.RGBA64:
+		m.Rect = m.Rect.Add(pt)
+	case *image.NRGBA:
+		m.Rect = m.Rect.Add(pt)
+	case *image.NRGBA64:
+		m.Rect = m.Rect.Add(pt)
+	case *image.Paletted:
+		m.Rect = m.Rect.Add(pt)
+	default:
+		panic(fmt.Sprintf("translate: unexpected image type %T", img))
+	}
+	return img
+}
+
+// ComposedFrames renders every animation frame onto the canvas, applying the blend and dispose
+// operations, and returns what is displayed for each frame. A png without acTL gives its image alone.
+func (p *Png) ComposedFrames() ([]*image.NRGBA, error) {
+	frames, err := p.Frames()
+	if err != nil {
+		return nil, err
+	}
+	if len(frames) == 0 {
+		img, err := p.ToImage()
+		if err != nil {
+			return nil, err
+		}
+		var still = image.NewNRGBA(img.Bounds())
+		draw.Draw(still, still.Bounds(), img, img.Bounds().Min, draw.Src)
+		return []*image.NRGBA{still}, nil
+	}
+	p.RLock()
+	var canvas = image.NewNRGBA(image.Rect(0, 0, int(p.IHDR.Width), int(p.IHDR.Height)))
+	p.RUnlock()
+	var composed = make([]*image.NRGBA, 0, len(frames))
+	for _, f := range frames {
+		var r = f.Image.Bounds()
+		var previous *image.NRGBA
+		if f.DisposeOp == DisposeOpPrevious {
+			previous = image.NewNRGBA(r)
+			draw.Draw(previous, r, canvas, r.Min, draw.Src)
+		}
+		var op = draw.Src
+		if f.BlendOp == BlendOpOver {
+			op = draw.Over
+		}
+		draw.Draw(canvas, r, f.Image, r.Min, op)
+		var out = image.NewNRGBA(canvas.Bounds())
+		copy(out.Pix, canvas.Pix)
+		composed = append(composed, out)
+		switch f.DisposeOp {
+		case DisposeOpBackground:
+			draw.Draw(canvas, r, image.Transparent, image.Point{}, draw.Src)
+		case DisposeOpPrevious:
+			draw.Draw(canvas, r, previous, r.Min, draw.Src)
+		}
+	}
+	return composed, nil
+}
+
+// ContactSheet lays the composed animation frames out in a grid of cols columns, left to right
+// then top to bottom, for a static preview of an APNG.
+func (p *Png) ContactSheet(cols int) (image.Image, error) {
+	if cols <= 0 {
+		return nil, errors.New("invalid column count")
+	}
+	frames, err := p.ComposedFrames()
+	if err != nil {
+		return nil, err
+	}
+	var size = frames[0].Bounds().Size()
+	var rows = (len(frames) + cols - 1) / cols
+	var sheet = image.NewNRGBA(image.Rect(0, 0, size.X*min(cols, len(frames)), size.Y*rows))
+	for i, f := range frames {
+		var at = image.Pt(i%cols*size.X, i/cols*size.Y)
+		draw.Draw(sheet, image.Rectangle{Min: at, Max: at.Add(size)}, f, f.Bounds().Min, draw.Src)
+	}
+	return sheet, nil
+}
+
+// NewAPNG assembles an animated png from frames, the first frame is the default image and
+// covers the canvas, the bounds of the others give their position on it. All the frames are
+// stored truecolor, with alpha unless every frame is opaque and 16 bit if a frame is.
+func NewAPNG(frames []AnimationFrame) (*Png, error) {
+	if len(frames) == 0 {
+		return nil, errors.New("no frame")
+	}
+	var canvas = frames[0].Image.Bounds()
+	if canvas.Empty() {
+		return nil, errors.New("invalid image size")
+	}
+	var deep, opaque = false, true
+	for i, f := range frames {
+		var r = f.Image.Bounds()
+		if r.Empty() || !r.In(canvas) {
+			return nil, errors.Errorf("frame %d: %v outside the canvas %v", i, r, canvas)
+		}
+		if f.DisposeOp > DisposeOpPrevious || f.BlendOp > BlendOpOver {
+			return nil, errors.Errorf("frame %d: invalid dispose op %d or blend op %d", i, f.DisposeOp, f.BlendOp)
+		}
+		deep = deep || isDeep(f.Image)
+		opaque = opaque && isOpaque(f.Image)
+	}
+	var ihdr = &IHDR{Width: uint32(canvas.Dx()), Height: uint32(canvas.Dy()), BitDepth: 8, ColorType: TruecolorAlpha}
+	if opaque {
+		ihdr.ColorType = Truecolor
+	}
+	if deep {
+		ihdr.BitDepth = 16
+	}
+	p, err := newPngFromRaster(ihdr, nil, nil, truecolorRows(frames[0].Image, deep, opaque))
+	if err != nil {
+		return nil, err
+	}
+	p.ACTL = &ACTL{NumFrames: uint32(len(frames))}
+
+	// IHDR, acTL, fcTL, IDAT, then fcTL and fdAT for every other frame, and IEND
+	var chunks = []*chunk{p.chunks[0]}
+	var seq uint32
+	var control = func(f AnimationFrame) error {
+		var r = f.Image.Bounds().Sub(canvas.Min)
+		c, err := serializeChunk(&FCTL{
+			SequenceNumber: seq,
+			Width:          uint32(r.Dx()),
+			Height:         uint32(r.Dy()),
+			XOffset:        uint32(r.Min.X),
+			YOffset:        uint32(r.Min.Y),
+			DelayNum:       f.DelayNum,
+			DelayDen:       f.DelayDen,
+			DisposeOp:      f.DisposeOp,
+			BlendOp:        f.BlendOp,
+		})
+		seq++
+		chunks = append(chunks, c)
+		return err
+	}
+	actl, err := serializeChunk(p.ACTL)
+	if err != nil {
+		return nil, err
+	}
+	chunks = append(chunks, actl)
+	if err = control(frames[0]); err != nil {
+		return nil, err
+	}
+	chunks = append(chunks, p.chunks[1:len(p.chunks)-1]...)
+	for _, f := range frames[1:] {
+		if err = control(f); err != nil {
+			return nil, err
+		}
+		var r = f.Image.Bounds()
+		var frameIHDR = *ihdr
+		frameIHDR.Width, frameIHDR.Height = uint32(r.Dx()), uint32(r.Dy())
+		stream, err := compressRaster(&frameIHDR, truecolorRows(f.Image, deep, opaque))
+		if err != nil {
+			return nil, err
+		}
+		if len(stream)+4 > maxChunkLength {
+			return nil, errors.New("chunk data too long")
+		}
+		var data = make([]byte, 4, 4+len(stream))
+		by.PutUint32(data, seq)
+		chunks = append(chunks, newChunk(FDATChunk, append(data, stream...)))
+		seq++
+	}
+	p.chunks = append(chunks, p.chunks[len(p.chunks)-1])
+	return p, nil
+}
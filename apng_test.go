@@ -0,0 +1,241 @@
+package simple_png
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+	"time"
+)
+
+// solidSamples returns w*h pixels of the single sample set px.
+func solidSamples(w, h int, px ...uint16) [][]uint16 {
+	var rows = make([][]uint16, h)
+	for y := range rows {
+		for x := 0; x < w; x++ {
+			rows[y] = append(rows[y], px...)
+		}
+	}
+	return rows
+}
+
+func fctlChunk(f FCTL) *chunk {
+	data, _ := f.Serialize()
+	return newChunk(FCTLChunk, data)
+}
+
+func fdatChunk(seq uint32, data []byte) *chunk {
+	return newChunk(FDATChunk, append(binary.BigEndian.AppendUint32(nil, seq), data...))
+}
+
+// testAPNG is a 4x4 animation: an opaque red default image, then a half transparent blue 2x2
+// square blended over it, disposed to the previous output, then a green 1x1 pixel.
+func testAPNG() []byte {
+	return buildPng(testAPNGChunks(4)...)
+}
+
+// testAPNGChunks gives the chunks of testAPNG, lastSeq is the sequence number of the last fdAT.
+func testAPNGChunks(lastSeq uint32) []*chunk {
+	var ihdr = &IHDR{Width: 4, Height: 4, BitDepth: 8, ColorType: TruecolorAlpha}
+	var square = &IHDR{Width: 2, Height: 2, BitDepth: 8, ColorType: TruecolorAlpha}
+	var pixel = &IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: TruecolorAlpha}
+	actl, _ := (&ACTL{NumFrames: 3}).Serialize()
+	return []*chunk{ihdrChunk(ihdr), newChunk(ACTLChunk, actl),
+		fctlChunk(FCTL{SequenceNumber: 0, Width: 4, Height: 4, DelayNum: 1, DelayDen: 10}),
+		newChunk(IDATChunk, encodeSamples(ihdr, solidSamples(4, 4, 0xff, 0, 0, 0xff))),
+		fctlChunk(FCTL{SequenceNumber: 1, Width: 2, Height: 2, XOffset: 1, YOffset: 1, DelayNum: 50,
+			DisposeOp: DisposeOpPrevious, BlendOp: BlendOpOver}),
+		fdatChunk(2, encodeSamples(square, solidSamples(2, 2, 0, 0, 0xff, 0x80))),
+		fctlChunk(FCTL{SequenceNumber: 3, Width: 1, Height: 1, XOffset: 3, YOffset: 0, DelayNum: 1, DelayDen: 1}),
+		fdatChunk(lastSeq, encodeSamples(pixel, solidSamples(1, 1, 0, 0xff, 0, 0xff))),
+		newChunk(IENDChunk, nil)}
+}
+
+func TestFrames(t *testing.T) {
+	p, err := ParsePng(bytes.NewReader(testAPNG()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := p.FrameCount(); !p.IsAnimated() || err != nil || n != 3 {
+		t.Fatalf("acTL %+v, frame count %d, %v", p.ACTL, n, err)
+	}
+	frames, err := p.Frames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 3 {
+		t.Fatalf("%d frames", len(frames))
+	}
+	var delays = []time.Duration{100 * time.Millisecond, 500 * time.Millisecond, time.Second}
+	var bounds = []image.Rectangle{image.Rect(0, 0, 4, 4), image.Rect(1, 1, 3, 3), image.Rect(3, 0, 4, 1)}
+	for i, f := range frames {
+		if f.Delay() != delays[i] || f.Image.Bounds() != bounds[i] {
+			t.Fatalf("frame %d: delay %v, bounds %v", i, f.Delay(), f.Image.Bounds())
+		}
+	}
+
+	composed, err := p.ComposedFrames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var red = color.NRGBA{R: 0xff, A: 0xff}
+	var green = color.NRGBA{G: 0xff, A: 0xff}
+	if c := composed[0].NRGBAAt(1, 1); c != red {
+		t.Fatalf("frame 0 (1,1) = %v", c)
+	}
+	if c := composed[1].NRGBAAt(1, 1); c.R == 0 || c.B == 0 || c.A != 0xff {
+		t.Fatalf("frame 1 (1,1) = %v, want blue over red", c)
+	}
+	// the square is disposed to the previous output
+	if c := composed[2].NRGBAAt(1, 1); c != red {
+		t.Fatalf("frame 2 (1,1) = %v", c)
+	}
+	if c := composed[2].NRGBAAt(3, 0); c != green {
+		t.Fatalf("frame 2 (3,0) = %v", c)
+	}
+
+	sheet, err := p.ContactSheet(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sheet.Bounds() != image.Rect(0, 0, 8, 8) {
+		t.Fatalf("contact sheet bounds %v", sheet.Bounds())
+	}
+	for i, f := range composed {
+		var at = image.Pt(i%2*4, i/2*4)
+		for y := 0; y < 4; y++ {
+			for x := 0; x < 4; x++ {
+				if c := sheet.At(at.X+x, at.Y+y); c != f.At(x, y) {
+					t.Fatalf("frame %d (%d,%d): sheet %v, want %v", i, x, y, c, f.At(x, y))
+				}
+			}
+		}
+	}
+}
+
+func TestFramesSequence(t *testing.T) {
+	for _, seq := range []uint32{3, 5} {
+		p, err := ParsePng(bytes.NewReader(buildPng(testAPNGChunks(seq)...)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = p.Frames(); err == nil {
+			t.Fatalf("fdAT sequence number %d accepted", seq)
+		}
+	}
+
+	p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(&IHDR{Width: 2, Height: 2, BitDepth: 8, ColorType: Grayscale}),
+		blankIDAT(&IHDR{Width: 2, Height: 2, BitDepth: 8, ColorType: Grayscale}), newChunk(IENDChunk, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.FrameCount(); p.IsAnimated() || err == nil {
+		t.Fatal("still png is animated")
+	}
+	if frames, err := p.Frames(); err != nil || frames != nil {
+		t.Fatalf("still png: %v frames, %v", len(frames), err)
+	}
+	sheet, err := p.ContactSheet(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sheet.Bounds() != image.Rect(0, 0, 2, 2) {
+		t.Fatalf("still png contact sheet %v", sheet.Bounds())
+	}
+}
+
+func TestFramesWithoutDefaultImage(t *testing.T) {
+	var ihdr = &IHDR{Width: 4, Height: 4, BitDepth: 8, ColorType: TruecolorAlpha}
+	var square = &IHDR{Width: 2, Height: 2, BitDepth: 8, ColorType: TruecolorAlpha}
+	var pixel = &IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: TruecolorAlpha}
+	var chunks = func(numFrames uint32) []*chunk {
+		actl, _ := (&ACTL{NumFrames: numFrames}).Serialize()
+		// the default image isn't part of the animation, the first frame doesn't cover the canvas
+		return []*chunk{ihdrChunk(ihdr), newChunk(ACTLChunk, actl),
+			newChunk(IDATChunk, encodeSamples(ihdr, solidSamples(4, 4, 0xff, 0, 0, 0xff))),
+			fctlChunk(FCTL{SequenceNumber: 0, Width: 2, Height: 2, XOffset: 1, YOffset: 1, DelayNum: 1, DelayDen: 10}),
+			fdatChunk(1, encodeSamples(square, solidSamples(2, 2, 0, 0, 0xff, 0xff))),
+			fctlChunk(FCTL{SequenceNumber: 2, Width: 1, Height: 1, DelayNum: 1, DelayDen: 10}),
+			fdatChunk(3, encodeSamples(pixel, solidSamples(1, 1, 0, 0xff, 0, 0xff))),
+			newChunk(IENDChunk, nil)}
+	}
+	p, err := ParsePng(bytes.NewReader(buildPng(chunks(2)...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, err := p.Frames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 2 || frames[0].Image.Bounds() != image.Rect(1, 1, 3, 3) || frames[1].Image.Bounds() != image.Rect(0, 0, 1, 1) {
+		t.Fatalf("%d frames", len(frames))
+	}
+	composed, err := p.ComposedFrames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if composed[0].Bounds() != image.Rect(0, 0, 4, 4) {
+		t.Fatalf("canvas %v", composed[0].Bounds())
+	}
+	if c := composed[0].NRGBAAt(1, 1); c != (color.NRGBA{B: 0xff, A: 0xff}) {
+		t.Fatalf("frame 0 (1,1) = %v", c)
+	}
+	if c := composed[1].NRGBAAt(0, 0); c != (color.NRGBA{G: 0xff, A: 0xff}) {
+		t.Fatalf("frame 1 (0,0) = %v", c)
+	}
+	if c := composed[0].NRGBAAt(0, 0); c != (color.NRGBA{}) {
+		t.Fatalf("frame 0 (0,0) = %v, the default image shows through", c)
+	}
+
+	if p, err = ParsePng(bytes.NewReader(buildPng(chunks(3)...))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.Frames(); err == nil {
+		t.Fatal("frame count not matching acTL accepted")
+	}
+}
+
+func TestNewAPNG(t *testing.T) {
+	var frames []AnimationFrame
+	for i, r := range []image.Rectangle{image.Rect(0, 0, 6, 5), image.Rect(2, 1, 5, 4), image.Rect(0, 4, 6, 5)} {
+		var m = image.NewNRGBA(r)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				m.SetNRGBA(x, y, color.NRGBA{R: uint8(40 * i), G: uint8(x * 30), B: uint8(y * 40), A: uint8(255 - 60*i)})
+			}
+		}
+		frames = append(frames, AnimationFrame{Image: m, DelayNum: uint16(i + 1), DelayDen: 30, BlendOp: uint8(i % 2)})
+	}
+	p, err := NewAPNG(frames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = p.WritePng(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	q, err := ParsePng(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := q.FrameCount(); err != nil || n != len(frames) {
+		t.Fatalf("frame count %d, %v", n, err)
+	}
+	got, err := q.Frames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(frames) {
+		t.Fatalf("%d frames", len(got))
+	}
+	for i, f := range got {
+		if f.Delay() != frames[i].Delay() || f.BlendOp != frames[i].BlendOp {
+			t.Fatalf("frame %d: delay %v, blend op %d", i, f.Delay(), f.BlendOp)
+		}
+		assertSamePixels(t, frames[i].Image, f.Image)
+	}
+}
@@ -1,10 +1,17 @@
 package simple_png
 
 import (
+	"bytes"
+	"compress/zlib"
 	"encoding/binary"
-	"errors"
+	"io"
+	"math"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
 )
 
 // png format  https://www.w3.org/TR/PNG-Chunks.html
@@ -25,13 +32,62 @@ const (
 	SBITChunk ChunkName = "sBIT"
 	TRNSChunk ChunkName = "tRNS"
 	PHYSChunk ChunkName = "pHYs"
+	OFFSChunk ChunkName = "oFFs"
+	SCALChunk ChunkName = "sCAL"
 	TEXTChunk ChunkName = "tEXt"
-	ZTXTChunk ChunkName = "zTXT"
+	ZTXTChunk ChunkName = "zTXt"
+	ITXTChunk ChunkName = "iTXt"
 	TIMEChunk ChunkName = "tIME"
+	PCALChunk ChunkName = "pCAL"
+	ICCPChunk ChunkName = "iCCP"
 )
 
+// Chunk properties are the bit 5 of each byte of the chunk name, set for lowercase letters:
+//
+//	ancillary bit:    first byte, 0 (uppercase) = critical, 1 (lowercase) = ancillary
+//	private bit:      second byte, 0 (uppercase) = public, 1 (lowercase) = private
+//	reserved bit:     third byte, must be 0 (uppercase) in files conforming to this version of PNG
+//	safe-to-copy bit: fourth byte, 0 (uppercase) = unsafe to copy, 1 (lowercase) = safe to copy
+func (n ChunkName) propertyBit(i int) bool {
+	return len(n) > i && n[i]&0x20 != 0
+}
+
+// IsValidTypeCode reports whether n is four ASCII letters, the only chunk type codes the spec
+// allows. Corrupt files can carry any bytes there, the parser keeps such chunks as they are.
+func (n ChunkName) IsValidTypeCode() bool {
+	if len(n) != 4 {
+		return false
+	}
+	for i := 0; i < 4; i++ {
+		if c := n[i] | 0x20; c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+// IsCritical reports whether the chunk is necessary for successful display of the file.
+func (n ChunkName) IsCritical() bool {
+	return !n.propertyBit(0)
+}
+
+// IsPublic reports whether the chunk is part of the specification or registered.
+func (n ChunkName) IsPublic() bool {
+	return !n.propertyBit(1)
+}
+
+// IsReserved reports whether the reserved bit is set, such chunks don't conform to the specification.
+func (n ChunkName) IsReserved() bool {
+	return n.propertyBit(2)
+}
+
+// IsSafeToCopy reports whether an editor that doesn't recognize the chunk may copy it to a
+// modified file, whatever the extent of the modifications.
+func (n ChunkName) IsSafeToCopy() bool {
+	return n.propertyBit(3)
+}
+
 // ISO_3309_CRC x32+x26+x23+x22+x16+x12+x11+x10+x8+x7+x5+x4+x2+x+1
-// TODO implement crc
 var ISO_3309_CRC = []uint{1, 1, 0, 1, 1, 0, 1, 1, 0, 1, 1, 1, 0, 0, 0, 1, 0, 0, 0, 0, 0, 1, 1, 0, 0, 1, 0, 0, 0, 0, 0, 1}
 
 type ChunkParse interface {
@@ -39,11 +95,23 @@ type ChunkParse interface {
 	Parse(chunk *chunk) error
 }
 
+// ChunkSerialize is the write side of ChunkParse, Serialize returns the chunk data field.
+type ChunkSerialize interface {
+	ChunkName() ChunkName
+	Serialize() ([]byte, error)
+}
+
 type chunk struct {
 	len  [4]byte
 	code [4]byte
 	data []byte
 	crc  [4]byte
+	// offset of the length field from the start of the input, -1 if the chunk wasn't read from it
+	offset int64
+	// raw is the whole chunk as read, kept by the KeepRaw option
+	raw []byte
+	// dirty is set when data was edited in place after crc was computed, writeTo then writes a fresh crc
+	dirty bool
 }
 
 /*
@@ -96,7 +164,7 @@ type IHDR struct {
 	Width             uint32
 	Height            uint32
 	BitDepth          uint8
-	ColorType         uint8
+	ColorType         ColorType
 	CompressionMethod uint8
 	FilterMethod      uint8
 	InterlaceMethod   uint8
@@ -109,17 +177,102 @@ func (c *IHDR) Parse(chunk *chunk) error {
 	c.Width = by.Uint32(chunk.data[:4])
 	c.Height = by.Uint32(chunk.data[4:8])
 	c.BitDepth = chunk.data[8]
-	c.ColorType = chunk.data[9]
+	c.ColorType = ColorType(chunk.data[9])
 	c.CompressionMethod = chunk.data[10]
 	c.FilterMethod = chunk.data[11]
 	c.InterlaceMethod = chunk.data[12]
-	return nil
+	if err := c.checkSize(); err != nil {
+		return err
+	}
+	return checkCompressionMethod(IHDRChunk, c.CompressionMethod)
 }
 
 func (c *IHDR) ChunkName() ChunkName {
 	return IHDRChunk
 }
 
+// checkSize rejects the dimensions the spec disallows, width and height range from 1 to 2^31-1.
+func (c *IHDR) checkSize() error {
+	if c.Width == 0 || c.Height == 0 {
+		return errors.Errorf("invalid image size %dx%d, zero dimension", c.Width, c.Height)
+	}
+	if c.Width > math.MaxInt32 || c.Height > math.MaxInt32 {
+		return errors.Errorf("invalid image size %dx%d, dimension above 2^31-1", c.Width, c.Height)
+	}
+	return nil
+}
+
+func (c *IHDR) Serialize() ([]byte, error) {
+	var data = make([]byte, 13)
+	by.PutUint32(data[:4], c.Width)
+	by.PutUint32(data[4:8], c.Height)
+	data[8] = c.BitDepth
+	data[9] = uint8(c.ColorType)
+	data[10] = c.CompressionMethod
+	data[11] = c.FilterMethod
+	data[12] = c.InterlaceMethod
+	return data, nil
+}
+
+// ColorType
+// Color type codes represent sums of the following values: 1 (palette used), 2 (color used), and 4 (alpha channel used).
+type ColorType uint8
+
+const (
+	Grayscale      ColorType = 0
+	Truecolor      ColorType = 2
+	Indexed        ColorType = 3
+	GrayscaleAlpha ColorType = 4
+	TruecolorAlpha ColorType = 6
+)
+
+func (c ColorType) String() string {
+	switch c {
+	case Grayscale:
+		return "grayscale"
+	case Truecolor:
+		return "truecolor"
+	case Indexed:
+		return "indexed-color"
+	case GrayscaleAlpha:
+		return "grayscale with alpha"
+	case TruecolorAlpha:
+		return "truecolor with alpha"
+	}
+	return "unknown"
+}
+
+// Channels is the number of samples per pixel, 0 for an unknown color type.
+func (c *IHDR) Channels() int {
+	switch c.ColorType {
+	case Grayscale, Indexed:
+		return 1
+	case GrayscaleAlpha:
+		return 2
+	case Truecolor:
+		return 3
+	case TruecolorAlpha:
+		return 4
+	}
+	return 0
+}
+
+// BitsPerPixel is the number of bits used by one pixel in a scanline.
+func (c *IHDR) BitsPerPixel() int {
+	return c.Channels() * int(c.BitDepth)
+}
+
+// BytesPerPixel is the number of bytes per complete pixel rounding up to one,
+// the bpp used by the filter algorithms.
+func (c *IHDR) BytesPerPixel() int {
+	return (c.BitsPerPixel() + 7) / 8
+}
+
+// rowBytes is the length of an unfiltered scanline of width pixels, without the filter type byte.
+func (c *IHDR) rowBytes(width int) int {
+	return (width*c.BitsPerPixel() + 7) / 8
+}
+
 /*
 
 --------------------------------------------------------------------------------------
@@ -173,11 +326,22 @@ func (p *PLTE) ChunkName() ChunkName {
 	return PLTEChunk
 }
 
+func (p *PLTE) Serialize() ([]byte, error) {
+	if len(p.Colors) == 0 || len(p.Colors) > 256 {
+		return nil, errors.New("invalid plte colors")
+	}
+	var data = make([]byte, 0, len(p.Colors)*3)
+	for _, c := range p.Colors {
+		data = append(data, c.Red, c.Green, c.Blue)
+	}
+	return data, nil
+}
+
 func (p *PLTE) Parse(chunk *chunk) error {
 	if chunk.data == nil || len(chunk.data)%3 != 0 || len(chunk.data) < 3 {
 		return errors.New("invalid plte chunk data")
 	}
-	for i := 0; i < len(chunk.data)-3; i += 3 {
+	for i := 0; i+3 <= len(chunk.data); i += 3 {
 		var pc = &PLTEColor{}
 		pc.Red = chunk.data[i]
 		pc.Green = chunk.data[i+1]
@@ -215,6 +379,10 @@ func (i *IDAT) ChunkName() ChunkName {
 	return IDATChunk
 }
 
+func (i *IDAT) Serialize() ([]byte, error) {
+	return i.Data, nil
+}
+
 func (i *IDAT) Parse(chunk *chunk) error {
 	i.Length = by.Uint32(chunk.len[:])
 	i.ChunkTypeCode = string(chunk.code[:])
@@ -262,18 +430,50 @@ func (b *BKGD) ChunkName() ChunkName {
 	return BKGDChunk
 }
 
+// Parse fills the fields matching the chunk length, the palette index for 1 byte, gray for 2 bytes
+// and red, green and blue for 6 bytes.
 func (b *BKGD) Parse(chunk *chunk) error {
-	if chunk.data == nil || len(chunk.data) < 9 {
+	switch len(chunk.data) {
+	case 1:
+		b.Palette = chunk.data[0]
+	case 2:
+		b.Gray = by.Uint16(chunk.data)
+	case 6:
+		b.Red = by.Uint16(chunk.data[:2])
+		b.Green = by.Uint16(chunk.data[2:4])
+		b.Blue = by.Uint16(chunk.data[4:6])
+	default:
 		return errors.New("invalid bkgd chunk data")
 	}
-	b.Palette = chunk.data[0]
-	b.Gray = by.Uint16(chunk.data[1:3])
-	b.Red = by.Uint16(chunk.data[3:5])
-	b.Green = by.Uint16(chunk.data[5:7])
-	b.Blue = by.Uint16(chunk.data[7:9])
 	return nil
 }
 
+// SerializeFor encodes the fields matching the color type of ihdr, samples always take 2 bytes.
+func (b *BKGD) SerializeFor(ihdr *IHDR) ([]byte, error) {
+	switch ihdr.ColorType {
+	case Indexed:
+		return []byte{b.Palette}, nil
+	case Grayscale, GrayscaleAlpha:
+		return serializeSamples(ihdr, b.Gray)
+	case Truecolor, TruecolorAlpha:
+		return serializeSamples(ihdr, b.Red, b.Green, b.Blue)
+	}
+	return nil, errors.Errorf("invalid color type %d", ihdr.ColorType)
+}
+
+// serializeSamples encodes samples as 2 bytes each, checking they fit the bit depth of ihdr.
+func serializeSamples(ihdr *IHDR, samples ...uint16) ([]byte, error) {
+	var max = uint32(1)<<ihdr.BitDepth - 1
+	var data = make([]byte, 0, 2*len(samples))
+	for _, v := range samples {
+		if uint32(v) > max {
+			return nil, errors.Errorf("sample %#04x out of range for bit depth %d", v, ihdr.BitDepth)
+		}
+		data = append(data, uint8(v>>8), uint8(v))
+	}
+	return data, nil
+}
+
 /*
 
 --------------------------------------------------------------------------------------
@@ -397,7 +597,7 @@ func (h *HIST) Parse(chunk *chunk) error {
 	if len(chunk.data) == 0 {
 		return nil
 	}
-	for i := 0; i < len(h.Elements)-2; i += 2 {
+	for i := 0; i+2 <= len(chunk.data); i += 2 {
 		h.Elements = append(h.Elements, by.Uint16(chunk.data[i:i+2]))
 	}
 	return nil
@@ -430,9 +630,9 @@ func (h *HIST) Parse(chunk *chunk) error {
 //
 // See Recommendations for Decoders: Pixel dimensions.
 type PHYS struct {
-	X             uint32
-	Y             uint32
-	UnitSpecifier uint8
+	X             uint32 `png:"0,4"`
+	Y             uint32 `png:"4,4"`
+	UnitSpecifier uint8  `png:"8,1"`
 }
 
 func (p *PHYS) ChunkName() ChunkName {
@@ -443,9 +643,17 @@ func (p *PHYS) Parse(chunk *chunk) error {
 	if chunk.data == nil || len(chunk.data) < 9 {
 		return errors.New("invalid phys chunk data")
 	}
-	p.X = by.Uint32(chunk.data[:4])
-	p.Y = by.Uint32(chunk.data[4:8])
-	p.UnitSpecifier = chunk.data[8]
+	if err := checkPhysUnit(chunk.data[8]); err != nil {
+		return err
+	}
+	return ParseFixedLayout(chunk.data, p)
+}
+
+// checkPhysUnit rejects the pHYs unit specifiers the spec doesn't define, only 0 and 1 are.
+func checkPhysUnit(unit uint8) error {
+	if unit > 1 {
+		return errors.Errorf("unknown phys unit specifier %d", unit)
+	}
 	return nil
 }
 
@@ -455,6 +663,128 @@ func (p *PHYS) Parse(chunk *chunk) error {
 
 */
 
+// OFFS
+//
+// The oFFs chunk gives the position on a printed page at which the image should be output when printed alone. It can also be used to define the image's location with respect to a larger screen or other application-specific coordinate system.
+//
+//	X position:     4 bytes (signed integer)
+//	Y position:     4 bytes (signed integer)
+//	Unit specifier: 1 byte
+//
+// Both position values are signed, unlike most png integers. The following values are legal for the unit specifier:
+//
+//	0: unit is the pixel (true dimensions unspecified)
+//	1: unit is the micrometer
+//
+// If present, this chunk must precede the first IDAT chunk.
+type OFFS struct {
+	X             int32 `png:"0,4"`
+	Y             int32 `png:"4,4"`
+	UnitSpecifier uint8 `png:"8,1"`
+}
+
+func (o *OFFS) ChunkName() ChunkName {
+	return OFFSChunk
+}
+
+func (o *OFFS) Parse(chunk *chunk) error {
+	if len(chunk.data) < 9 {
+		return errors.New("invalid offs chunk data")
+	}
+	return ParseFixedLayout(chunk.data, o)
+}
+
+func (o *OFFS) Serialize() ([]byte, error) {
+	var data = make([]byte, 9)
+	by.PutUint32(data[:4], uint32(o.X))
+	by.PutUint32(data[4:8], uint32(o.Y))
+	data[8] = o.UnitSpecifier
+	return data, nil
+}
+
+/*
+
+--------------------------------------------------------------------------------------
+
+*/
+
+// SCAL
+//
+// The sCAL chunk gives the actual physical dimensions of the subject matter of the image, which may differ from the size at which it is displayed or printed.
+//
+//	Unit specifier:     1 byte
+//	Pixel width:        1 or more bytes (ASCII floating-point)
+//	Null separator:     1 byte
+//	Pixel height:       1 or more bytes (ASCII floating-point)
+//
+// The following values are legal for the unit specifier:
+//
+//	1: unit is the meter
+//	2: unit is the radian
+//
+// Both values are strictly positive. If present, this chunk must precede the first IDAT chunk.
+type SCAL struct {
+	UnitSpecifier uint8
+	// PixelWidth and PixelHeight are the size of one pixel in the unit
+	PixelWidth  float64
+	PixelHeight float64
+}
+
+func (s *SCAL) ChunkName() ChunkName {
+	return SCALChunk
+}
+
+func (s *SCAL) Parse(chunk *chunk) error {
+	if len(chunk.data) < 4 {
+		return errors.New("invalid scal chunk data")
+	}
+	unit := chunk.data[0]
+	if unit != 1 && unit != 2 {
+		return errors.Errorf("invalid scal unit specifier %d", unit)
+	}
+	width, height, ok := bytes.Cut(chunk.data[1:], []byte(nullSep))
+	if !ok {
+		return errors.New("invalid scal chunk data")
+	}
+	w, err := parseScale(width)
+	if err != nil {
+		return err
+	}
+	h, err := parseScale(height)
+	if err != nil {
+		return err
+	}
+	s.UnitSpecifier, s.PixelWidth, s.PixelHeight = unit, w, h
+	return nil
+}
+
+// parseScale parses an sCAL value, a strictly positive finite number in ASCII.
+func parseScale(b []byte) (float64, error) {
+	v, err := strconv.ParseFloat(string(b), 64)
+	if err != nil || !(v > 0) || math.IsInf(v, 1) {
+		return 0, errors.Errorf("invalid scal value %q", b)
+	}
+	return v, nil
+}
+
+func (s *SCAL) Serialize() ([]byte, error) {
+	if s.UnitSpecifier != 1 && s.UnitSpecifier != 2 {
+		return nil, errors.Errorf("invalid scal unit specifier %d", s.UnitSpecifier)
+	}
+	if !(s.PixelWidth > 0) || !(s.PixelHeight > 0) || math.IsInf(s.PixelWidth, 1) || math.IsInf(s.PixelHeight, 1) {
+		return nil, errors.New("scal values must be positive and finite")
+	}
+	var data = strconv.AppendFloat([]byte{s.UnitSpecifier}, s.PixelWidth, 'g', -1, 64)
+	data = strconv.AppendFloat(append(data, 0), s.PixelHeight, 'g', -1, 64)
+	return data, nil
+}
+
+/*
+
+--------------------------------------------------------------------------------------
+
+*/
+
 // SBIT
 //
 // To simplify decoders, PNG specifies that only certain sample depths can be used, and further specifies that sample values should be scaled to the full range of possible values at the sample depth. However, the sBIT chunk is provided in order to store the original number of significant bits. This allows decoders to recover the original data losslessly even if the data had a sample depth not directly supported by PNG. We recommend that an encoder emit an sBIT chunk if it has converted the data from a lower sample depth.
@@ -483,6 +813,23 @@ func (s *SBIT) ChunkName() ChunkName {
 	return SBITChunk
 }
 
+// SerializeFor encodes one significant bit count per channel of ihdr, red, green and blue for indexed-color.
+func (s *SBIT) SerializeFor(ihdr *IHDR) ([]byte, error) {
+	var n, depth = ihdr.Channels(), ihdr.BitDepth
+	if ihdr.ColorType == Indexed {
+		n, depth = 3, 8
+	}
+	if n == 0 {
+		return nil, errors.Errorf("invalid color type %d", ihdr.ColorType)
+	}
+	for _, bits := range s.OrgData[:n] {
+		if bits == 0 || bits > depth {
+			return nil, errors.Errorf("sbit %d out of range for sample depth %d", bits, depth)
+		}
+	}
+	return append([]byte(nil), s.OrgData[:n]...), nil
+}
+
 func (s *SBIT) Parse(chunk *chunk) error {
 	if chunk.data == nil || len(chunk.data) < 1 {
 		return errors.New("invalid sbit chunk data")
@@ -524,16 +871,46 @@ func (t *TEXT) ChunkName() ChunkName {
 
 const nullSep = string(byte(0x00))
 
+// ErrTextNull is returned for a tEXt whose text holds a null character, only the separator may be one.
+var ErrTextNull = errors.New("text must not contain a null character")
+
+func (t *TEXT) Serialize() ([]byte, error) {
+	if err := checkKeyword(t.Keyword); err != nil {
+		return nil, err
+	}
+	if strings.Contains(t.Text, nullSep) {
+		return nil, ErrTextNull
+	}
+	return append(append([]byte(t.Keyword), 0), t.Text...), nil
+}
+
+// checkKeyword the keyword must be at least one character and less than 80 characters long, without null character.
+func checkKeyword(keyword string) error {
+	if len(keyword) < 1 || len(keyword) > 79 {
+		return errors.New("keyword must be 1-79 bytes")
+	}
+	if strings.Contains(keyword, nullSep) {
+		return errors.New("keyword must not contain a null character")
+	}
+	return nil
+}
+
+// Parse splits the data at the first null character, the keyword can't hold one by construction
+// and one in the text is ErrTextNull. The text may be empty and is kept byte for byte.
 func (t *TEXT) Parse(chunk *chunk) error {
-	str := strings.TrimSpace(string(chunk.data[:]))
-	strs := strings.Split(str, nullSep)
-	if len(strs) != 2 {
-		return errors.New("invalid text")
+	keyword, text, ok := bytes.Cut(chunk.data, []byte(nullSep))
+	if !ok {
+		return errors.New("invalid text chunk data, no null separator")
 	}
-	t.Keyword = strs[0]
+	if len(keyword) == 0 {
+		return errors.New("invalid text chunk data, empty keyword")
+	}
+	if bytes.Contains(text, []byte(nullSep)) {
+		return ErrTextNull
+	}
+	t.Keyword = string(keyword)
 	t.Separator = " "
-	t.Text = strs[1]
-
+	t.Text = string(text)
 	return nil
 }
 
@@ -571,15 +948,21 @@ func (t *TIME) ChunkName() ChunkName {
 }
 
 func (t *TIME) Parse(chunk *chunk) error {
+	if len(chunk.data) < 7 {
+		return errors.New("invalid time chunk data")
+	}
+	var month, day, hour, minute, second = chunk.data[2], chunk.data[3], chunk.data[4], chunk.data[5], chunk.data[6]
+	if month < 1 || month > 12 || day < 1 || day > 31 || hour > 23 || minute > 59 || second > 60 {
+		return errors.Errorf("invalid time %d-%02d-%02d %02d:%02d:%02d", by.Uint16(chunk.data[:2]), month, day, hour, minute, second)
+	}
 	t.Year = by.Uint16(chunk.data[:2])
-	t.Month = chunk.data[2]
-	t.Day = chunk.data[3]
-	t.Hour = chunk.data[4]
-	t.Minute = chunk.data[5]
-	t.Second = chunk.data[6]
+	t.Month, t.Day, t.Hour, t.Minute, t.Second = month, day, hour, minute, second
 	return nil
 }
 
+// ToTime returns the time in UTC. time.Time has no leap seconds, a Second of 60 is normalized
+// like time.Date does to second 0 of the next minute, 23:59:60 becomes midnight of the next day.
+// A day past the end of its month, such as February 30, rolls over into the next month the same way.
 func (t *TIME) ToTime() time.Time {
 	return time.Date(int(t.Year), time.Month(t.Month), int(t.Day), int(t.Hour), int(t.Minute), int(t.Second), 0, time.UTC)
 }
@@ -591,7 +974,6 @@ func (t *TIME) ToTime() time.Time {
 */
 
 // TRNS
-// TODO !
 // The tRNS chunk specifies that the image uses simple transparency: either alpha values associated with palette entries (for indexed-color images) or a single transparent color (for grayscale and truecolor images). Although simple transparency is not as elegant as the full alpha channel, it requires less storage space and is sufficient for many common cases.
 // For color type 3 (indexed color), the tRNS chunk contains a series of one-byte alpha values, corresponding to entries in the PLTE chunk:
 //
@@ -617,7 +999,15 @@ func (t *TIME) ToTime() time.Time {
 // Note: when dealing with 16-bit grayscale or truecolor data, it is important to compare both bytes of the sample values to determine whether a pixel is transparent. Although decoders may drop the low-order byte of the samples for display, this must not occur until after the data has been tested for transparency. For example, if the grayscale level 0x0001 is specified to be transparent, it would be incorrect to compare only the high-order byte and decide that 0x0002 is also transparent.
 //
 // When present, the tRNS chunk must precede the first IDAT chunk, and must follow the PLTE chunk, if any.
+//
+// The layout depends on the color type which a chunk alone doesn't carry, so Parse fills every
+// interpretation the length allows and the decoder reads the fields matching IHDR.
 type TRNS struct {
+	Alphas []uint8
+	Gray   uint16
+	Red    uint16
+	Green  uint16
+	Blue   uint16
 }
 
 func (T *TRNS) ChunkName() ChunkName {
@@ -625,8 +1015,36 @@ func (T *TRNS) ChunkName() ChunkName {
 }
 
 func (T *TRNS) Parse(chunk *chunk) error {
-	//TODO implement me
-	panic("implement me")
+	if chunk.data == nil || len(chunk.data) == 0 || len(chunk.data) > 256 {
+		return errors.New("invalid trns chunk data")
+	}
+	T.Alphas = append([]uint8(nil), chunk.data...)
+	if len(chunk.data) >= 2 {
+		T.Gray = by.Uint16(chunk.data[:2])
+	}
+	if len(chunk.data) >= 6 {
+		T.Red = by.Uint16(chunk.data[:2])
+		T.Green = by.Uint16(chunk.data[2:4])
+		T.Blue = by.Uint16(chunk.data[4:6])
+	}
+	return nil
+}
+
+// SerializeFor encodes the alphas for indexed-color or the transparent sample key, 2 bytes per sample,
+// for grayscale and truecolor. tRNS is prohibited for the color types with an alpha channel.
+func (T *TRNS) SerializeFor(ihdr *IHDR) ([]byte, error) {
+	switch ihdr.ColorType {
+	case Indexed:
+		if len(T.Alphas) == 0 || len(T.Alphas) > 256 {
+			return nil, errors.Errorf("trns has %d alphas", len(T.Alphas))
+		}
+		return append([]byte(nil), T.Alphas...), nil
+	case Grayscale:
+		return serializeSamples(ihdr, T.Gray)
+	case Truecolor:
+		return serializeSamples(ihdr, T.Red, T.Green, T.Blue)
+	}
+	return nil, errors.Errorf("trns is prohibited for color type %d", ihdr.ColorType)
 }
 
 /*
@@ -653,21 +1071,337 @@ type ZTXT struct {
 	Separator         string
 	CompressionMethod uint8
 	Text              string
+	// Level is the compress/zlib level Serialize deflates Text with, the zero value stands for
+	// zlib.DefaultCompression since uncompressed text belongs in a tEXt chunk.
+	Level int
 }
 
 func (z *ZTXT) ChunkName() ChunkName {
 	return ZTXTChunk
 }
 
+// Parse keeps the decompressed text in Text.
 func (z *ZTXT) Parse(chunk *chunk) error {
-	str := strings.TrimSpace(string(chunk.data[:]))
-	strs := strings.Split(str, nullSep)
-	if len(strs) != 2 {
-		return errors.New("invalid text")
+	keyword, rest, ok := bytes.Cut(chunk.data, []byte(nullSep))
+	if !ok || len(rest) < 1 {
+		return errors.New("invalid ztxt chunk data")
+	}
+	if err := checkCompressionMethod(ZTXTChunk, rest[0]); err != nil {
+		return err
+	}
+	text, err := inflate(rest[1:])
+	if err != nil {
+		return err
 	}
-	z.Keyword = strs[0]
+	z.Keyword = string(keyword)
 	z.Separator = " "
-	z.CompressionMethod = strs[1][0]
-	z.Text = strs[1][1:]
+	z.CompressionMethod = rest[0]
+	z.Text = string(text)
+	return nil
+}
+
+func (z *ZTXT) Serialize() ([]byte, error) {
+	if err := checkKeyword(z.Keyword); err != nil {
+		return nil, err
+	}
+	text, err := deflate([]byte(z.Text), z.Level)
+	if err != nil {
+		return nil, err
+	}
+	var data = append([]byte(z.Keyword), 0, z.CompressionMethod)
+	return append(data, text...), nil
+}
+
+// checkCompressionMethod rejects every compression method but 0, zlib datastream with deflate compression.
+func checkCompressionMethod(name ChunkName, method uint8) error {
+	if method != 0 {
+		return errors.Errorf("unknown %s compression method %d", name, method)
+	}
+	return nil
+}
+
+func inflate(data []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// deflate compresses data at a compress/zlib level, 0 meaning zlib.DefaultCompression.
+func deflate(data []byte, level int) ([]byte, error) {
+	if level == zlib.NoCompression {
+		level = zlib.DefaultCompression
+	}
+	var buf bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+/*
+
+--------------------------------------------------------------------------------------
+
+*/
+
+// ITXT
+// International textual data  https://www.w3.org/TR/png/#11iTXt
+// This chunk is semantically equivalent to the tEXt and zTXt chunks, but the textual data is in the UTF-8 encoding of the Unicode character set instead of Latin-1. An iTXt chunk contains:
+//
+//	Keyword:             1-79 bytes (character string)
+//	Null separator:      1 byte
+//	Compression flag:    1 byte
+//	Compression method:  1 byte
+//	Language tag:        0 or more bytes (character string)
+//	Null separator:      1 byte
+//	Translated keyword:  0 or more bytes
+//	Null separator:      1 byte
+//	Text:                0 or more bytes
+//
+// The keyword is described above.
+// The compression flag is 0 for uncompressed text, 1 for compressed text. Only the text field may be compressed. The only value presently defined for the compression method byte is 0, meaning zlib datastream with deflate compression. For uncompressed text, encoders should set the compression method to 0 and decoders should ignore it.
+// The language tag indicates the human language used by the translated keyword and the text. It is case-insensitive and consists of ASCII hyphen-separated words of 1-8 alphanumeric characters each, for example cn, en-uk, x-klingon. If the first word is two or three letters long, it is an ISO language code. If the language tag is empty, the language is unspecified.
+// The translated keyword and text both use the UTF-8 encoding of the Unicode character set, and neither may contain a zero byte (null character). The text, unlike the other strings, is not null-terminated; its length is derived from the chunk length.
+type ITXT struct {
+	Keyword           string
+	CompressionFlag   uint8
+	CompressionMethod uint8
+	LanguageTag       string
+	TranslatedKeyword string
+	// Text is always the decompressed text.
+	Text string
+	// Level is the compress/zlib level Serialize deflates Text with when CompressionFlag is 1,
+	// the zero value stands for zlib.DefaultCompression.
+	Level int
+}
+
+func (i *ITXT) ChunkName() ChunkName {
+	return ITXTChunk
+}
+
+// Parse inflates the text only when the compression flag is 1. The compression method must be 0
+// then, for uncompressed text it's kept but otherwise ignored, as the spec tells decoders to.
+func (i *ITXT) Parse(chunk *chunk) error {
+	keyword, rest, ok := bytes.Cut(chunk.data, []byte(nullSep))
+	if !ok || len(rest) < 2 {
+		return errors.New("invalid itxt chunk data")
+	}
+	flag, method := rest[0], rest[1]
+	lang, rest, ok := bytes.Cut(rest[2:], []byte(nullSep))
+	if !ok {
+		return errors.New("invalid itxt chunk data")
+	}
+	translated, text, ok := bytes.Cut(rest, []byte(nullSep))
+	if !ok {
+		return errors.New("invalid itxt chunk data")
+	}
+	switch flag {
+	case 0:
+	case 1:
+		if err := checkCompressionMethod(ITXTChunk, method); err != nil {
+			return err
+		}
+		var err error
+		if text, err = inflate(text); err != nil {
+			return err
+		}
+	default:
+		return errors.Errorf("invalid itxt compression flag %d", flag)
+	}
+	if !utf8.Valid(translated) || !utf8.Valid(text) {
+		return errors.New("itxt text is not valid utf-8")
+	}
+	i.Keyword = string(keyword)
+	i.CompressionFlag = flag
+	i.CompressionMethod = method
+	i.LanguageTag = string(lang)
+	i.TranslatedKeyword = string(translated)
+	i.Text = string(text)
 	return nil
 }
+
+func (i *ITXT) Serialize() ([]byte, error) {
+	if err := checkKeyword(i.Keyword); err != nil {
+		return nil, err
+	}
+	if !utf8.ValidString(i.TranslatedKeyword) || !utf8.ValidString(i.Text) {
+		return nil, errors.New("itxt text is not valid utf-8")
+	}
+	var text = []byte(i.Text)
+	switch i.CompressionFlag {
+	case 0:
+	case 1:
+		if err := checkCompressionMethod(ITXTChunk, i.CompressionMethod); err != nil {
+			return nil, err
+		}
+		var err error
+		if text, err = deflate(text, i.Level); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.Errorf("invalid itxt compression flag %d", i.CompressionFlag)
+	}
+	var data = append([]byte(i.Keyword), 0, i.CompressionFlag, i.CompressionMethod)
+	data = append(append(data, i.LanguageTag...), 0)
+	data = append(append(data, i.TranslatedKeyword...), 0)
+	return append(data, text...), nil
+}
+
+/*
+
+--------------------------------------------------------------------------------------
+
+*/
+
+// PCAL
+// Calibration of pixel values  http://www.libpng.org/pub/png/spec/register/pngext-1.5.0.html#C.pCAL
+// This chunk defines a mapping from the stored sample values to original sample values, which can be physical quantities. It contains:
+//
+//	Calibration name:   1-79 bytes (character string)
+//	Null separator:     1 byte
+//	Original zero (x0): 4 bytes (signed integer)
+//	Original max (x1):  4 bytes (signed integer)
+//	Equation type:      1 byte
+//	Number of params:   1 byte
+//	Unit name:          0 or more bytes (character string)
+//	Null separator:     1 byte
+//	Parameter 0 (p0):   1 or more bytes (ASCII floating-point)
+//	Null separator:     1 byte
+//	...
+//	Parameter L (pL):   1 or more bytes (ASCII floating-point)
+//
+// The equation type selects the mapping and fixes the number of parameters:
+//
+//	0: linear mapping, 2 parameters
+//	1: base-e exponential mapping, 3 parameters
+//	2: arbitrary-base exponential mapping, 3 parameters
+//	3: hyperbolic mapping, 4 parameters
+//
+// If present, this chunk must precede the first IDAT chunk.
+type PCAL struct {
+	CalibrationName string
+	X0              int32
+	X1              int32
+	EquationType    uint8
+	UnitName        string
+	Params          []string
+}
+
+// pcalParams is the number of parameters of each equation type.
+var pcalParams = []int{2, 3, 3, 4}
+
+func (c *PCAL) ChunkName() ChunkName {
+	return PCALChunk
+}
+
+func (c *PCAL) Parse(chunk *chunk) error {
+	name, rest, ok := bytes.Cut(chunk.data, []byte(nullSep))
+	if !ok || len(rest) < 10 {
+		return errors.New("invalid pcal chunk data")
+	}
+	var head = rest[:10]
+	var equation, n = head[8], int(head[9])
+	if int(equation) >= len(pcalParams) || n != pcalParams[equation] {
+		return errors.Errorf("invalid pcal equation type %d with %d parameters", equation, n)
+	}
+	unit, rest, ok := bytes.Cut(rest[10:], []byte(nullSep))
+	if !ok {
+		return errors.New("invalid pcal chunk data")
+	}
+	var params = strings.Split(string(rest), nullSep)
+	if len(params) != n {
+		return errors.Errorf("pcal has %d parameters, want %d", len(params), n)
+	}
+	c.CalibrationName = string(name)
+	c.X0 = int32(by.Uint32(head[:4]))
+	c.X1 = int32(by.Uint32(head[4:8]))
+	c.EquationType = equation
+	c.UnitName = string(unit)
+	c.Params = params
+	return nil
+}
+
+func (c *PCAL) Serialize() ([]byte, error) {
+	if err := checkKeyword(c.CalibrationName); err != nil {
+		return nil, err
+	}
+	if int(c.EquationType) >= len(pcalParams) || len(c.Params) != pcalParams[c.EquationType] {
+		return nil, errors.Errorf("invalid pcal equation type %d with %d parameters", c.EquationType, len(c.Params))
+	}
+	var data = append([]byte(c.CalibrationName), 0)
+	data = append(data, uint8(c.X0>>24), uint8(c.X0>>16), uint8(c.X0>>8), uint8(c.X0))
+	data = append(data, uint8(c.X1>>24), uint8(c.X1>>16), uint8(c.X1>>8), uint8(c.X1))
+	data = append(data, c.EquationType, uint8(len(c.Params)))
+	data = append(append(data, c.UnitName...), 0)
+	return append(data, strings.Join(c.Params, nullSep)...), nil
+}
+
+/*
+
+--------------------------------------------------------------------------------------
+
+*/
+
+// ICCP
+// Embedded ICC profile  https://www.w3.org/TR/png/#11iCCP
+// If the iCCP chunk is present, the image samples conform to the color space represented by the embedded ICC profile as defined by the International Color Consortium. The iCCP chunk contains:
+//
+//	Profile name:       1-79 bytes (character string)
+//	Null separator:     1 byte
+//	Compression method: 1 byte
+//	Compressed profile: n bytes
+//
+// The profile name may be any convenient name for referring to the profile. It is case-sensitive and subject to the same restrictions as the keyword in a text chunk.
+// The only value presently defined for the compression method byte is 0, meaning zlib datastream with deflate compression.
+//
+// If the iCCP chunk is present, the sRGB chunk should not be present. It must precede the first IDAT chunk, and it must also precede the PLTE chunk if present.
+type ICCP struct {
+	ProfileName       string
+	CompressionMethod uint8
+	// Profile is the decompressed ICC profile.
+	Profile []byte
+}
+
+func (i *ICCP) ChunkName() ChunkName {
+	return ICCPChunk
+}
+
+func (i *ICCP) Parse(chunk *chunk) error {
+	name, rest, ok := bytes.Cut(chunk.data, []byte(nullSep))
+	if !ok || len(rest) < 1 {
+		return errors.New("invalid iccp chunk data")
+	}
+	if err := checkCompressionMethod(ICCPChunk, rest[0]); err != nil {
+		return err
+	}
+	profile, err := inflate(rest[1:])
+	if err != nil {
+		return err
+	}
+	i.ProfileName = string(name)
+	i.CompressionMethod = rest[0]
+	i.Profile = profile
+	return nil
+}
+
+func (i *ICCP) Serialize() ([]byte, error) {
+	if err := checkKeyword(i.ProfileName); err != nil {
+		return nil, err
+	}
+	profile, err := deflate(i.Profile, zlib.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	var data = append([]byte(i.ProfileName), 0, i.CompressionMethod)
+	return append(data, profile...), nil
+}
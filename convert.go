@@ -0,0 +1,112 @@
+package simple_png
+
+import (
+	"image/color"
+
+	"github.com/pkg/errors"
+)
+
+// ConvertColorType re-encodes the image data with the target color type, rebuilding IHDR,
+// PLTE, tRNS and IDAT. 16 bit images stay 16 bit unless the target is indexed-color, every
+// other image becomes 8 bit. The result is never interlaced.
+//
+// A conversion that would lose information (color dropped by a grayscale target, alpha
+// dropped by an opaque target, precision dropped by a palette) returns an error unless force
+// is set, in which case grayscale uses the luminance and alpha is discarded.
+// Indexed-color needs at most 256 distinct colors, whatever force is.
+func (p *Png) ConvertColorType(target ColorType, force bool) error {
+	img, err := p.ToImage()
+	if err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+
+	var depth uint8 = 8
+	if p.IHDR.BitDepth == 16 && target != Indexed {
+		depth = 16
+	}
+	var ihdr = &IHDR{Width: p.IHDR.Width, Height: p.IHDR.Height, BitDepth: depth, ColorType: target}
+	if err = ihdr.checkDecodable(); err != nil {
+		return err
+	}
+
+	var b = img.Bounds()
+	var rows = make([][]byte, b.Dy())
+	var index = map[color.NRGBA]int{}
+	var plte *PLTE
+	var alphas []uint8
+	for y := range rows {
+		var row = make([]byte, 0, ihdr.rowBytes(b.Dx()))
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := nrgba64At(img, x, b.Min.Y+y)
+			var samples []uint16
+			switch target {
+			case Grayscale, GrayscaleAlpha:
+				var gray = c.R
+				if c.R != c.G || c.G != c.B {
+					if !force {
+						return errors.Errorf("conversion to %s loses color", target)
+					}
+					gray = uint16((19595*uint32(c.R) + 38470*uint32(c.G) + 7471*uint32(c.B) + 1<<15) >> 16)
+				}
+				samples = append(samples, gray)
+			case Truecolor, TruecolorAlpha, Indexed:
+				samples = append(samples, c.R, c.G, c.B)
+			}
+			switch target {
+			case GrayscaleAlpha, TruecolorAlpha, Indexed:
+				samples = append(samples, c.A)
+			default:
+				if c.A != 0xffff && !force {
+					return errors.Errorf("conversion to %s loses transparency", target)
+				}
+			}
+			for _, v := range samples {
+				if depth == 8 && v>>8*0x101 != v && !force {
+					return errors.Errorf("conversion to %s loses precision", target)
+				}
+			}
+
+			if target != Indexed {
+				for _, v := range samples {
+					row = append(row, uint8(v>>8))
+					if depth == 16 {
+						row = append(row, uint8(v))
+					}
+				}
+				continue
+			}
+			var key = color.NRGBA{R: uint8(c.R >> 8), G: uint8(c.G >> 8), B: uint8(c.B >> 8), A: uint8(c.A >> 8)}
+			i, ok := index[key]
+			if !ok {
+				if len(index) == 256 {
+					return errors.New("more than 256 colors for indexed-color")
+				}
+				if plte == nil {
+					plte = &PLTE{}
+				}
+				i = len(index)
+				index[key] = i
+				plte.Colors = append(plte.Colors, &PLTEColor{Red: key.R, Green: key.G, Blue: key.B})
+				alphas = append(alphas, key.A)
+			}
+			row = append(row, uint8(i))
+		}
+		rows[y] = row
+	}
+
+	// tRNS only needs the entries up to the last non-opaque one
+	for len(alphas) > 0 && alphas[len(alphas)-1] == 0xff {
+		alphas = alphas[:len(alphas)-1]
+	}
+	var trns []byte
+	if len(alphas) > 0 {
+		trns = alphas
+	}
+	np, err := newPngFromRaster(ihdr, plte, trns, rows)
+	if err != nil {
+		return err
+	}
+	return p.replaceImage(np)
+}
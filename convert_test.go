@@ -0,0 +1,116 @@
+package simple_png
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestConvertColorType(t *testing.T) {
+	var gray = image.NewNRGBA(image.Rect(0, 0, 5, 3))
+	var colored = image.NewNRGBA(image.Rect(0, 0, 5, 3))
+	for i := 0; i < 15; i++ {
+		v := uint8(i * 17)
+		gray.SetNRGBA(i%5, i/5, color.NRGBA{R: v, G: v, B: v, A: 0xff})
+		colored.SetNRGBA(i%5, i/5, color.NRGBA{R: v, G: 0, B: 255 - v, A: uint8(i * 10)})
+	}
+	var cases = []struct {
+		img      image.Image
+		target   ColorType
+		lossless bool
+	}{
+		{gray, Grayscale, true},
+		{gray, GrayscaleAlpha, true},
+		{gray, Truecolor, true},
+		{gray, Indexed, true},
+		{colored, TruecolorAlpha, true},
+		{colored, Indexed, true},
+		{colored, Truecolor, false},
+		{colored, Grayscale, false},
+		{testImage(), Indexed, false},
+	}
+	for i, c := range cases {
+		var buf bytes.Buffer
+		if err := Encode(&buf, c.img); err != nil {
+			t.Fatal(err)
+		}
+		p, err := ParsePng(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.ConvertColorType(c.target, false)
+		if (err == nil) != c.lossless {
+			t.Fatalf("case %d: ConvertColorType(%s) = %v", i, c.target, err)
+		}
+		if err != nil {
+			continue
+		}
+		if p.IHDR.ColorType != c.target {
+			t.Fatalf("case %d: color type %s", i, p.IHDR.ColorType)
+		}
+		buf.Reset()
+		if err = p.WritePng(&buf); err != nil {
+			t.Fatal(err)
+		}
+		got, err := png.Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertSamePixels(t, c.img, got)
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, colored); err != nil {
+		t.Fatal(err)
+	}
+	p, err := ParsePng(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.ConvertColorType(Grayscale, true); err != nil {
+		t.Fatal(err)
+	}
+	if p.IHDR.ColorType != Grayscale || p.TRNS != nil {
+		t.Fatalf("forced conversion gave %s", p.IHDR.ColorType)
+	}
+}
+
+// TestInterlacedHelpers checks the helpers built on ToImage give the same result for an
+// interlaced image as for the same pixels stored without interlacing.
+func TestInterlacedHelpers(t *testing.T) {
+	var results [2][]any
+	for interlace := uint8(0); interlace < 2; interlace++ {
+		var ihdr = &IHDR{Width: 10, Height: 9, BitDepth: 8, ColorType: GrayscaleAlpha, InterlaceMethod: interlace}
+		p, err := ParsePng(bytes.NewReader(randomPng(rand.New(rand.NewSource(5)), ihdr, false)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rgba64, err := p.ToRGBA64()
+		if err != nil {
+			t.Fatal(err)
+		}
+		transparent, err := p.HasTransparency()
+		if err != nil {
+			t.Fatal(err)
+		}
+		isGray, _, distinct, err := p.ActualProperties()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = p.ConvertColorType(TruecolorAlpha, false); err != nil {
+			t.Fatal(err)
+		}
+		raw := make([]byte, 10*9*4)
+		if _, err = p.DecodeInto(raw); err != nil {
+			t.Fatal(err)
+		}
+		results[interlace] = []any{rgba64.Pix, transparent, isGray, distinct, raw}
+	}
+	if !reflect.DeepEqual(results[0], results[1]) {
+		t.Fatal("interlaced image gives different results")
+	}
+}
@@ -0,0 +1,786 @@
+package simple_png
+
+import (
+	"bytes"
+	"compress/zlib"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"slices"
+
+	"github.com/pkg/errors"
+)
+
+// allowedBitDepths the bit depth restrictions for each color type, see IHDR.
+var allowedBitDepths = map[ColorType][]uint8{
+	Grayscale:      {1, 2, 4, 8, 16},
+	Truecolor:      {8, 16},
+	Indexed:        {1, 2, 4, 8},
+	GrayscaleAlpha: {8, 16},
+	TruecolorAlpha: {8, 16},
+}
+
+// defaultMaxPixels bounds the image size ToImage and RawSize accept, 256 million pixels take 1GiB as NRGBA.
+const defaultMaxPixels = 256 << 20
+
+type decodeConfig struct {
+	premultiplied bool
+	maxPixels     uint64
+	narrow        bool
+}
+
+type DecodeOption func(*decodeConfig)
+
+// WithPremultiplied makes ToImage return images with an alpha channel premultiplied, *image.RGBA
+// and *image.RGBA64 in place of *image.NRGBA and *image.NRGBA64. Paletted images are unaffected.
+func WithPremultiplied() DecodeOption {
+	return func(c *decodeConfig) {
+		c.premultiplied = true
+	}
+}
+
+// MaxPixels limits the width times height of the images decoded, IHDR can claim dimensions that
+// would allocate terabytes before a single byte of image data is read. 0 removes the limit,
+// the default is 256 million pixels.
+func MaxPixels(n uint64) DecodeOption {
+	return func(c *decodeConfig) {
+		c.maxPixels = n
+	}
+}
+
+// Downsample16to8 makes ToImage decode 16 bit images straight into 8 bit image types, keeping the
+// high byte of each sample so 0xffff becomes 0xff and 0x0000 stays 0x00. It halves the memory
+// taken when full precision isn't needed. tRNS color keys still match the full 16 bit samples.
+func Downsample16to8() DecodeOption {
+	return func(c *decodeConfig) {
+		c.narrow = true
+	}
+}
+
+func newDecodeConfig(opts []DecodeOption) *decodeConfig {
+	var c = &decodeConfig{maxPixels: defaultMaxPixels}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *decodeConfig) checkPixels(ihdr *IHDR) error {
+	if n := uint64(ihdr.Width) * uint64(ihdr.Height); c.maxPixels > 0 && n > c.maxPixels {
+		return errors.Errorf("image of %d pixels exceeds the limit of %d", n, c.maxPixels)
+	}
+	return nil
+}
+
+// pass is one sub-image of the scanline stream, a non-interlaced image is a single pass.
+type pass struct {
+	x0, y0, dx, dy int
+	width, height  int
+}
+
+// adam7 the starting offset and step of the seven Adam7 passes.
+var adam7 = [7]pass{
+	{x0: 0, y0: 0, dx: 8, dy: 8},
+	{x0: 4, y0: 0, dx: 8, dy: 8},
+	{x0: 0, y0: 4, dx: 4, dy: 8},
+	{x0: 2, y0: 0, dx: 4, dy: 4},
+	{x0: 0, y0: 2, dx: 2, dy: 4},
+	{x0: 1, y0: 0, dx: 2, dy: 2},
+	{x0: 0, y0: 1, dx: 1, dy: 2},
+}
+
+func (c *IHDR) passes() []pass {
+	var w, h = int(c.Width), int(c.Height)
+	if c.InterlaceMethod == 0 {
+		return []pass{{dx: 1, dy: 1, width: w, height: h}}
+	}
+	var ps []pass
+	for _, a := range adam7 {
+		if w <= a.x0 || h <= a.y0 {
+			continue
+		}
+		a.width = (w - a.x0 + a.dx - 1) / a.dx
+		a.height = (h - a.y0 + a.dy - 1) / a.dy
+		ps = append(ps, a)
+	}
+	return ps
+}
+
+// Passes returns the number of passes the image data is transmitted in, 7 for Adam7 and 1
+// otherwise, 0 without IHDR.
+func (p *Png) Passes() int {
+	p.RLock()
+	defer p.RUnlock()
+	switch {
+	case p.IHDR == nil:
+		return 0
+	case p.IHDR.InterlaceMethod == 1:
+		return len(adam7)
+	}
+	return 1
+}
+
+// PassDimensions returns the width and height of each pass, the image size alone when not
+// interlaced. Adam7 passes of small images can be empty, their size is then 0.
+func (p *Png) PassDimensions() [][2]int {
+	p.RLock()
+	defer p.RUnlock()
+	if p.IHDR == nil {
+		return nil
+	}
+	var w, h = int(p.IHDR.Width), int(p.IHDR.Height)
+	if p.IHDR.InterlaceMethod != 1 {
+		return [][2]int{{w, h}}
+	}
+	var dims = make([][2]int, len(adam7))
+	for i, a := range adam7 {
+		if w > a.x0 && h > a.y0 {
+			dims[i] = [2]int{(w - a.x0 + a.dx - 1) / a.dx, (h - a.y0 + a.dy - 1) / a.dy}
+		}
+	}
+	return dims
+}
+
+func (c *IHDR) checkDecodable() error {
+	depths, ok := allowedBitDepths[c.ColorType]
+	if !ok {
+		return errors.Errorf("invalid color type %d", c.ColorType)
+	}
+	var valid bool
+	for _, d := range depths {
+		valid = valid || d == c.BitDepth
+	}
+	if !valid {
+		return errors.Errorf("invalid bit depth %d for color type %d", c.BitDepth, c.ColorType)
+	}
+	if c.CompressionMethod != 0 {
+		return errors.Errorf("unknown compression method %d", c.CompressionMethod)
+	}
+	if c.FilterMethod != 0 {
+		return errors.Errorf("unknown filter method %d", c.FilterMethod)
+	}
+	if c.InterlaceMethod > 1 {
+		return errors.Errorf("unknown interlace method %d", c.InterlaceMethod)
+	}
+	return c.checkSize()
+}
+
+// scanlineReader reads the scanlines of one pass from the decompressed datastream and unfilters them.
+type scanlineReader struct {
+	r    io.Reader
+	bpp  int
+	cur  []byte
+	prev []byte
+}
+
+func newScanlineReader(r io.Reader, ihdr *IHDR, width int) *scanlineReader {
+	var n = ihdr.rowBytes(width) + 1
+	return &scanlineReader{r: r, bpp: ihdr.BytesPerPixel(), cur: make([]byte, n), prev: make([]byte, n)}
+}
+
+// next returns the unfiltered scanline without its filter type byte,
+// it is only valid until the following call.
+func (s *scanlineReader) next() ([]byte, error) {
+	s.cur, s.prev = s.prev, s.cur
+	if _, err := io.ReadFull(s.r, s.cur); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := unfilterRow(s.cur[0], s.cur[1:], s.prev[1:], s.bpp); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return s.cur[1:], nil
+}
+
+func (p *Png) idatReader() io.Reader {
+	if err := p.pixelErr(); err != nil {
+		return errReader{err}
+	}
+	var rs = make([]io.Reader, len(p.IDATs))
+	for i, idat := range p.IDATs {
+		rs[i] = bytes.NewReader(idat.Data)
+	}
+	return io.MultiReader(rs...)
+}
+
+// errReader fails every read with err.
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+// Palette returns the PLTE colors with the tRNS alpha applied, entries beyond the tRNS are fully opaque.
+func (p *Png) Palette() color.Palette {
+	if p.PLTE == nil {
+		return nil
+	}
+	var pal = make(color.Palette, len(p.PLTE.Colors))
+	for i, c := range p.PLTE.Colors {
+		var a uint8 = 0xff
+		if p.TRNS != nil && i < len(p.TRNS.Alphas) {
+			a = p.TRNS.Alphas[i]
+		}
+		pal[i] = color.NRGBA{R: c.Red, G: c.Green, B: c.Blue, A: a}
+	}
+	return pal
+}
+
+// BackgroundColor returns the bKGD color resolved against the color type: the palette entry for
+// indexed-color, a color.Gray for grayscale up to 8 bits and a color.Gray16 at 16 bits, like the
+// pixels ToImage gives, and the samples scaled to 16 bits for truecolor. ok is false without a
+// bKGD, or when it doesn't fit the image: a palette index out of range, samples beyond the bit depth,
+// an IHDR ToImage would reject.
+func (p *Png) BackgroundColor() (c color.Color, ok bool) {
+	p.RLock()
+	defer p.RUnlock()
+	if p.BKGD == nil || p.IHDR == nil || p.IHDR.checkDecodable() != nil {
+		return nil, false
+	}
+	var max = uint32(1)<<p.IHDR.BitDepth - 1
+	var scale = func(v uint16) (uint16, bool) {
+		return uint16(uint32(v) * 0xffff / max), uint32(v) <= max
+	}
+	switch p.IHDR.ColorType {
+	case Indexed:
+		if p.PLTE == nil || int(p.BKGD.Palette) >= len(p.PLTE.Colors) {
+			return nil, false
+		}
+		var e = p.PLTE.Colors[p.BKGD.Palette]
+		return color.RGBA{R: e.Red, G: e.Green, B: e.Blue, A: 0xff}, true
+	case Grayscale, GrayscaleAlpha:
+		y, ok := scale(p.BKGD.Gray)
+		if p.IHDR.BitDepth < 16 {
+			// scaled to 16 bits the gray has equal bytes, 0x80 is 0x8080
+			return color.Gray{Y: uint8(y >> 8)}, ok
+		}
+		return color.Gray16{Y: y}, ok
+	case Truecolor, TruecolorAlpha:
+		r, okR := scale(p.BKGD.Red)
+		g, okG := scale(p.BKGD.Green)
+		b, okB := scale(p.BKGD.Blue)
+		return color.RGBA64{R: r, G: g, B: b, A: 0xffff}, okR && okG && okB
+	}
+	return nil, false
+}
+
+// ToImage decodes the image data, the concrete type follows the color type and bit depth:
+//
+//	Color Type  Image
+//
+//	0           *image.Gray, *image.Gray16
+//	2           *image.RGBA, *image.RGBA64
+//	3           *image.Paletted
+//	4, 6        *image.NRGBA, *image.NRGBA64
+//
+// Grayscale and truecolor images with a tRNS chunk decode to *image.NRGBA or *image.NRGBA64.
+// With Downsample16to8, 16 bit images decode to the 8 bit type of their row.
+//
+// PNG samples are never premultiplied by alpha, which is what the NRGBA types hold: a 50% red
+// pixel is R 0xff, A 0x80. The premultiplied *image.RGBA stores the same pixel as R 0x80, A 0x80,
+// WithPremultiplied converts to it. Opaque images are the same either way.
+func (p *Png) ToImage(opts ...DecodeOption) (image.Image, error) {
+	var conf = newDecodeConfig(opts)
+	p.RLock()
+	defer p.RUnlock()
+	if p.IHDR == nil {
+		return nil, errors.New("no IHDR found")
+	}
+	if err := p.IHDR.checkDecodable(); err != nil {
+		return nil, err
+	}
+	if err := conf.checkPixels(p.IHDR); err != nil {
+		return nil, err
+	}
+	img, err := p.decodeStream(p.IHDR, p.idatReader(), conf.narrow)
+	if err != nil || !conf.premultiplied {
+		return img, err
+	}
+	var dst draw.Image
+	switch img.(type) {
+	case *image.NRGBA:
+		dst = image.NewRGBA(img.Bounds())
+	case *image.NRGBA64:
+		dst = image.NewRGBA64(img.Bounds())
+	default:
+		return img, nil
+	}
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Src)
+	return dst, nil
+}
+
+// decodeStream decodes the zlib datastream r holding an image laid out as ihdr, which is p.IHDR
+// or the IHDR of an APNG frame, with the palette and tRNS of p. narrow decodes 16 bit samples
+// to 8 bits.
+func (p *Png) decodeStream(ihdr *IHDR, r io.Reader, narrow bool) (image.Image, error) {
+	d, err := p.newFrameDecoder(ihdr, image.Rect(0, 0, int(ihdr.Width), int(ihdr.Height)), narrow)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer zr.Close()
+	for _, ps := range ihdr.passes() {
+		sr := newScanlineReader(zr, ihdr, ps.width)
+		for y := 0; y < ps.height; y++ {
+			row, err := sr.next()
+			if err != nil {
+				return nil, err
+			}
+			if err = d.putRow(row, ps, ps.y0+y*ps.dy); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return d.img, nil
+}
+
+// ToRGBA64 decodes the image data into an *image.RGBA64 whatever the color type and bit depth,
+// samples are scaled to the full 16 bit range and the palette and tRNS chunk are applied.
+func (p *Png) ToRGBA64() (*image.RGBA64, error) {
+	img, err := p.ToImage()
+	if err != nil {
+		return nil, err
+	}
+	if rgba64, ok := img.(*image.RGBA64); ok {
+		return rgba64, nil
+	}
+	var dst = image.NewRGBA64(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Src)
+	return dst, nil
+}
+
+// DecodeAs decodes the image data and converts it to model, one of color.NRGBAModel, NRGBA64Model,
+// RGBAModel, RGBA64Model, GrayModel and Gray16Model. Conversions to the non-premultiplied models keep
+// the color of transparent pixels.
+func (p *Png) DecodeAs(model color.Model) (image.Image, error) {
+	img, err := p.ToImage()
+	if err != nil {
+		return nil, err
+	}
+	switch model {
+	case color.NRGBAModel, color.NRGBA64Model, color.RGBAModel, color.RGBA64Model, color.GrayModel, color.Gray16Model:
+		// only compared once known, a color.Palette model can't be compared at all
+		if img.ColorModel() == model {
+			return img, nil
+		}
+	default:
+		return nil, errors.New("unsupported color model")
+	}
+	var b = img.Bounds()
+	var dst draw.Image
+	switch model {
+	case color.NRGBAModel:
+		var m = image.NewNRGBA(b)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := nrgba64At(img, x, y)
+				m.SetNRGBA(x, y, color.NRGBA{R: uint8(c.R >> 8), G: uint8(c.G >> 8), B: uint8(c.B >> 8), A: uint8(c.A >> 8)})
+			}
+		}
+		return m, nil
+	case color.NRGBA64Model:
+		var m = image.NewNRGBA64(b)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				m.SetNRGBA64(x, y, nrgba64At(img, x, y))
+			}
+		}
+		return m, nil
+	case color.RGBAModel:
+		dst = image.NewRGBA(b)
+	case color.RGBA64Model:
+		dst = image.NewRGBA64(b)
+	case color.GrayModel:
+		dst = image.NewGray(b)
+	case color.Gray16Model:
+		dst = image.NewGray16(b)
+	default:
+		return nil, errors.New("unsupported color model")
+	}
+	draw.Draw(dst, b, img, b.Min, draw.Src)
+	return dst, nil
+}
+
+type decoder struct {
+	ihdr           *IHDR
+	trns           *TRNS
+	useTransparent bool
+	// narrow keeps the high byte of 16 bit samples, img is then an 8 bit image type
+	narrow bool
+	img    image.Image
+}
+
+// newDecoder allocates the image the scanlines are converted into, rect is usually the full image.
+func (p *Png) newDecoder(rect image.Rectangle) (*decoder, error) {
+	return p.newFrameDecoder(p.IHDR, rect, false)
+}
+
+func (p *Png) newFrameDecoder(ihdr *IHDR, rect image.Rectangle, narrow bool) (*decoder, error) {
+	var d = &decoder{ihdr: ihdr, trns: p.TRNS, narrow: narrow && ihdr.BitDepth == 16}
+	var deep = ihdr.BitDepth == 16 && !d.narrow
+	// missing ancillary chunks take their spec defaults: no tRNS is fully opaque, gAMA and bKGD
+	// don't affect the samples. A tRNS of the wrong length for the color type is ignored likewise.
+	if p.TRNS != nil {
+		switch ihdr.ColorType {
+		case Grayscale:
+			d.useTransparent = len(p.TRNS.Alphas) == 2
+		case Truecolor:
+			d.useTransparent = len(p.TRNS.Alphas) == 6
+		}
+	}
+	switch {
+	case ihdr.ColorType == Indexed:
+		if p.PLTE == nil {
+			return nil, errors.WithStack(ErrMissingPLTE)
+		}
+		d.img = image.NewPaletted(rect, p.Palette())
+	case ihdr.ColorType == Grayscale && !d.useTransparent && deep:
+		d.img = image.NewGray16(rect)
+	case ihdr.ColorType == Grayscale && !d.useTransparent:
+		d.img = image.NewGray(rect)
+	case ihdr.ColorType == Truecolor && !d.useTransparent && deep:
+		d.img = image.NewRGBA64(rect)
+	case ihdr.ColorType == Truecolor && !d.useTransparent:
+		d.img = image.NewRGBA(rect)
+	case deep:
+		d.img = image.NewNRGBA64(rect)
+	default:
+		d.img = image.NewNRGBA(rect)
+	}
+	return d, nil
+}
+
+// sample returns the i-th sample of an unfiltered scanline.
+func sample(row []byte, i int, depth uint8) uint16 {
+	switch depth {
+	case 16:
+		return uint16(row[2*i])<<8 | uint16(row[2*i+1])
+	case 8:
+		return uint16(row[i])
+	}
+	var bit = i * int(depth)
+	var shift = 8 - int(depth) - bit%8
+	return uint16(row[bit/8]>>shift) & (1<<depth - 1)
+}
+
+// putRow converts the samples of one scanline of pass ps to pixels of row y.
+func (d *decoder) putRow(row []byte, ps pass, y int) error {
+	var depth = d.ihdr.BitDepth
+	var max = uint16(1)<<depth - 1
+	var channels = d.ihdr.Channels()
+	// shift narrows 16 bit samples once tRNS has been matched against them
+	var shift uint
+	if d.narrow {
+		shift, max = 8, 0xff
+	}
+	for i := 0; i < ps.width; i++ {
+		var x = ps.x0 + i*ps.dx
+		switch d.ihdr.ColorType {
+		case Indexed:
+			idx := sample(row, i, depth)
+			img := d.img.(*image.Paletted)
+			if int(idx) >= len(img.Palette) {
+				return errors.Errorf("palette index %d out of range", idx)
+			}
+			img.SetColorIndex(x, y, uint8(idx))
+		case Grayscale:
+			v := sample(row, i, depth)
+			var a uint16 = 0xffff
+			if d.useTransparent && v == d.trns.Gray {
+				a = 0
+			}
+			v, a = v>>shift, a>>shift
+			switch img := d.img.(type) {
+			case *image.Gray16:
+				img.SetGray16(x, y, color.Gray16{Y: v})
+			case *image.Gray:
+				img.SetGray(x, y, color.Gray{Y: uint8(v * 0xff / max)})
+			case *image.NRGBA64:
+				img.SetNRGBA64(x, y, color.NRGBA64{R: v, G: v, B: v, A: a})
+			case *image.NRGBA:
+				g := uint8(v * 0xff / max)
+				img.SetNRGBA(x, y, color.NRGBA{R: g, G: g, B: g, A: uint8(a)})
+			}
+		default:
+			var s [4]uint16
+			for c := 0; c < channels; c++ {
+				s[c] = sample(row, i*channels+c, depth)
+			}
+			r, g, b, a := s[0], s[1], s[2], s[3]
+			switch d.ihdr.ColorType {
+			case GrayscaleAlpha:
+				r, g, b, a = s[0], s[0], s[0], s[1]
+			case Truecolor:
+				a = uint16(1)<<depth - 1
+				if d.useTransparent && r == d.trns.Red && g == d.trns.Green && b == d.trns.Blue {
+					a = 0
+				}
+			}
+			r, g, b, a = r>>shift, g>>shift, b>>shift, a>>shift
+			switch img := d.img.(type) {
+			case *image.RGBA64:
+				img.SetRGBA64(x, y, color.RGBA64{R: r, G: g, B: b, A: a})
+			case *image.RGBA:
+				img.SetRGBA(x, y, color.RGBA{R: uint8(r), G: uint8(g), B: uint8(b), A: uint8(a)})
+			case *image.NRGBA64:
+				img.SetNRGBA64(x, y, color.NRGBA64{R: r, G: g, B: b, A: a})
+			case *image.NRGBA:
+				img.SetNRGBA(x, y, color.NRGBA{R: uint8(r), G: uint8(g), B: uint8(b), A: uint8(a)})
+			}
+		}
+	}
+	return nil
+}
+
+// RawSize is the length of the unfiltered raster DecodeInto writes, scanlines without filter type bytes
+// packed at the image bit depth. Interlaced images are laid out de-interlaced. MaxPixels applies.
+// Only IHDR is needed, so RawSize works on a png parsed by ParseMetadata too.
+func (p *Png) RawSize(opts ...DecodeOption) (int, error) {
+	var conf = newDecodeConfig(opts)
+	p.RLock()
+	defer p.RUnlock()
+	if p.IHDR == nil {
+		return 0, errors.New("no IHDR found")
+	}
+	if err := p.IHDR.checkDecodable(); err != nil {
+		return 0, err
+	}
+	if err := conf.checkPixels(p.IHDR); err != nil {
+		return 0, err
+	}
+	return p.IHDR.rowBytes(int(p.IHDR.Width)) * int(p.IHDR.Height), nil
+}
+
+// DecodeInto writes the unfiltered raster into dst, see RawSize for the layout, and returns the bytes written.
+// When dst is too small it returns the needed length with io.ErrShortBuffer.
+func (p *Png) DecodeInto(dst []byte, opts ...DecodeOption) (int, error) {
+	size, err := p.RawSize(opts...)
+	if err != nil {
+		return 0, err
+	}
+	if len(dst) < size {
+		return size, errors.WithStack(io.ErrShortBuffer)
+	}
+	p.RLock()
+	defer p.RUnlock()
+	zr, err := zlib.NewReader(p.idatReader())
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	defer zr.Close()
+	var stride = p.IHDR.rowBytes(int(p.IHDR.Width))
+	var bits = p.IHDR.BitsPerPixel()
+	if bits < 8 && p.IHDR.InterlaceMethod == 1 {
+		// passes only set their own pixel bits, keep the row padding deterministic
+		clear(dst[:size])
+	}
+	for _, ps := range p.IHDR.passes() {
+		sr := newScanlineReader(zr, p.IHDR, ps.width)
+		for y := 0; y < ps.height; y++ {
+			row, err := sr.next()
+			if err != nil {
+				return 0, err
+			}
+			var out = dst[(ps.y0+y*ps.dy)*stride:][:stride]
+			if ps.dx == 1 {
+				copy(out, row)
+				continue
+			}
+			for i := 0; i < ps.width; i++ {
+				putPixelBits(out, row, ps.x0+i*ps.dx, i, bits)
+			}
+		}
+	}
+	return size, nil
+}
+
+// RecoverOriginalSamples returns the samples of every pixel, row by row, right-shifted to the
+// significant bits recorded by sBIT, the spec's lossless recovery of the original precision.
+// Indexed-color pixels give the red, green and blue samples of their palette entry. Without
+// sBIT the samples are returned as stored.
+func (p *Png) RecoverOriginalSamples() ([]uint16, error) {
+	size, err := p.RawSize()
+	if err != nil {
+		return nil, err
+	}
+	var raster = make([]byte, size)
+	if _, err = p.DecodeInto(raster); err != nil {
+		return nil, err
+	}
+	p.RLock()
+	defer p.RUnlock()
+	var ihdr = p.IHDR
+	var channels, depth = ihdr.Channels(), ihdr.BitDepth
+	if ihdr.ColorType == Indexed {
+		if p.PLTE == nil {
+			return nil, errors.WithStack(ErrMissingPLTE)
+		}
+		channels, depth = 3, 8
+	}
+	var shifts = make([]uint8, channels)
+	if p.SBIT != nil {
+		for c := range shifts {
+			var bits = p.SBIT.OrgData[c]
+			if bits == 0 || bits > depth {
+				return nil, errors.Errorf("sbit %d out of range for sample depth %d", bits, depth)
+			}
+			shifts[c] = depth - bits
+		}
+	}
+	var width, stride = int(ihdr.Width), ihdr.rowBytes(int(ihdr.Width))
+	var samples = make([]uint16, 0, width*int(ihdr.Height)*channels)
+	for y := 0; y < int(ihdr.Height); y++ {
+		var row = raster[y*stride : (y+1)*stride]
+		for x := 0; x < width; x++ {
+			if ihdr.ColorType == Indexed {
+				var idx = int(sample(row, x, ihdr.BitDepth))
+				if idx >= len(p.PLTE.Colors) {
+					return nil, errors.Errorf("palette index %d out of range", idx)
+				}
+				var c = p.PLTE.Colors[idx]
+				samples = append(samples, uint16(c.Red>>shifts[0]), uint16(c.Green>>shifts[1]), uint16(c.Blue>>shifts[2]))
+				continue
+			}
+			for c := 0; c < channels; c++ {
+				samples = append(samples, sample(row, x*channels+c, depth)>>shifts[c])
+			}
+		}
+	}
+	return samples, nil
+}
+
+// AlphaMask returns the alpha of every pixel without building the color image: the alpha
+// channel of color types 4 and 6, scaled to 8 bits, or the tRNS transparency of color types 0, 2
+// and 3. An image without alpha channel or tRNS gives a fully opaque mask without decoding.
+func (p *Png) AlphaMask() (*image.Alpha, error) {
+	size, err := p.RawSize()
+	if err != nil {
+		return nil, err
+	}
+	p.RLock()
+	var ihdr, trns, plte = p.IHDR, p.TRNS, p.PLTE
+	var perr = p.pixelErr()
+	p.RUnlock()
+	if perr != nil {
+		return nil, errors.WithStack(perr)
+	}
+	var width, height = int(ihdr.Width), int(ihdr.Height)
+	var mask = image.NewAlpha(image.Rect(0, 0, width, height))
+	var alpha = ihdr.ColorType == GrayscaleAlpha || ihdr.ColorType == TruecolorAlpha
+	var keyed = trns != nil && (ihdr.ColorType == Grayscale && len(trns.Alphas) == 2 ||
+		ihdr.ColorType == Truecolor && len(trns.Alphas) == 6 || ihdr.ColorType == Indexed)
+	if !alpha && !keyed {
+		for i := range mask.Pix {
+			mask.Pix[i] = 0xff
+		}
+		return mask, nil
+	}
+	if ihdr.ColorType == Indexed && plte == nil {
+		return nil, errors.WithStack(ErrMissingPLTE)
+	}
+
+	var raster = make([]byte, size)
+	if _, err = p.DecodeInto(raster); err != nil {
+		return nil, err
+	}
+	var channels, depth, stride = ihdr.Channels(), ihdr.BitDepth, ihdr.rowBytes(width)
+	for y := 0; y < height; y++ {
+		var row, out = raster[y*stride:][:stride], mask.Pix[y*mask.Stride:][:width]
+		for x := range out {
+			var a uint8 = 0xff
+			switch ihdr.ColorType {
+			case GrayscaleAlpha, TruecolorAlpha:
+				var v = sample(row, x*channels+channels-1, depth)
+				if depth == 16 {
+					v >>= 8
+				}
+				a = uint8(v)
+			case Indexed:
+				var idx = int(sample(row, x, depth))
+				if idx >= len(plte.Colors) {
+					return nil, errors.Errorf("palette index %d out of range", idx)
+				}
+				if idx < len(trns.Alphas) {
+					a = trns.Alphas[idx]
+				}
+			case Grayscale:
+				if sample(row, x, depth) == trns.Gray {
+					a = 0
+				}
+			case Truecolor:
+				if sample(row, 3*x, depth) == trns.Red && sample(row, 3*x+1, depth) == trns.Green &&
+					sample(row, 3*x+2, depth) == trns.Blue {
+					a = 0
+				}
+			}
+			out[x] = a
+		}
+	}
+	return mask, nil
+}
+
+// channelNames names the channels of each color type in sample order.
+var channelNames = map[ColorType][]string{
+	Grayscale:      {"Gray"},
+	Truecolor:      {"Red", "Green", "Blue"},
+	Indexed:        {"Index"},
+	GrayscaleAlpha: {"Gray", "Alpha"},
+	TruecolorAlpha: {"Red", "Green", "Blue", "Alpha"},
+}
+
+// Planes splits the unfiltered raster into one plane per channel, named in names: Red, Green,
+// Blue, Gray, Alpha, or Index for indexed-color whose palette isn't applied. Each plane holds the
+// width*height samples row by row at the image bit depth, unscaled: one byte per sample up to 8
+// bits, sub-byte samples being unpacked, and two big-endian bytes at 16 bits.
+func (p *Png) Planes() (planes [][]byte, names []string, err error) {
+	size, err := p.RawSize()
+	if err != nil {
+		return nil, nil, err
+	}
+	var raster = make([]byte, size)
+	if _, err = p.DecodeInto(raster); err != nil {
+		return nil, nil, err
+	}
+	p.RLock()
+	defer p.RUnlock()
+	var ihdr = p.IHDR
+	var channels, depth = ihdr.Channels(), ihdr.BitDepth
+	var width, height, stride = int(ihdr.Width), int(ihdr.Height), ihdr.rowBytes(int(ihdr.Width))
+	var n = 1
+	if depth == 16 {
+		n = 2
+	}
+	planes = make([][]byte, channels)
+	for c := range planes {
+		planes[c] = make([]byte, 0, width*height*n)
+	}
+	for y := 0; y < height; y++ {
+		var row = raster[y*stride : (y+1)*stride]
+		for x := 0; x < width; x++ {
+			for c := range planes {
+				var s = sample(row, x*channels+c, depth)
+				if depth == 16 {
+					planes[c] = append(planes[c], uint8(s>>8))
+				}
+				planes[c] = append(planes[c], uint8(s))
+			}
+		}
+	}
+	return planes, slices.Clone(channelNames[ihdr.ColorType]), nil
+}
+
+// putPixelBits copies pixel i of src to pixel x of dst, pixels being bits wide.
+func putPixelBits(dst, src []byte, x, i, bits int) {
+	if bits >= 8 {
+		var n = bits / 8
+		copy(dst[x*n:(x+1)*n], src[i*n:(i+1)*n])
+		return
+	}
+	var mask = uint8(1)<<bits - 1
+	var v = src[i*bits/8] >> (8 - bits - i*bits%8) & mask
+	var shift = 8 - bits - x*bits%8
+	dst[x*bits/8] = dst[x*bits/8]&^(mask<<shift) | v<<shift
+}
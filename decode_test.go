@@ -0,0 +1,1049 @@
+package simple_png
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math/rand"
+	"os"
+	"slices"
+	"testing"
+)
+
+// stdlibImages covers every color type and bit depth the standard library encoder can write.
+func stdlibImages() map[string]image.Image {
+	var rnd = rand.New(rand.NewSource(1))
+	var rect = image.Rect(0, 0, 19, 13)
+	var gray, gray16 = image.NewGray(rect), image.NewGray16(rect)
+	var rgba, nrgba = image.NewRGBA(rect), image.NewNRGBA(rect)
+	var rgba64, nrgba64 = image.NewRGBA64(rect), image.NewNRGBA64(rect)
+	var images = map[string]image.Image{
+		"gray": gray, "gray16": gray16, "rgb": rgba, "rgba": nrgba, "rgb16": rgba64, "rgba16": nrgba64,
+	}
+	for _, n := range []int{2, 4, 16, 256} {
+		for _, alpha := range []bool{false, true} {
+			var pal color.Palette
+			for i := 0; i < n; i++ {
+				var a uint8 = 0xff
+				if alpha && i%3 == 0 {
+					a = uint8(rnd.Intn(256))
+				}
+				pal = append(pal, color.NRGBA{R: uint8(rnd.Intn(256)), G: uint8(i), B: uint8(rnd.Intn(256)), A: a})
+			}
+			m := image.NewPaletted(rect, pal)
+			for i := range m.Pix {
+				m.Pix[i] = uint8(rnd.Intn(n))
+			}
+			images[fmt.Sprintf("paletted%d-trns=%v", n, alpha)] = m
+		}
+	}
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			v := uint16(rnd.Intn(1 << 16))
+			gray.SetGray(x, y, color.Gray{Y: uint8(v)})
+			gray16.SetGray16(x, y, color.Gray16{Y: v})
+			rgba.SetRGBA(x, y, color.RGBA{R: uint8(v), G: uint8(x), B: uint8(y), A: 0xff})
+			nrgba.SetNRGBA(x, y, color.NRGBA{R: uint8(v), G: uint8(x), B: uint8(y), A: uint8(v >> 8)})
+			rgba64.SetRGBA64(x, y, color.RGBA64{R: v, G: v ^ 0x5555, B: uint16(x * y), A: 0xffff})
+			nrgba64.SetNRGBA64(x, y, color.NRGBA64{R: v, G: v ^ 0x5555, B: uint16(x * y), A: v ^ 0xaaaa})
+		}
+	}
+	return images
+}
+
+func TestToImageStdlibEncoded(t *testing.T) {
+	for name, m := range stdlibImages() {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, m); err != nil {
+			t.Fatal(err)
+		}
+		p, err := ParsePng(&buf)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := p.ToImage()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		t.Run(name, func(t *testing.T) {
+			assertSamePixels(t, m, got)
+		})
+	}
+}
+
+// packRow packs samples MSB first at the given bit depth.
+func packRow(samples []uint16, depth uint8) []byte {
+	var row = make([]byte, (len(samples)*int(depth)+7)/8)
+	for i, s := range samples {
+		switch depth {
+		case 16:
+			row[2*i], row[2*i+1] = uint8(s>>8), uint8(s)
+		case 8:
+			row[i] = uint8(s)
+		default:
+			bit := i * int(depth)
+			row[bit/8] |= uint8(s) << (8 - int(depth) - bit%8)
+		}
+	}
+	return row
+}
+
+// encodeSamples compresses pixels (width*channels samples per row) into an IDAT datastream,
+// splitting them into Adam7 passes when the image is interlaced.
+func encodeSamples(ihdr *IHDR, pixels [][]uint16) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	var channels = ihdr.Channels()
+	for _, ps := range ihdr.passes() {
+		var prev = make([]byte, ihdr.rowBytes(ps.width))
+		for y := 0; y < ps.height; y++ {
+			var samples []uint16
+			for i := 0; i < ps.width; i++ {
+				x := ps.x0 + i*ps.dx
+				samples = append(samples, pixels[ps.y0+y*ps.dy][x*channels:(x+1)*channels]...)
+			}
+			row := packRow(samples, ihdr.BitDepth)
+			_, _ = zw.Write(adaptiveFilter(row, prev, ihdr.BytesPerPixel()))
+			prev = row
+		}
+	}
+	_ = zw.Close()
+	return buf.Bytes()
+}
+
+// randomPng builds a png for ihdr with random samples, a palette for color type 3 and an optional tRNS.
+func randomPng(rnd *rand.Rand, ihdr *IHDR, trns bool) []byte {
+	var max = 1<<ihdr.BitDepth - 1
+	var paletteSize = min(max+1, 200)
+	var channels = ihdr.Channels()
+	var pixels = make([][]uint16, ihdr.Height)
+	for y := range pixels {
+		for i := 0; i < int(ihdr.Width)*channels; i++ {
+			v := rnd.Intn(max + 1)
+			if ihdr.ColorType == Indexed {
+				v %= paletteSize
+			}
+			pixels[y] = append(pixels[y], uint16(v))
+		}
+	}
+	var chunks = []*chunk{ihdrChunk(ihdr)}
+	if ihdr.ColorType == Indexed {
+		var plte = make([]byte, paletteSize*3)
+		rnd.Read(plte)
+		chunks = append(chunks, newChunk(PLTEChunk, plte))
+	}
+	if trns {
+		var data []byte
+		switch ihdr.ColorType {
+		case Indexed:
+			data = make([]byte, paletteSize/2+1)
+			rnd.Read(data)
+		default:
+			// key on the first pixel so that at least one pixel is transparent
+			for c := 0; c < channels; c++ {
+				data = append(data, uint8(pixels[0][c]>>8), uint8(pixels[0][c]))
+			}
+		}
+		chunks = append(chunks, newChunk(TRNSChunk, data))
+	}
+	chunks = append(chunks, newChunk(IDATChunk, encodeSamples(ihdr, pixels)), newChunk(IENDChunk, nil))
+	return buildPng(chunks...)
+}
+
+func TestToImageMatrix(t *testing.T) {
+	var rnd = rand.New(rand.NewSource(2))
+	var sizes = [][2]uint32{{13, 11}, {1, 1}, {3, 9}, {33, 2}}
+	for ct, depths := range allowedBitDepths {
+		for _, depth := range depths {
+			for _, interlace := range []uint8{0, 1} {
+				for _, trns := range []bool{false, true} {
+					if trns && (ct == GrayscaleAlpha || ct == TruecolorAlpha) {
+						continue
+					}
+					for _, size := range sizes {
+						var ihdr = &IHDR{Width: size[0], Height: size[1], BitDepth: depth, ColorType: ct, InterlaceMethod: interlace}
+						var name = fmt.Sprintf("ct=%d,depth=%d,interlace=%d,trns=%v,%dx%d", ct, depth, interlace, trns, size[0], size[1])
+						raw := randomPng(rnd, ihdr, trns)
+						want, err := png.Decode(bytes.NewReader(raw))
+						if err != nil {
+							t.Fatalf("%s: %v", name, err)
+						}
+						p, err := ParsePng(bytes.NewReader(raw))
+						if err != nil {
+							t.Fatalf("%s: %v", name, err)
+						}
+						got, err := p.ToImage()
+						if err != nil {
+							t.Fatalf("%s: %v", name, err)
+						}
+						t.Run(name, func(t *testing.T) {
+							assertSamePixels(t, want, got)
+						})
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestStreamRGBA(t *testing.T) {
+	var rnd = rand.New(rand.NewSource(3))
+	for _, ihdr := range []*IHDR{
+		{Width: 9, Height: 5, BitDepth: 8, ColorType: Truecolor},
+		{Width: 9, Height: 5, BitDepth: 16, ColorType: TruecolorAlpha},
+		{Width: 9, Height: 5, BitDepth: 2, ColorType: Indexed},
+		{Width: 9, Height: 5, BitDepth: 8, ColorType: GrayscaleAlpha, InterlaceMethod: 1},
+	} {
+		p, err := ParsePng(bytes.NewReader(randomPng(rnd, ihdr, false)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err = p.StreamRGBA(&buf); err != nil {
+			t.Fatal(err)
+		}
+		img, err := p.ToImage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want []byte
+		for y := 0; y < int(ihdr.Height); y++ {
+			for x := 0; x < int(ihdr.Width); x++ {
+				var c color.NRGBA
+				switch m := img.(type) {
+				case *image.NRGBA:
+					c = m.NRGBAAt(x, y)
+				case *image.NRGBA64:
+					c64 := m.NRGBA64At(x, y)
+					c = color.NRGBA{R: uint8(c64.R >> 8), G: uint8(c64.G >> 8), B: uint8(c64.B >> 8), A: uint8(c64.A >> 8)}
+				default:
+					c = color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				}
+				want = append(want, c.R, c.G, c.B, c.A)
+			}
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Fatalf("ct=%d depth=%d: streamed rgba differs", ihdr.ColorType, ihdr.BitDepth)
+		}
+	}
+}
+
+func TestToImageAncillaryDefaults(t *testing.T) {
+	var ihdr = &IHDR{Width: 3, Height: 2, BitDepth: 8, ColorType: Truecolor}
+	var idat = blankIDAT(ihdr)
+	var gama = newChunk(GAMAChunk, []byte{0, 0, 0xb1, 0x8f})
+	var bkgd = newChunk(BKGDChunk, []byte{0, 1, 0, 2, 0, 3})
+	var trns = newChunk(TRNSChunk, []byte{0, 0, 0, 0, 0, 0})
+	var badTRNS = newChunk(TRNSChunk, []byte{0, 0})
+	var cases = []struct {
+		name   string
+		chunks []*chunk
+		opaque bool
+	}{
+		{"none", nil, true},
+		{"no gAMA", []*chunk{bkgd, trns}, false},
+		{"no bKGD", []*chunk{gama, trns}, false},
+		{"no tRNS", []*chunk{gama, bkgd}, true},
+		{"short tRNS", []*chunk{badTRNS}, true},
+	}
+	for _, c := range cases {
+		var chunks = append([]*chunk{ihdrChunk(ihdr)}, c.chunks...)
+		chunks = append(chunks, idat, newChunk(IENDChunk, nil))
+		p, err := ParsePng(bytes.NewReader(buildPng(chunks...)))
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		img, err := p.ToImage()
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if _, _, _, a := img.At(0, 0).RGBA(); (a == 0xffff) != c.opaque {
+			t.Fatalf("%s: alpha %d", c.name, a)
+		}
+	}
+
+	// a tRNS that was never parsed into p.TRNS decodes as fully opaque
+	p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), trns, idat, newChunk(IENDChunk, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.TRNS = nil
+	img, err := p.ToImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := img.(*image.RGBA); !ok {
+		t.Fatalf("decoded to %T, want *image.RGBA", img)
+	}
+}
+
+func TestDecodeInto(t *testing.T) {
+	var rnd = rand.New(rand.NewSource(4))
+	for _, ihdr := range []*IHDR{
+		{Width: 11, Height: 7, BitDepth: 2, ColorType: Grayscale},
+		{Width: 11, Height: 7, BitDepth: 16, ColorType: Truecolor},
+	} {
+		var interlaced = *ihdr
+		interlaced.InterlaceMethod = 1
+		var pixels = make([][]uint16, ihdr.Height)
+		for y := range pixels {
+			for i := 0; i < int(ihdr.Width)*ihdr.Channels(); i++ {
+				pixels[y] = append(pixels[y], uint16(rnd.Intn(1<<ihdr.BitDepth)))
+			}
+		}
+		var want []byte
+		for _, row := range pixels {
+			want = append(want, packRow(row, ihdr.BitDepth)...)
+		}
+		for _, h := range []*IHDR{ihdr, &interlaced} {
+			p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(h), newChunk(IDATChunk, encodeSamples(h, pixels)), newChunk(IENDChunk, nil))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			size, err := p.RawSize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size != len(want) {
+				t.Fatalf("RawSize %d, want %d", size, len(want))
+			}
+			if n, err := p.DecodeInto(make([]byte, size-1)); n != size || !errors.Is(err, io.ErrShortBuffer) {
+				t.Fatalf("short buffer: %d %v", n, err)
+			}
+			var dst = make([]byte, size)
+			for i := 0; i < 2; i++ {
+				n, err := p.DecodeInto(dst)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n != size || !bytes.Equal(dst, want) {
+					t.Fatalf("depth %d interlace %d: raster differs", h.BitDepth, h.InterlaceMethod)
+				}
+			}
+		}
+	}
+}
+
+func TestToRGBA64(t *testing.T) {
+	var cases = []struct {
+		ihdr   *IHDR
+		pixel  []uint16
+		chunks []*chunk
+		want   color.RGBA64
+	}{
+		{&IHDR{Width: 1, Height: 1, BitDepth: 1, ColorType: Grayscale}, []uint16{1}, nil, color.RGBA64{0xffff, 0xffff, 0xffff, 0xffff}},
+		{&IHDR{Width: 1, Height: 1, BitDepth: 4, ColorType: Grayscale}, []uint16{5}, nil, color.RGBA64{0x5555, 0x5555, 0x5555, 0xffff}},
+		{&IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Truecolor}, []uint16{255, 0, 128}, nil, color.RGBA64{0xffff, 0, 0x8080, 0xffff}},
+		{&IHDR{Width: 1, Height: 1, BitDepth: 16, ColorType: TruecolorAlpha}, []uint16{0xffff, 0x1234, 0, 0xffff}, nil, color.RGBA64{0xffff, 0x1234, 0, 0xffff}},
+		{&IHDR{Width: 1, Height: 1, BitDepth: 2, ColorType: Indexed}, []uint16{1}, []*chunk{newChunk(PLTEChunk, []byte{0, 0, 0, 255, 255, 255})}, color.RGBA64{0xffff, 0xffff, 0xffff, 0xffff}},
+		{&IHDR{Width: 1, Height: 1, BitDepth: 2, ColorType: Indexed}, []uint16{1}, []*chunk{newChunk(PLTEChunk, []byte{0, 0, 0, 255, 255, 255}), newChunk(TRNSChunk, []byte{255, 0})}, color.RGBA64{}},
+	}
+	for i, c := range cases {
+		var chunks = append([]*chunk{ihdrChunk(c.ihdr)}, c.chunks...)
+		chunks = append(chunks, newChunk(IDATChunk, encodeSamples(c.ihdr, [][]uint16{c.pixel})), newChunk(IENDChunk, nil))
+		p, err := ParsePng(bytes.NewReader(buildPng(chunks...)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := p.ToRGBA64()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := img.RGBA64At(0, 0); got != c.want {
+			t.Fatalf("case %d: got %v, want %v", i, got, c.want)
+		}
+	}
+}
+
+func TestToImageGrayAlpha(t *testing.T) {
+	var pixels = [][]uint16{{0, 0, 0x7f, 0x80, 0xff, 0xff}, {0x12, 0xff, 0xfe, 0, 0x40, 0x01}}
+	for _, depth := range []uint8{8, 16} {
+		var ihdr = &IHDR{Width: 3, Height: 2, BitDepth: depth, ColorType: GrayscaleAlpha}
+		var samples = pixels
+		if depth == 16 {
+			samples = [][]uint16{}
+			for _, row := range pixels {
+				var wide []uint16
+				for _, v := range row {
+					wide = append(wide, v<<8|v^0x5a)
+				}
+				samples = append(samples, wide)
+			}
+		}
+		var raw = buildPng(ihdrChunk(ihdr), newChunk(IDATChunk, encodeSamples(ihdr, samples)), newChunk(IENDChunk, nil))
+		want, err := png.Decode(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := ParsePng(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := p.ToImage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for y, row := range samples {
+			for x := 0; x < 3; x++ {
+				var g, a = row[2*x], row[2*x+1]
+				var c color.NRGBA64
+				switch img := got.(type) {
+				case *image.NRGBA:
+					if depth != 8 {
+						t.Fatalf("depth %d decoded to %T", depth, got)
+					}
+					c = nrgba64At(img, x, y)
+					g, a = g*0x101, a*0x101
+				case *image.NRGBA64:
+					if depth != 16 {
+						t.Fatalf("depth %d decoded to %T", depth, got)
+					}
+					c = img.NRGBA64At(x, y)
+				default:
+					t.Fatalf("decoded to %T", got)
+				}
+				if c != (color.NRGBA64{R: g, G: g, B: g, A: a}) {
+					t.Fatalf("depth %d: pixel (%d,%d) = %v, want gray %#x alpha %#x", depth, x, y, c, g, a)
+				}
+				if w := nrgba64At(want, x, y); w != c {
+					t.Fatalf("depth %d: pixel (%d,%d) = %v, image/png decodes %v", depth, x, y, c, w)
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeAs(t *testing.T) {
+	var ihdr = &IHDR{Width: 2, Height: 1, BitDepth: 8, ColorType: TruecolorAlpha}
+	var raw = buildPng(ihdrChunk(ihdr), newChunk(IDATChunk, encodeSamples(ihdr, [][]uint16{{200, 100, 50, 0, 10, 20, 30, 128}})), newChunk(IENDChunk, nil))
+	p, err := ParsePng(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var models = []struct {
+		model color.Model
+		want  color.Color
+	}{
+		{color.NRGBAModel, color.NRGBA{R: 200, G: 100, B: 50, A: 0}},
+		{color.NRGBA64Model, color.NRGBA64{R: 200 * 0x101, G: 100 * 0x101, B: 50 * 0x101, A: 0}},
+		{color.RGBAModel, color.RGBA{}},
+		{color.RGBA64Model, color.RGBA64{}},
+		{color.GrayModel, color.Gray{}},
+		{color.Gray16Model, color.Gray16{}},
+	}
+	for _, m := range models {
+		img, err := p.DecodeAs(m.model)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.ColorModel() != m.model {
+			t.Fatalf("%T: got model of %T", m.want, img)
+		}
+		if got := img.At(0, 0); got != m.want {
+			t.Fatalf("%T: got %v, want %v", m.want, got, m.want)
+		}
+		if want := m.model.Convert(color.NRGBA64{R: 10 * 0x101, G: 20 * 0x101, B: 30 * 0x101, A: 128 * 0x101}); img.At(1, 0) != want {
+			t.Fatalf("%T: got %v, want %v", m.want, img.At(1, 0), want)
+		}
+	}
+	if _, err = p.DecodeAs(color.AlphaModel); err == nil {
+		t.Fatal("unsupported model accepted")
+	}
+}
+
+func TestDecodeAsPaletted(t *testing.T) {
+	var pal = color.Palette{color.NRGBA{R: 255, A: 255}, color.NRGBA{B: 255, A: 128}}
+	var src = image.NewPaletted(image.Rect(0, 0, 2, 1), pal)
+	src.SetColorIndex(1, 0, 1)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	p, err := ParsePng(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.DecodeAs(pal); err == nil {
+		t.Fatal("palette model accepted")
+	}
+	img, err := p.DecodeAs(color.NRGBAModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.At(1, 0); got != pal[1] {
+		t.Fatalf("got %v, want %v", got, pal[1])
+	}
+}
+
+func TestDecodeRegion(t *testing.T) {
+	var rnd = rand.New(rand.NewSource(6))
+	for _, ihdr := range []*IHDR{
+		{Width: 17, Height: 13, BitDepth: 8, ColorType: TruecolorAlpha},
+		{Width: 17, Height: 13, BitDepth: 4, ColorType: Indexed},
+		{Width: 17, Height: 13, BitDepth: 16, ColorType: Grayscale, InterlaceMethod: 1},
+	} {
+		raw := randomPng(rnd, ihdr, false)
+		p, err := ParsePng(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		full, err := p.ToImage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range []image.Rectangle{image.Rect(3, 2, 9, 7), image.Rect(0, 0, 17, 1), image.Rect(10, 10, 40, 40)} {
+			got, err := p.DecodeRegion(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := full.(interface {
+				SubImage(image.Rectangle) image.Image
+			}).SubImage(r)
+			assertSamePixels(t, want, got)
+		}
+		if _, err = p.DecodeRegion(image.Rect(20, 20, 30, 30)); err == nil {
+			t.Fatal("region outside the image accepted")
+		}
+	}
+
+	// rows below the region are never read, so a truncated datastream still decodes the top
+	var ihdr = &IHDR{Width: 64, Height: 64, BitDepth: 8, ColorType: Truecolor}
+	raw := randomPng(rnd, ihdr, false)
+	p, err := ParsePng(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stream = p.IDATs[0].Data
+	p.IDATs[0].Data = stream[:len(stream)/2]
+	if _, err = p.DecodeRegion(image.Rect(0, 0, 64, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.ToImage(); err == nil {
+		t.Fatal("truncated datastream decoded")
+	}
+}
+
+func TestDecodeBands(t *testing.T) {
+	var rnd = rand.New(rand.NewSource(7))
+	for _, ihdr := range []*IHDR{
+		{Width: 19, Height: 23, BitDepth: 8, ColorType: TruecolorAlpha},
+		{Width: 19, Height: 23, BitDepth: 1, ColorType: Grayscale},
+		{Width: 19, Height: 23, BitDepth: 16, ColorType: Truecolor, InterlaceMethod: 1},
+	} {
+		p, err := ParsePng(bytes.NewReader(randomPng(rnd, ihdr, false)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		full, err := p.ToImage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, h := range []int{1, 5, 23, 100} {
+			bands, err := p.DecodeBands(h)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(bands) != (23+h-1)/h {
+				t.Fatalf("%d bands of %d rows", len(bands), h)
+			}
+			for _, band := range bands {
+				assertSamePixels(t, full.(interface {
+					SubImage(image.Rectangle) image.Image
+				}).SubImage(band.Bounds()), band)
+			}
+		}
+	}
+}
+
+func benchmarkPng(b *testing.B) *Png {
+	var ihdr = &IHDR{Width: 2048, Height: 2048, BitDepth: 8, ColorType: TruecolorAlpha}
+	p, err := ParsePng(bytes.NewReader(randomPng(rand.New(rand.NewSource(8)), ihdr, false)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return p
+}
+
+func BenchmarkToImage(b *testing.B) {
+	var p = benchmarkPng(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.ToImage(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeBands(b *testing.B) {
+	var p = benchmarkPng(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.DecodeBands(256); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkDecodeVsStdlib decodes the same fixtures by ParsePng and ToImage and by image/png,
+// after checking both give the same pixels.
+func BenchmarkDecodeVsStdlib(b *testing.B) {
+	demo, err := os.ReadFile("./demo.png")
+	if err != nil {
+		b.Fatal(err)
+	}
+	var rnd = rand.New(rand.NewSource(8))
+	var fixtures = []struct {
+		name string
+		raw  []byte
+	}{
+		{"demo", demo},
+		{"rgba8", randomPng(rnd, &IHDR{Width: 512, Height: 512, BitDepth: 8, ColorType: TruecolorAlpha}, false)},
+		{"rgb16", randomPng(rnd, &IHDR{Width: 512, Height: 512, BitDepth: 16, ColorType: Truecolor}, false)},
+		{"gray2", randomPng(rnd, &IHDR{Width: 512, Height: 512, BitDepth: 2, ColorType: Grayscale}, false)},
+		{"indexed4", randomPng(rnd, &IHDR{Width: 512, Height: 512, BitDepth: 4, ColorType: Indexed}, true)},
+		{"interlaced", randomPng(rnd, &IHDR{Width: 512, Height: 512, BitDepth: 8, ColorType: Truecolor, InterlaceMethod: 1}, false)},
+	}
+	for _, f := range fixtures {
+		p, err := ParsePng(bytes.NewReader(f.raw))
+		if err != nil {
+			b.Fatal(err)
+		}
+		got, err := p.ToImage()
+		if err != nil {
+			b.Fatal(err)
+		}
+		want, err := png.Decode(bytes.NewReader(f.raw))
+		if err != nil {
+			b.Fatal(err)
+		}
+		assertSamePixels(b, want, got)
+
+		b.Run(f.name+"/simple-png", func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(f.raw)))
+			for i := 0; i < b.N; i++ {
+				p, err := ParsePng(bytes.NewReader(f.raw))
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err = p.ToImage(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(f.name+"/image-png", func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(f.raw)))
+			for i := 0; i < b.N; i++ {
+				if _, err := png.Decode(bytes.NewReader(f.raw)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestRecoverOriginalSamples(t *testing.T) {
+	// 5-6-5 source samples scaled to 8 bits by left bit replication
+	var ihdr = &IHDR{Width: 3, Height: 2, BitDepth: 8, ColorType: Truecolor}
+	var orig = [][]uint16{{31, 63, 0, 1, 2, 3, 16, 32, 8}, {0, 0, 31, 30, 60, 29, 4, 5, 6}}
+	var scaled [][]uint16
+	for _, row := range orig {
+		var r []uint16
+		for i, v := range row {
+			var bits = []uint16{5, 6, 5}[i%3]
+			r = append(r, v<<(8-bits)|v>>(2*bits-8))
+		}
+		scaled = append(scaled, r)
+	}
+	var raw = buildPng(ihdrChunk(ihdr), newChunk(SBITChunk, []byte{5, 6, 5}),
+		newChunk(IDATChunk, encodeSamples(ihdr, scaled)), newChunk(IENDChunk, nil))
+	p, err := ParsePng(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.RecoverOriginalSamples()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := slices.Concat(orig...); !slices.Equal(got, want) {
+		t.Fatalf("samples %v, want %v", got, want)
+	}
+
+	ihdr = &IHDR{Width: 2, Height: 1, BitDepth: 2, ColorType: Indexed}
+	raw = buildPng(ihdrChunk(ihdr), newChunk(SBITChunk, []byte{4, 4, 4}), newChunk(PLTEChunk, []byte{0xff, 0x10, 0x00, 0x88, 0x77, 0x66}),
+		newChunk(IDATChunk, encodeSamples(ihdr, [][]uint16{{1, 0}})), newChunk(IENDChunk, nil))
+	if p, err = ParsePng(bytes.NewReader(raw)); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = p.RecoverOriginalSamples(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint16{8, 7, 6, 15, 1, 0}; !slices.Equal(got, want) {
+		t.Fatalf("indexed samples %v, want %v", got, want)
+	}
+}
+
+func TestToImagePremultiplied(t *testing.T) {
+	for _, depth := range []uint8{8, 16} {
+		var ihdr = &IHDR{Width: 1, Height: 1, BitDepth: depth, ColorType: TruecolorAlpha}
+		var max, half = uint16(1)<<depth - 1, uint16(1) << (depth - 1)
+		var raw = buildPng(ihdrChunk(ihdr), newChunk(IDATChunk, encodeSamples(ihdr, [][]uint16{{max, 0, 0, half}})), newChunk(IENDChunk, nil))
+		p, err := ParsePng(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := p.ToImage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pre, err := p.ToImage(WithPremultiplied())
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch depth {
+		case 8:
+			if c := img.(*image.NRGBA).NRGBAAt(0, 0); c != (color.NRGBA{R: 0xff, A: 0x80}) {
+				t.Fatalf("NRGBA %v", c)
+			}
+			if c := pre.(*image.RGBA).RGBAAt(0, 0); c != (color.RGBA{R: 0x80, A: 0x80}) {
+				t.Fatalf("RGBA %v", c)
+			}
+		case 16:
+			if c := img.(*image.NRGBA64).NRGBA64At(0, 0); c != (color.NRGBA64{R: 0xffff, A: 0x8000}) {
+				t.Fatalf("NRGBA64 %v", c)
+			}
+			if c := pre.(*image.RGBA64).RGBA64At(0, 0); c != (color.RGBA64{R: 0x8000, A: 0x8000}) {
+				t.Fatalf("RGBA64 %v", c)
+			}
+		}
+	}
+}
+
+func TestMaxPixels(t *testing.T) {
+	// the IDAT is never read, the IHDR alone has to be rejected
+	var ihdr = &IHDR{Width: 60000, Height: 60000, BitDepth: 8, ColorType: TruecolorAlpha}
+	p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), newChunk(IDATChunk, []byte{0x78, 0x9c}), newChunk(IENDChunk, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.ToImage(); err == nil {
+		t.Fatal("ToImage accepted 3.6 billion pixels")
+	}
+	if _, err = p.RawSize(); err == nil {
+		t.Fatal("RawSize accepted 3.6 billion pixels")
+	}
+
+	ihdr = &IHDR{Width: 20, Height: 20, BitDepth: 8, ColorType: Grayscale}
+	if p, err = ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), blankIDAT(ihdr), newChunk(IENDChunk, nil)))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.ToImage(MaxPixels(399)); err == nil {
+		t.Fatal("MaxPixels(399) accepted 400 pixels")
+	}
+	if _, err = p.ToImage(MaxPixels(400)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.RawSize(MaxPixels(0)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackgroundColor(t *testing.T) {
+	var cases = []struct {
+		ihdr   *IHDR
+		chunks []*chunk
+		want   color.Color
+	}{
+		{&IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Indexed},
+			[]*chunk{newChunk(PLTEChunk, []byte{1, 2, 3, 4, 5, 6}), newChunk(BKGDChunk, []byte{1})}, color.RGBA{R: 4, G: 5, B: 6, A: 0xff}},
+		{&IHDR{Width: 1, Height: 1, BitDepth: 2, ColorType: Grayscale},
+			[]*chunk{newChunk(BKGDChunk, []byte{0, 1})}, color.Gray{Y: 0x55}},
+		{&IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Grayscale},
+			[]*chunk{newChunk(BKGDChunk, []byte{0, 128})}, color.Gray{Y: 128}},
+		{&IHDR{Width: 1, Height: 1, BitDepth: 4, ColorType: Grayscale},
+			[]*chunk{newChunk(BKGDChunk, []byte{0, 0xf})}, color.Gray{Y: 0xff}},
+		{&IHDR{Width: 1, Height: 1, BitDepth: 16, ColorType: GrayscaleAlpha},
+			[]*chunk{newChunk(BKGDChunk, []byte{0x12, 0x34})}, color.Gray16{Y: 0x1234}},
+		{&IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: TruecolorAlpha},
+			[]*chunk{newChunk(BKGDChunk, []byte{0, 0xff, 0, 0x80, 0, 0})}, color.RGBA64{R: 0xffff, G: 0x8080, A: 0xffff}},
+		// out of range
+		{&IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Indexed},
+			[]*chunk{newChunk(PLTEChunk, []byte{1, 2, 3}), newChunk(BKGDChunk, []byte{1})}, nil},
+		{&IHDR{Width: 1, Height: 1, BitDepth: 1, ColorType: Grayscale},
+			[]*chunk{newChunk(BKGDChunk, []byte{0, 2})}, nil},
+		{&IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Grayscale}, nil, nil},
+		// invalid bit depths, 0 used to divide by zero
+		{&IHDR{Width: 1, Height: 1, BitDepth: 0, ColorType: Grayscale},
+			[]*chunk{newChunk(BKGDChunk, []byte{0, 0})}, nil},
+		{&IHDR{Width: 1, Height: 1, BitDepth: 0, ColorType: Truecolor},
+			[]*chunk{newChunk(BKGDChunk, []byte{0, 0, 0, 0, 0, 0})}, nil},
+		{&IHDR{Width: 1, Height: 1, BitDepth: 4, ColorType: Truecolor},
+			[]*chunk{newChunk(BKGDChunk, []byte{0, 1, 0, 1, 0, 1})}, nil},
+	}
+	for i, c := range cases {
+		var chunks = append([]*chunk{ihdrChunk(c.ihdr)}, c.chunks...)
+		p, err := ParsePng(bytes.NewReader(buildPng(append(chunks, blankIDAT(c.ihdr), newChunk(IENDChunk, nil))...)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := p.BackgroundColor()
+		if ok != (c.want != nil) || got != c.want && ok {
+			t.Fatalf("case %d: %v, %v, want %v", i, got, ok, c.want)
+		}
+	}
+}
+
+func TestPassDimensions(t *testing.T) {
+	var p = NewPng(&IHDR{Width: 10, Height: 9, BitDepth: 8, ColorType: Grayscale, InterlaceMethod: 1})
+	var want = [][2]int{{2, 2}, {1, 2}, {3, 1}, {2, 3}, {5, 2}, {5, 5}, {10, 4}}
+	if p.Passes() != 7 || !slices.Equal(p.PassDimensions(), want) {
+		t.Fatalf("%d passes %v, want %v", p.Passes(), p.PassDimensions(), want)
+	}
+	p = NewPng(&IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Grayscale, InterlaceMethod: 1})
+	if want = [][2]int{{1, 1}, {}, {}, {}, {}, {}, {}}; !slices.Equal(p.PassDimensions(), want) {
+		t.Fatalf("1x1 passes %v", p.PassDimensions())
+	}
+	p = NewPng(&IHDR{Width: 10, Height: 9, BitDepth: 8, ColorType: Grayscale})
+	if want = [][2]int{{10, 9}}; p.Passes() != 1 || !slices.Equal(p.PassDimensions(), want) {
+		t.Fatalf("%d passes %v", p.Passes(), p.PassDimensions())
+	}
+}
+
+func TestMissingPLTE(t *testing.T) {
+	var ihdr = &IHDR{Width: 4, Height: 3, BitDepth: 2, ColorType: Indexed}
+	p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), blankIDAT(ihdr), newChunk(IENDChunk, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.ToImage(); !errors.Is(err, ErrMissingPLTE) {
+		t.Fatalf("ToImage: %v", err)
+	}
+	if err = p.StreamRGBA(io.Discard); !errors.Is(err, ErrMissingPLTE) {
+		t.Fatalf("StreamRGBA: %v", err)
+	}
+	if _, err = p.RecoverOriginalSamples(); !errors.Is(err, ErrMissingPLTE) {
+		t.Fatalf("RecoverOriginalSamples: %v", err)
+	}
+	if err = p.Validate(); !errors.Is(err, ErrMissingPLTE) {
+		t.Fatalf("Validate: %v", err)
+	}
+	if err = p.Renderable(); !errors.Is(err, ErrMissingPLTE) {
+		t.Fatalf("Renderable: %v", err)
+	}
+}
+
+func TestShortTRNS(t *testing.T) {
+	var ihdr = &IHDR{Width: 4, Height: 1, BitDepth: 8, ColorType: Indexed}
+	p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr),
+		newChunk(PLTEChunk, []byte{10, 0, 0, 20, 0, 0, 30, 0, 0, 40, 0, 0}), newChunk(TRNSChunk, []byte{0, 0x80}),
+		newChunk(IDATChunk, encodeSamples(ihdr, [][]uint16{{0, 1, 2, 3}})), newChunk(IENDChunk, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want = []uint8{0, 0x80, 0xff, 0xff}
+	var pal = p.Palette()
+	if len(pal) != 4 {
+		t.Fatalf("%d palette entries", len(pal))
+	}
+	img, err := p.ToImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, a := range want {
+		if c := pal[i].(color.NRGBA); c.A != a || c.R != uint8(10*(i+1)) {
+			t.Fatalf("palette entry %d = %v, want alpha %d", i, c, a)
+		}
+		if c := color.NRGBAModel.Convert(img.At(i, 0)).(color.NRGBA); c.A != a {
+			t.Fatalf("pixel %d = %v, want alpha %d", i, c, a)
+		}
+	}
+}
+
+func TestDownsample16to8(t *testing.T) {
+	var gray = &IHDR{Width: 3, Height: 1, BitDepth: 16, ColorType: Grayscale}
+	p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(gray), newChunk(IDATChunk, encodeSamples(gray, [][]uint16{{0, 0xffff, 0x12ab}})), newChunk(IENDChunk, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := p.ToImage(Downsample16to8())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, ok := img.(*image.Gray); !ok || !slices.Equal(g.Pix, []byte{0, 0xff, 0x12}) {
+		t.Fatalf("got %T %v", img, img)
+	}
+
+	var rnd = rand.New(rand.NewSource(5))
+	var want = map[ColorType]string{Grayscale: "*image.Gray", Truecolor: "*image.RGBA", GrayscaleAlpha: "*image.NRGBA", TruecolorAlpha: "*image.NRGBA"}
+	for ct, typ := range want {
+		for _, trns := range []bool{false, true} {
+			if trns && (ct == GrayscaleAlpha || ct == TruecolorAlpha) {
+				continue
+			}
+			var ihdr = &IHDR{Width: 9, Height: 5, BitDepth: 16, ColorType: ct, InterlaceMethod: uint8(rnd.Intn(2))}
+			p, err := ParsePng(bytes.NewReader(randomPng(rnd, ihdr, trns)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			full, err := p.ToImage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			narrow, err := p.ToImage(Downsample16to8())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if trns {
+				typ = "*image.NRGBA"
+			}
+			if got := fmt.Sprintf("%T", narrow); got != typ {
+				t.Fatalf("ct=%d,trns=%v: %s, want %s", ct, trns, got, typ)
+			}
+			for y := 0; y < 5; y++ {
+				for x := 0; x < 9; x++ {
+					var c = nrgba64At(full, x, y)
+					var w = color.NRGBA{R: uint8(c.R >> 8), G: uint8(c.G >> 8), B: uint8(c.B >> 8), A: uint8(c.A >> 8)}
+					if got := color.NRGBAModel.Convert(narrow.At(x, y)); got != w {
+						t.Fatalf("ct=%d,trns=%v (%d,%d): %v, want %v", ct, trns, x, y, got, w)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestAlphaMask(t *testing.T) {
+	var rnd = rand.New(rand.NewSource(11))
+	for ct, depths := range allowedBitDepths {
+		for _, depth := range depths {
+			for _, trns := range []bool{false, true} {
+				if trns && (ct == GrayscaleAlpha || ct == TruecolorAlpha) {
+					continue
+				}
+				var ihdr = &IHDR{Width: 13, Height: 7, BitDepth: depth, ColorType: ct, InterlaceMethod: uint8(rnd.Intn(2))}
+				p, err := ParsePng(bytes.NewReader(randomPng(rnd, ihdr, trns)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				mask, err := p.AlphaMask()
+				if err != nil {
+					t.Fatal(err)
+				}
+				img, err := p.ToImage()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if mask.Bounds() != img.Bounds() {
+					t.Fatalf("mask bounds %v, image %v", mask.Bounds(), img.Bounds())
+				}
+				for y := 0; y < 7; y++ {
+					for x := 0; x < 13; x++ {
+						if want := uint8(nrgba64At(img, x, y).A >> 8); mask.AlphaAt(x, y).A != want {
+							t.Fatalf("ct=%d,depth=%d,trns=%v (%d,%d): alpha %d, want %d", ct, depth, trns, x, y, mask.AlphaAt(x, y).A, want)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestPlanes(t *testing.T) {
+	var cases = []struct {
+		ihdr   *IHDR
+		pixels [][]uint16
+		names  []string
+		planes [][]byte
+	}{
+		{&IHDR{Width: 2, Height: 2, BitDepth: 8, ColorType: TruecolorAlpha}, [][]uint16{{1, 2, 3, 4, 5, 6, 7, 8}, {9, 10, 11, 12, 13, 14, 15, 16}},
+			[]string{"Red", "Green", "Blue", "Alpha"}, [][]byte{{1, 5, 9, 13}, {2, 6, 10, 14}, {3, 7, 11, 15}, {4, 8, 12, 16}}},
+		{&IHDR{Width: 3, Height: 1, BitDepth: 16, ColorType: GrayscaleAlpha}, [][]uint16{{0x1234, 0xffff, 0, 1, 0xabcd, 0x8000}},
+			[]string{"Gray", "Alpha"}, [][]byte{{0x12, 0x34, 0, 0, 0xab, 0xcd}, {0xff, 0xff, 0, 1, 0x80, 0}}},
+		{&IHDR{Width: 5, Height: 2, BitDepth: 2, ColorType: Grayscale, InterlaceMethod: 1}, [][]uint16{{0, 1, 2, 3, 2}, {3, 3, 0, 1, 1}},
+			[]string{"Gray"}, [][]byte{{0, 1, 2, 3, 2, 3, 3, 0, 1, 1}}},
+	}
+	for i, c := range cases {
+		p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(c.ihdr),
+			newChunk(IDATChunk, encodeSamples(c.ihdr, c.pixels)), newChunk(IENDChunk, nil))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		planes, names, err := p.Planes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(names, c.names) {
+			t.Fatalf("case %d: names %v", i, names)
+		}
+		if len(planes) != len(c.planes) {
+			t.Fatalf("case %d: %d planes", i, len(planes))
+		}
+		for j := range planes {
+			if !bytes.Equal(planes[j], c.planes[j]) {
+				t.Fatalf("case %d: plane %s is %v, want %v", i, names[j], planes[j], c.planes[j])
+			}
+		}
+	}
+}
+
+func TestTruecolorColorKey(t *testing.T) {
+	for _, depth := range []uint8{8, 16} {
+		var ihdr = &IHDR{Width: 3, Height: 1, BitDepth: depth, ColorType: Truecolor}
+		// the keyed color, then one differing in blue only, then another color
+		var pixels = [][]uint16{{10, 20, 30, 10, 20, 31, 200, 100, 0}}
+		var key = []byte{0, 10, 0, 20, 0, 30}
+		if depth == 16 {
+			pixels = [][]uint16{{0x0a0b, 0x1415, 0x1e1f, 0x0a0b, 0x1415, 0x1e1e, 0xffff, 0, 0x8000}}
+			key = []byte{0x0a, 0x0b, 0x14, 0x15, 0x1e, 0x1f}
+		}
+		var idat = newChunk(IDATChunk, encodeSamples(ihdr, pixels))
+		plain, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), idat, newChunk(IENDChunk, nil))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyed, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), newChunk(TRNSChunk, key), idat, newChunk(IENDChunk, nil))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		opaque, err := plain.ToImage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := keyed.ToImage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch depth {
+		case 8:
+			_, okOpaque := opaque.(*image.RGBA)
+			_, okKeyed := img.(*image.NRGBA)
+			if !okOpaque || !okKeyed {
+				t.Fatalf("depth 8 decoded to %T and %T", opaque, img)
+			}
+		case 16:
+			_, okOpaque := opaque.(*image.RGBA64)
+			_, okKeyed := img.(*image.NRGBA64)
+			if !okOpaque || !okKeyed {
+				t.Fatalf("depth 16 decoded to %T and %T", opaque, img)
+			}
+		}
+		for x, want := range []uint16{0, 0xffff, 0xffff} {
+			if _, _, _, a := opaque.At(x, 0).RGBA(); a != 0xffff {
+				t.Fatalf("depth %d: pixel %d alpha %#x without tRNS", depth, x, a)
+			}
+			var c = nrgba64At(img, x, 0)
+			if c.A != want {
+				t.Fatalf("depth %d: pixel %d alpha %#x, want %#x", depth, x, c.A, want)
+			}
+			if r, _, _, _ := opaque.At(x, 0).RGBA(); r != uint32(c.R) {
+				t.Fatalf("depth %d: pixel %d red %#x, want %#x", depth, x, c.R, r)
+			}
+		}
+	}
+}
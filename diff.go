@@ -0,0 +1,199 @@
+package simple_png
+
+import (
+	"bytes"
+	"slices"
+
+	"github.com/pkg/errors"
+)
+
+// DiffOp is the kind of change a ChunkDiff records.
+type DiffOp uint8
+
+const (
+	ChunkAdded DiffOp = iota + 1
+	ChunkRemoved
+	ChunkChanged
+)
+
+func (o DiffOp) String() string {
+	switch o {
+	case ChunkAdded:
+		return "added"
+	case ChunkRemoved:
+		return "removed"
+	case ChunkChanged:
+		return "changed"
+	}
+	return "unknown"
+}
+
+// ChunkDiff is one chunk level change between two pngs, see Diff.
+type ChunkDiff struct {
+	Op   DiffOp
+	Name ChunkName
+	// Index counts the chunks named Name before this one, in the old png for removed and
+	// changed chunks, in the new png for added ones
+	Index int
+	// Position is the index of an added chunk among all chunks of the new png
+	Position int
+	// Data is the new chunk data, nil for removed chunks
+	Data []byte
+}
+
+// chunkKey names a chunk by its type and how many chunks of that type precede it.
+type chunkKey struct {
+	name  ChunkName
+	index int
+}
+
+func chunkKeys(chunks []*chunk) []chunkKey {
+	var seen = map[ChunkName]int{}
+	var keys = make([]chunkKey, len(chunks))
+	for i, c := range chunks {
+		var name = ChunkName(c.code[:])
+		keys[i] = chunkKey{name, seen[name]}
+		seen[name]++
+	}
+	return keys
+}
+
+// Diff reports the chunks to add, remove and change to turn p into other. Chunks are matched
+// by type and occurrence, the second tEXt of p against the second tEXt of other, and compared
+// by data. A matched chunk that moved relative to the others is reported as removed and added
+// again, so ApplyPatch on a copy of p always reproduces the chunk order of other.
+func (p *Png) Diff(other *Png) []ChunkDiff {
+	// chunks are replaced, never edited in place, so copies of the lists taken one lock at a
+	// time are consistent, and a.Diff(b) can't deadlock against b.Diff(a)
+	var snapshot = func(p *Png) []*chunk {
+		p.RLock()
+		defer p.RUnlock()
+		return slices.Clone(p.chunks)
+	}
+	var oldChunks, newChunks = snapshot(p), snapshot(other)
+	var oldKeys, newKeys = chunkKeys(oldChunks), chunkKeys(newChunks)
+	var newPos = make(map[chunkKey]int, len(newKeys))
+	for i, k := range newKeys {
+		newPos[k] = i
+	}
+
+	// the matched chunks keeping their order are the longest increasing run of new positions
+	var matched = make([]int, len(oldKeys))
+	for i, k := range oldKeys {
+		if j, ok := newPos[k]; ok {
+			matched[i] = j
+		} else {
+			matched[i] = -1
+		}
+	}
+	var keep = longestIncreasing(matched)
+
+	var diffs []ChunkDiff
+	var kept = make([]bool, len(newKeys))
+	for i, k := range oldKeys {
+		if !keep[i] {
+			diffs = append(diffs, ChunkDiff{Op: ChunkRemoved, Name: k.name, Index: k.index})
+			continue
+		}
+		var j = matched[i]
+		kept[j] = true
+		if !bytes.Equal(oldChunks[i].data, newChunks[j].data) {
+			diffs = append(diffs, ChunkDiff{Op: ChunkChanged, Name: k.name, Index: k.index,
+				Data: slices.Clone(newChunks[j].data)})
+		}
+	}
+	for j, k := range newKeys {
+		if !kept[j] {
+			diffs = append(diffs, ChunkDiff{Op: ChunkAdded, Name: k.name, Index: k.index, Position: j,
+				Data: slices.Clone(newChunks[j].data)})
+		}
+	}
+	return diffs
+}
+
+// longestIncreasing marks a longest strictly increasing subsequence of the non-negative values
+// of s.
+func longestIncreasing(s []int) []bool {
+	// tails[n] is the index in s ending the best run of length n+1 found so far
+	var tails []int
+	var prev = make([]int, len(s))
+	for i, v := range s {
+		if v < 0 {
+			continue
+		}
+		n, _ := slices.BinarySearchFunc(tails, v, func(t, v int) int { return s[t] - v })
+		prev[i] = -1
+		if n > 0 {
+			prev[i] = tails[n-1]
+		}
+		if n == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[n] = i
+		}
+	}
+	var keep = make([]bool, len(s))
+	if len(tails) > 0 {
+		for i := tails[len(tails)-1]; i >= 0; i = prev[i] {
+			keep[i] = true
+		}
+	}
+	return keep
+}
+
+// ApplyPatch applies a diff made by Diff. Removed and changed chunks must exist in p, added
+// chunks are inserted at their Position once the others are applied. The patched chunks are
+// parsed again as ParsePng would, on error p is left as it was.
+func (p *Png) ApplyPatch(patch []ChunkDiff) error {
+	p.Lock()
+	defer p.Unlock()
+	var keys = chunkKeys(p.chunks)
+	var at = make(map[chunkKey]int, len(keys))
+	for i, k := range keys {
+		at[k] = i
+	}
+
+	var chunks = slices.Clone(p.chunks)
+	var removed = make([]bool, len(chunks))
+	var added []ChunkDiff
+	var critical bool
+	for _, d := range patch {
+		if d.Name.IsCritical() {
+			critical = true
+		}
+		if d.Op == ChunkAdded {
+			added = append(added, d)
+			continue
+		}
+		i, ok := at[chunkKey{d.Name, d.Index}]
+		if !ok || removed[i] {
+			return errors.Errorf("%s %s chunk %d not found", d.Op, d.Name, d.Index)
+		}
+		switch d.Op {
+		case ChunkRemoved:
+			removed[i] = true
+		case ChunkChanged:
+			chunks[i] = chunks[i].withData(slices.Clone(d.Data))
+		default:
+			return errors.Errorf("unknown diff op %d", d.Op)
+		}
+	}
+
+	var patched = make([]*chunk, 0, len(chunks)+len(added))
+	for i, c := range chunks {
+		if !removed[i] {
+			patched = append(patched, c)
+		}
+	}
+	slices.SortStableFunc(added, func(a, b ChunkDiff) int { return a.Position - b.Position })
+	for _, d := range added {
+		var pos = min(max(d.Position, 0), len(patched))
+		patched = slices.Insert(patched, pos, newChunk(d.Name, slices.Clone(d.Data)))
+	}
+
+	if err := p.reparse(patched); err != nil {
+		return err
+	}
+	p.modified = p.modified || critical
+	return nil
+}
@@ -0,0 +1,110 @@
+package simple_png
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	var ihdr = &IHDR{Width: 2, Height: 2, BitDepth: 8, ColorType: Grayscale}
+	var gama = newChunk(GAMAChunk, []byte{0, 0, 0xb1, 0x8f})
+	var text1, text2 = newChunk(TEXTChunk, []byte("Title\x00a")), newChunk(TEXTChunk, []byte("Author\x00b"))
+	var unknown = newChunk("prVt", []byte("private"))
+	var tm = newChunk(TIMEChunk, []byte{0x07, 0xe8, 1, 2, 3, 4, 5})
+	a, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), gama, unknown, blankIDAT(ihdr),
+		text1, text2, newChunk(IENDChunk, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), newChunk(GAMAChunk, []byte{0, 1, 0x86, 0xa0}),
+		tm, blankIDAT(ihdr), text1, newChunk(TEXTChunk, []byte("Author\x00c")), unknown, newChunk(IENDChunk, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := a.Diff(a); len(d) != 0 {
+		t.Fatalf("diff against itself: %+v", d)
+	}
+
+	var patch = a.Diff(b)
+	var ops = map[DiffOp]int{}
+	for _, d := range patch {
+		ops[d.Op]++
+	}
+	// gAMA and the second tEXt change, the moved private chunk is removed and added again
+	if ops[ChunkChanged] != 2 || ops[ChunkRemoved] != 1 || ops[ChunkAdded] != 2 {
+		t.Fatalf("patch %+v", patch)
+	}
+	if err = a.ApplyPatch(patch); err != nil {
+		t.Fatal(err)
+	}
+	var got, want bytes.Buffer
+	if err = a.WritePng(&got); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.WritePng(&want); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Fatal("patched png differs from the target")
+	}
+	if a.GAMA == nil || a.GAMA.ImageGamma != 100000 || a.TIME == nil || len(a.TEXTs) != 2 || a.TEXTs[1].Text != "c" {
+		t.Fatalf("typed chunks not updated: %+v %+v %+v", a.GAMA, a.TIME, a.TEXTs)
+	}
+
+	if err = a.ApplyPatch([]ChunkDiff{{Op: ChunkRemoved, Name: TEXTChunk, Index: 5}}); err == nil {
+		t.Fatal("removing a missing chunk succeeded")
+	}
+	if err = a.ApplyPatch([]ChunkDiff{{Op: ChunkRemoved, Name: IHDRChunk}}); err == nil || a.IHDR == nil {
+		t.Fatalf("removing IHDR: %v", err)
+	}
+}
+
+func TestDiffLocking(t *testing.T) {
+	var ihdr = &IHDR{Width: 2, Height: 2, BitDepth: 8, ColorType: Grayscale}
+	var raw = buildPng(ihdrChunk(ihdr), blankIDAT(ihdr), newChunk(IENDChunk, nil))
+	a, err := ParsePng(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ParsePng(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a Diff waiting for b must not hold a's lock, or b.Diff(a) and writers on both deadlock
+	b.Lock()
+	var diffed = make(chan struct{})
+	go func() {
+		a.Diff(b)
+		close(diffed)
+	}()
+	// with b write locked, a Diff parked on a read lock waits for b's
+	var deadline = time.Now().Add(10 * time.Second)
+	for !parkedInRLock(").Diff(") {
+		if time.Now().After(deadline) {
+			b.Unlock()
+			t.Fatal("Diff never waited for the lock of other")
+		}
+		runtime.Gosched()
+	}
+	if a.TryLock() {
+		a.Unlock()
+	} else {
+		t.Error("Diff holds the lock of p while waiting for other")
+	}
+	b.Unlock()
+	<-diffed
+}
+
+// parkedInRLock reports whether a goroutine running fn is blocked in sync.RWMutex.RLock.
+func parkedInRLock(fn string) bool {
+	var buf = make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	for _, g := range bytes.Split(buf, []byte("\n\n")) {
+		if bytes.Contains(g, []byte("[sync.RWMutex.RLock")) && bytes.Contains(g, []byte(fn)) {
+			return true
+		}
+	}
+	return false
+}
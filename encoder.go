@@ -0,0 +1,124 @@
+package simple_png
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Encoder writes a png scanline by scanline, holding only the previous scanline and the
+// compressed data of the IDAT chunk being filled, so images far larger than memory can be
+// produced. Interlaced images are not supported, their passes need the whole image.
+type Encoder struct {
+	w    io.Writer
+	ihdr *IHDR
+	conf *writeConfig
+	zw   *zlib.Writer
+	// buf holds compressed data not yet written as an IDAT chunk
+	buf  bytes.Buffer
+	prev []byte
+	bpp  int
+	rows int
+	// err is the first write error, every later call returns it
+	err error
+}
+
+// NewEncoder writes the signature and the IHDR chunk to w and returns an Encoder for the
+// scanlines of ihdr. The IDATChunkSize option sets the size of the IDAT chunks written.
+func NewEncoder(w io.Writer, ihdr *IHDR, opts ...WriteOption) (*Encoder, error) {
+	if err := ihdr.checkSize(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := ihdr.checkDecodable(); err != nil {
+		return nil, err
+	}
+	if ihdr.InterlaceMethod != 0 {
+		return nil, errors.New("interlaced images can't be encoded by scanline")
+	}
+	var e = &Encoder{w: w, ihdr: ihdr, conf: newWriteConfig(opts), bpp: ihdr.BytesPerPixel()}
+	e.prev = make([]byte, ihdr.rowBytes(int(ihdr.Width)))
+	e.zw = zlib.NewWriter(&e.buf)
+	if _, err := w.Write(pngHeaderBytes); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := e.WriteChunk(ihdr); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// WriteChunk writes an ancillary or PLTE chunk ahead of the image data, so it must be called
+// before the first WriteScanline. Indexed-color images need their PLTE written this way.
+func (e *Encoder) WriteChunk(c ChunkSerialize) error {
+	if e.err != nil {
+		return e.err
+	}
+	if e.rows > 0 {
+		return errors.Errorf("%s chunk after the first scanline", c.ChunkName())
+	}
+	ch, err := serializeChunk(c)
+	if err != nil {
+		return err
+	}
+	e.err = ch.writeTo(e.w)
+	return e.err
+}
+
+// WriteScanline filters and compresses the next scanline. row holds the unfiltered samples
+// packed at the image bit depth, without the filter type byte, and may be reused by the caller
+// once WriteScanline returns.
+func (e *Encoder) WriteScanline(row []byte) error {
+	if e.err != nil {
+		return e.err
+	}
+	if e.rows == int(e.ihdr.Height) {
+		return errors.Errorf("all %d scanlines already written", e.rows)
+	}
+	if len(row) != len(e.prev) {
+		return errors.Errorf("scanline is %d bytes, want %d", len(row), len(e.prev))
+	}
+	if _, err := e.zw.Write(adaptiveFilter(row, e.prev, e.bpp)); err != nil {
+		e.err = errors.WithStack(err)
+		return e.err
+	}
+	copy(e.prev, row)
+	e.rows++
+	return e.flush(false)
+}
+
+// flush writes the full IDAT chunks buffered so far, and the remainder too when all is set.
+func (e *Encoder) flush(all bool) error {
+	var size = e.conf.idatChunkSize
+	for e.buf.Len() >= size || all && e.buf.Len() > 0 {
+		if e.err = newChunk(IDATChunk, e.buf.Next(min(size, e.buf.Len()))).writeTo(e.w); e.err != nil {
+			return e.err
+		}
+	}
+	return nil
+}
+
+// Close finishes the datastream and writes the last IDAT chunk and IEND. It fails when fewer
+// scanlines than the image height were written. Close doesn't close the underlying writer.
+func (e *Encoder) Close() error {
+	if e.err != nil {
+		return e.err
+	}
+	if e.rows != int(e.ihdr.Height) {
+		e.err = errors.Errorf("%d of %d scanlines written", e.rows, e.ihdr.Height)
+		return e.err
+	}
+	if err := e.zw.Close(); err != nil {
+		e.err = errors.WithStack(err)
+		return e.err
+	}
+	if err := e.flush(true); err != nil {
+		return err
+	}
+	if e.err = newChunk(IENDChunk, nil).writeTo(e.w); e.err != nil {
+		return e.err
+	}
+	e.err = errors.New("encoder closed")
+	return nil
+}
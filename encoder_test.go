@@ -0,0 +1,110 @@
+package simple_png
+
+import (
+	"bytes"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+func TestEncoder(t *testing.T) {
+	var rnd = rand.New(rand.NewSource(7))
+	var plte = &PLTE{Colors: []*PLTEColor{{Red: 255}, {Green: 255}, {Blue: 255}, {Red: 9, Green: 9, Blue: 9}}}
+	for _, ihdr := range []*IHDR{
+		{Width: 70, Height: 40, BitDepth: 8, ColorType: Truecolor},
+		{Width: 33, Height: 9, BitDepth: 16, ColorType: GrayscaleAlpha},
+		{Width: 21, Height: 17, BitDepth: 2, ColorType: Indexed},
+	} {
+		var max = 1<<ihdr.BitDepth - 1
+		if ihdr.ColorType == Indexed {
+			max = len(plte.Colors) - 1
+		}
+		var pixels = make([][]uint16, ihdr.Height)
+		for y := range pixels {
+			for i := 0; i < int(ihdr.Width)*ihdr.Channels(); i++ {
+				pixels[y] = append(pixels[y], uint16(rnd.Intn(max+1)))
+			}
+		}
+
+		var buf bytes.Buffer
+		e, err := NewEncoder(&buf, ihdr, IDATChunkSize(64))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ihdr.ColorType == Indexed {
+			if err = e.WriteChunk(plte); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var row = make([]byte, ihdr.rowBytes(int(ihdr.Width)))
+		for _, samples := range pixels {
+			// the encoder must not keep the caller's buffer
+			copy(row, packRow(samples, ihdr.BitDepth))
+			if err = e.WriteScanline(row); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = e.WriteScanline(row); err == nil {
+			t.Fatal("scanline past the image height accepted")
+		}
+		if err = e.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := ParsePng(bytes.NewReader(buf.Bytes()), Strict())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(p.IDATs) < 2 {
+			t.Fatalf("%v: %d IDAT chunks", ihdr.ColorType, len(p.IDATs))
+		}
+		var chunks = []*chunk{ihdrChunk(ihdr)}
+		if ihdr.ColorType == Indexed {
+			data, _ := plte.Serialize()
+			chunks = append(chunks, newChunk(PLTEChunk, data))
+		}
+		ref, err := ParsePng(bytes.NewReader(buildPng(append(chunks,
+			newChunk(IDATChunk, encodeSamples(ihdr, pixels)), newChunk(IENDChunk, nil))...)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := ref.ToImage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := p.ToImage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertSamePixels(t, want, got)
+		if _, err = png.Decode(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEncoderErrors(t *testing.T) {
+	var ihdr = &IHDR{Width: 4, Height: 2, BitDepth: 8, ColorType: Grayscale}
+	if _, err := NewEncoder(&bytes.Buffer{}, &IHDR{Width: 4, Height: 2, BitDepth: 8, ColorType: Grayscale, InterlaceMethod: 1}); err == nil {
+		t.Fatal("interlaced encoder created")
+	}
+	if _, err := NewEncoder(&bytes.Buffer{}, &IHDR{Width: 4, Height: 2, BitDepth: 3, ColorType: Grayscale}); err == nil {
+		t.Fatal("encoder created for bit depth 3")
+	}
+	e, err := NewEncoder(&bytes.Buffer{}, ihdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = e.WriteScanline(make([]byte, 3)); err == nil {
+		t.Fatal("short scanline accepted")
+	}
+	if err = e.WriteScanline(make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if err = e.WriteChunk(&PLTE{Colors: []*PLTEColor{{}}}); err == nil {
+		t.Fatal("chunk after the image data accepted")
+	}
+	if err = e.Close(); err == nil {
+		t.Fatal("closed with a scanline missing")
+	}
+}
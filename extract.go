@@ -0,0 +1,86 @@
+package simple_png
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ExtractResources writes the embedded resources of p to files in dir, creating it if needed,
+// and returns the paths written in order:
+//
+//	profile.icc                  the decompressed iCCP profile
+//	exif.tiff                    the eXIf chunk data, a TIFF-structured Exif block
+//	text-<n>-<keyword>.txt       the value of the n-th tEXt, zTXt or iTXt chunk, in UTF-8
+//
+// Keyword characters other than ASCII letters, digits, '-' and '_' are replaced by '_' in file
+// names, n keeps repeated keywords apart. Existing files are overwritten. On error the paths
+// written so far are returned with it.
+func (p *Png) ExtractResources(dir string) ([]string, error) {
+	p.RLock()
+	var profile []byte
+	if p.ICCP != nil {
+		profile = p.ICCP.Profile
+	}
+	var exif []byte
+	if i := p.chunkIndex("eXIf"); i >= 0 {
+		exif = p.chunks[i].data
+	}
+	p.RUnlock()
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var paths []string
+	var write = func(name string, data []byte) error {
+		var path = filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			return errors.WithStack(err)
+		}
+		paths = append(paths, path)
+		return nil
+	}
+	if profile != nil {
+		if err := write("profile.icc", profile); err != nil {
+			return paths, err
+		}
+	}
+	if exif != nil {
+		if err := write("exif.tiff", exif); err != nil {
+			return paths, err
+		}
+	}
+	for i, e := range p.TextEntries() {
+		var value = e.Value
+		if e.Kind != ITXTChunk {
+			value = latin1ToUTF8(value)
+		}
+		if err := write(fmt.Sprintf("text-%d-%s.txt", i, fileSafe(e.Keyword)), []byte(value)); err != nil {
+			return paths, err
+		}
+	}
+	return paths, nil
+}
+
+// latin1ToUTF8 converts the Latin-1 text of tEXt and zTXt, where each byte is a code point.
+func latin1ToUTF8(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		b.WriteRune(rune(s[i]))
+	}
+	return b.String()
+}
+
+// fileSafe replaces the characters of s that aren't safe in a file name on every platform.
+func fileSafe(s string) string {
+	var b = []byte(s)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
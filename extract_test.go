@@ -0,0 +1,48 @@
+package simple_png
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestExtractResources(t *testing.T) {
+	var ihdr = &IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Grayscale}
+	iccp, _ := (&ICCP{ProfileName: "ICC", Profile: []byte("profile bytes")}).Serialize()
+	ztxt, _ := (&ZTXT{Keyword: "Comment", Text: "compressed"}).Serialize()
+	itxt, _ := (&ITXT{Keyword: "Title", LanguageTag: "fr", Text: "été"}).Serialize()
+	p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), newChunk(ICCPChunk, iccp),
+		newChunk("eXIf", []byte("MM\x00*exif")), newChunk(TEXTChunk, []byte("Author/Name\x00caf\xe9")),
+		newChunk(ZTXTChunk, ztxt), blankIDAT(ihdr), newChunk(ITXTChunk, itxt), newChunk(IENDChunk, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dir = filepath.Join(t.TempDir(), "assets")
+	paths, err := p.ExtractResources(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want = map[string]string{
+		"profile.icc":            "profile bytes",
+		"exif.tiff":              "MM\x00*exif",
+		"text-0-Author_Name.txt": "café",
+		"text-1-Comment.txt":     "compressed",
+		"text-2-Title.txt":       "été",
+	}
+	var names []string
+	for _, path := range paths {
+		names = append(names, filepath.Base(path))
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want[filepath.Base(path)] {
+			t.Fatalf("%s holds %q", path, data)
+		}
+	}
+	if !slices.Equal(names, []string{"profile.icc", "exif.tiff", "text-0-Author_Name.txt", "text-1-Comment.txt", "text-2-Title.txt"}) {
+		t.Fatalf("wrote %q", names)
+	}
+}
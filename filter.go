@@ -0,0 +1,140 @@
+package simple_png
+
+import "github.com/pkg/errors"
+
+// Filter Algorithms  https://www.w3.org/TR/PNG-Filters.html
+//
+// Filter method 0 defines five basic filter types, each scanline is prefixed
+// with the filter type byte that was applied to it:
+//
+//	Type    Name
+//
+//	0       None
+//	1       Sub
+//	2       Up
+//	3       Average
+//	4       Paeth
+//
+// Filters are applied to bytes, not to pixels, regardless of the bit depth or
+// color type of the image. bpp is the number of bytes per complete pixel,
+// rounding up to one.
+const (
+	FilterNone uint8 = iota
+	FilterSub
+	FilterUp
+	FilterAverage
+	FilterPaeth
+)
+
+func paeth(a, b, c uint8) uint8 {
+	p := int(a) + int(b) - int(c)
+	pa := abs(p - int(a))
+	pb := abs(p - int(b))
+	pc := abs(p - int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// filterRow writes the filtered cur row into dst, prev is the unfiltered
+// previous row (all zero for the first row).
+func filterRow(ft uint8, dst, cur, prev []byte, bpp int) {
+	switch ft {
+	case FilterNone:
+		copy(dst, cur)
+	case FilterSub:
+		for i := range cur {
+			var left uint8
+			if i >= bpp {
+				left = cur[i-bpp]
+			}
+			dst[i] = cur[i] - left
+		}
+	case FilterUp:
+		for i := range cur {
+			dst[i] = cur[i] - prev[i]
+		}
+	case FilterAverage:
+		for i := range cur {
+			var left uint8
+			if i >= bpp {
+				left = cur[i-bpp]
+			}
+			dst[i] = cur[i] - uint8((int(left)+int(prev[i]))/2)
+		}
+	case FilterPaeth:
+		for i := range cur {
+			var left, upLeft uint8
+			if i >= bpp {
+				left, upLeft = cur[i-bpp], prev[i-bpp]
+			}
+			dst[i] = cur[i] - paeth(left, prev[i], upLeft)
+		}
+	}
+}
+
+// unfilterRow reverses the filter ft on cur in place, prev is the already unfiltered previous row.
+func unfilterRow(ft uint8, cur, prev []byte, bpp int) error {
+	switch ft {
+	case FilterNone:
+	case FilterSub:
+		for i := bpp; i < len(cur); i++ {
+			cur[i] += cur[i-bpp]
+		}
+	case FilterUp:
+		for i := range cur {
+			cur[i] += prev[i]
+		}
+	case FilterAverage:
+		for i := range cur {
+			var left uint8
+			if i >= bpp {
+				left = cur[i-bpp]
+			}
+			cur[i] += uint8((int(left) + int(prev[i])) / 2)
+		}
+	case FilterPaeth:
+		for i := range cur {
+			var left, upLeft uint8
+			if i >= bpp {
+				left, upLeft = cur[i-bpp], prev[i-bpp]
+			}
+			cur[i] += paeth(left, prev[i], upLeft)
+		}
+	default:
+		return errors.Errorf("invalid filter type %d", ft)
+	}
+	return nil
+}
+
+// adaptiveFilter picks the filter type with the minimum sum of absolute
+// differences, the heuristic recommended by the spec, and returns the
+// filtered row prefixed with its filter type byte.
+func adaptiveFilter(cur, prev []byte, bpp int) []byte {
+	var best []byte
+	var bestSum = -1
+	var buf = make([]byte, len(cur))
+	for ft := FilterNone; ft <= FilterPaeth; ft++ {
+		filterRow(ft, buf, cur, prev, bpp)
+		var sum int
+		for _, b := range buf {
+			sum += abs(int(int8(b)))
+		}
+		if bestSum < 0 || sum < bestSum {
+			bestSum = sum
+			best = append(append(best[:0], ft), buf...)
+		}
+	}
+	return best
+}
@@ -0,0 +1,125 @@
+package simple_png
+
+import (
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PngInfo is the summary returned by Info.
+type PngInfo struct {
+	Width       uint32
+	Height      uint32
+	BitDepth    uint8
+	ColorType   string
+	Interlaced  bool
+	HasAlpha    bool
+	PaletteSize int
+	// Gamma is 0 when there is no gAMA chunk.
+	Gamma float64
+	// DPI is the horizontal resolution, 0 unless pHYs is given in meters.
+	DPI          float64
+	ModTime      time.Time
+	TextKeywords []string
+}
+
+// Info reads the chunks preceding the first IDAT and summarizes them, the image data is never read.
+// Chunks placed after the image data, such as a trailing tIME or tEXt, are not reported.
+func Info(r io.Reader) (PngInfo, error) {
+	var info PngInfo
+	if err := readSignature(r); err != nil {
+		return info, err
+	}
+	var p = &Png{}
+	for {
+		c, err := readChunkHeader(r)
+		if err != nil {
+			return info, err
+		}
+		if name := ChunkName(c.code[:]); name == IDATChunk || name == IENDChunk {
+			break
+		}
+		if err = readChunkBody(r, c); err != nil {
+			return info, err
+		}
+		p.chunks = append(p.chunks, c)
+	}
+
+	var IHDR = &IHDR{}
+	if err := p.ParseChunk(IHDR, true); err != nil {
+		if errors.Is(err, chunkNotFoundErr) {
+			return info, ErrMissingIHDR
+		}
+		return info, errors.WithStack(err)
+	}
+	info.Width = IHDR.Width
+	info.Height = IHDR.Height
+	info.BitDepth = IHDR.BitDepth
+	info.ColorType = IHDR.ColorType.String()
+	info.Interlaced = IHDR.InterlaceMethod == 1
+	info.HasAlpha = IHDR.ColorType == GrayscaleAlpha || IHDR.ColorType == TruecolorAlpha
+
+	var PLTE = &PLTE{}
+	if err := p.ParseChunk(PLTE, true); err == nil {
+		info.PaletteSize = len(PLTE.Colors)
+	}
+	var TRNS = &TRNS{}
+	if err := p.ParseChunk(TRNS, true); err == nil {
+		info.HasAlpha = true
+	}
+	var GAMA = &GAMA{}
+	if err := p.ParseChunk(GAMA, true); err == nil {
+		info.Gamma = float64(GAMA.ImageGamma) / 100000
+	}
+	var PHYS = &PHYS{}
+	if err := p.ParseChunk(PHYS, true); err == nil && PHYS.UnitSpecifier == 1 {
+		info.DPI = float64(PHYS.X) * 0.0254
+	}
+	var TIME = &TIME{}
+	if err := p.ParseChunk(TIME, true); err == nil {
+		info.ModTime = TIME.ToTime()
+	}
+	for {
+		var text = &TEXT{}
+		if err := p.ParseChunk(text, true); err != nil {
+			break
+		}
+		info.TextKeywords = append(info.TextKeywords, text.Keyword)
+	}
+	for {
+		var text = &ZTXT{}
+		if err := p.ParseChunk(text, true); err != nil {
+			break
+		}
+		info.TextKeywords = append(info.TextKeywords, text.Keyword)
+	}
+	for {
+		var text = &ITXT{}
+		if err := p.ParseChunk(text, true); err != nil {
+			break
+		}
+		info.TextKeywords = append(info.TextKeywords, text.Keyword)
+	}
+	return info, nil
+}
+
+// PhysicalSize returns the printed size of the image in meters and the chunk it was taken from.
+// sCAL, which states the physical size of a pixel, wins over pHYs, whose pixels per meter are
+// only used when sCAL is absent or given in radians. ok is false when neither chunk gives a size
+// in meters.
+func (p *Png) PhysicalSize() (widthMeters, heightMeters float64, source string, ok bool) {
+	p.RLock()
+	defer p.RUnlock()
+	if p.IHDR == nil {
+		return 0, 0, "", false
+	}
+	var width, height = float64(p.IHDR.Width), float64(p.IHDR.Height)
+	if p.SCAL != nil && p.SCAL.UnitSpecifier == 1 {
+		return width * p.SCAL.PixelWidth, height * p.SCAL.PixelHeight, string(SCALChunk), true
+	}
+	if p.PHYS != nil && p.PHYS.UnitSpecifier == 1 && p.PHYS.X > 0 && p.PHYS.Y > 0 {
+		return width / float64(p.PHYS.X), height / float64(p.PHYS.Y), string(PHYSChunk), true
+	}
+	return 0, 0, "", false
+}
@@ -0,0 +1,83 @@
+package simple_png
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestInfo(t *testing.T) {
+	open, err := os.Open("./demo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer open.Close()
+	info, err := Info(open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Width != 256 || info.Height != 81 || info.BitDepth != 8 || info.ColorType != "truecolor" {
+		t.Fatalf("unexpected header %+v", info)
+	}
+	if info.Interlaced || info.HasAlpha || info.PaletteSize != 0 {
+		t.Fatalf("unexpected flags %+v", info)
+	}
+	if math.Abs(info.DPI-96) > 0.1 {
+		t.Fatalf("DPI %v, want 96", info.DPI)
+	}
+	if !slices.Equal(info.TextKeywords, []string{"Software"}) {
+		t.Fatalf("keywords %v", info.TextKeywords)
+	}
+
+	var ihdr = &IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Grayscale}
+	if _, err = Info(bytes.NewReader(buildPng(blankIDAT(ihdr), newChunk(IENDChunk, nil)))); !errors.Is(err, ErrMissingIHDR) {
+		t.Fatalf("got %v, want ErrMissingIHDR", err)
+	}
+}
+
+func TestPhysicalSize(t *testing.T) {
+	var ihdr = &IHDR{Width: 200, Height: 100, BitDepth: 8, ColorType: Grayscale}
+	var phys = newChunk(PHYSChunk, []byte{0, 0, 0x0f, 0xa0, 0, 0, 0x07, 0xd0, 1})
+	var cases = []struct {
+		chunks        []*chunk
+		width, height float64
+		source        string
+	}{
+		{nil, 0, 0, ""},
+		{[]*chunk{phys}, 0.05, 0.05, "pHYs"},
+		{[]*chunk{phys, newChunk(SCALChunk, []byte("\x010.001\x000.002"))}, 0.2, 0.2, "sCAL"},
+		{[]*chunk{phys, newChunk(SCALChunk, []byte("\x021e-5\x001e-5"))}, 0.05, 0.05, "pHYs"},
+		{[]*chunk{newChunk(PHYSChunk, []byte{0, 0, 0, 1, 0, 0, 0, 1, 0})}, 0, 0, ""},
+	}
+	for i, c := range cases {
+		var chunks = append([]*chunk{ihdrChunk(ihdr)}, c.chunks...)
+		p, err := ParsePng(bytes.NewReader(buildPng(append(chunks, blankIDAT(ihdr), newChunk(IENDChunk, nil))...)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		width, height, source, ok := p.PhysicalSize()
+		if ok != (c.source != "") || source != c.source || math.Abs(width-c.width) > 1e-9 || math.Abs(height-c.height) > 1e-9 {
+			t.Fatalf("case %d: %v x %v from %q, %v", i, width, height, source, ok)
+		}
+	}
+}
+
+func TestParseSCAL(t *testing.T) {
+	for _, data := range []string{"\x011\x00", "\x03\x311\x001", "\x01-1\x001", "\x01inf\x001", "\x01nan\x001", "\x0112"} {
+		if err := (&SCAL{}).Parse(newChunk(SCALChunk, []byte(data))); err == nil {
+			t.Fatalf("%q accepted", data)
+		}
+	}
+	var want = &SCAL{UnitSpecifier: 2, PixelWidth: 2.5e-7, PixelHeight: 0.125}
+	data, err := want.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got = &SCAL{}
+	if err = got.Parse(newChunk(SCALChunk, data)); err != nil || *got != *want {
+		t.Fatalf("got %+v, %v", got, err)
+	}
+}
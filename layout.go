@@ -0,0 +1,98 @@
+package simple_png
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ParseFixedLayout fills the struct dst points to from data, for chunks whose fields sit at fixed
+// offsets. Each field to fill carries a png tag giving its byte offset and size, multi-byte
+// integers are big-endian like every png integer:
+//
+//	type PHYS struct {
+//		X             uint32 `png:"0,4"`
+//		Y             uint32 `png:"4,4"`
+//		UnitSpecifier uint8  `png:"8,1"`
+//	}
+//
+// Fields may be unsigned or signed integers whose size matches the tag, byte arrays of the tag
+// size, byte slices and strings. Untagged fields are left alone. Data shorter than a field's
+// offset plus size is an error, trailing data isn't.
+func ParseFixedLayout(data []byte, dst any) error {
+	var v = reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return errors.New("ParseFixedLayout needs a non-nil pointer to a struct")
+	}
+	v = v.Elem()
+	var t = v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		var field = t.Field(i)
+		tag, ok := field.Tag.Lookup("png")
+		if !ok || tag == "-" {
+			continue
+		}
+		off, size, err := parseLayoutTag(tag)
+		if err != nil {
+			return errors.Wrapf(err, "field %s", field.Name)
+		}
+		if !field.IsExported() {
+			return errors.Errorf("field %s: unexported", field.Name)
+		}
+		if off+size > len(data) {
+			return errors.Errorf("field %s: needs %d bytes, data has %d", field.Name, off+size, len(data))
+		}
+		var b = data[off : off+size]
+		var f = v.Field(i)
+		switch f.Kind() {
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if int(f.Type().Size()) != size {
+				return errors.Errorf("field %s: %s can't hold %d bytes", field.Name, f.Type(), size)
+			}
+			var u uint64
+			for _, c := range b {
+				u = u<<8 | uint64(c)
+			}
+			if f.CanUint() {
+				f.SetUint(u)
+			} else {
+				// sign extend from the field width
+				var shift = 64 - 8*size
+				f.SetInt(int64(u<<shift) >> shift)
+			}
+		case reflect.Array:
+			if f.Type().Elem().Kind() != reflect.Uint8 || f.Len() != size {
+				return errors.Errorf("field %s: %s can't hold %d bytes", field.Name, f.Type(), size)
+			}
+			reflect.Copy(f, reflect.ValueOf(b))
+		case reflect.Slice:
+			if f.Type().Elem().Kind() != reflect.Uint8 {
+				return errors.Errorf("field %s: unsupported type %s", field.Name, f.Type())
+			}
+			f.SetBytes(append([]byte(nil), b...))
+		case reflect.String:
+			f.SetString(string(b))
+		default:
+			return errors.Errorf("field %s: unsupported type %s", field.Name, f.Type())
+		}
+	}
+	return nil
+}
+
+// parseLayoutTag splits a png tag "offset,size".
+func parseLayoutTag(tag string) (off, size int, err error) {
+	o, s, ok := strings.Cut(tag, ",")
+	if !ok {
+		return 0, 0, errors.Errorf("invalid png tag %q, want \"offset,size\"", tag)
+	}
+	if off, err = strconv.Atoi(strings.TrimSpace(o)); err == nil {
+		size, err = strconv.Atoi(strings.TrimSpace(s))
+	}
+	if err != nil || off < 0 || size <= 0 {
+		return 0, 0, errors.Errorf("invalid png tag %q", tag)
+	}
+	return off, size, nil
+}
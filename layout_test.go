@@ -0,0 +1,46 @@
+package simple_png
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseFixedLayout(t *testing.T) {
+	type custom struct {
+		A     uint16  `png:"0,2"`
+		B     int32   `png:"2,4"`
+		Tag   [3]byte `png:"6,3"`
+		Name  string  `png:"9,4"`
+		Rest  []byte  `png:"13,2"`
+		Other int
+	}
+	var data = []byte{0x01, 0x02, 0xff, 0xff, 0xff, 0xfe, 'a', 'b', 'c', 'n', 'a', 'm', 'e', 7, 8, 9}
+	var got = custom{Other: 42}
+	if err := ParseFixedLayout(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.A != 0x0102 || got.B != -2 || got.Tag != [3]byte{'a', 'b', 'c'} || got.Name != "name" ||
+		!bytes.Equal(got.Rest, []byte{7, 8}) || got.Other != 42 {
+		t.Fatalf("%+v", got)
+	}
+	if err := ParseFixedLayout(data[:14], &got); err == nil {
+		t.Fatal("short data accepted")
+	}
+	var bad struct {
+		A uint16 `png:"0,4"`
+	}
+	if err := ParseFixedLayout(data, &bad); err == nil {
+		t.Fatal("size mismatch accepted")
+	}
+	if err := ParseFixedLayout(data, got); err == nil {
+		t.Fatal("non-pointer accepted")
+	}
+
+	var phys PHYS
+	if err := phys.Parse(newChunk(PHYSChunk, []byte{0, 0, 0x0e, 0xc4, 0, 0, 0x0e, 0xc3, 1})); err != nil {
+		t.Fatal(err)
+	}
+	if phys != (PHYS{X: 3780, Y: 3779, UnitSpecifier: 1}) {
+		t.Fatalf("pHYs %+v", phys)
+	}
+}
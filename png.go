@@ -1,6 +1,11 @@
 package simple_png
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
 	"io"
 	"slices"
 	"sync"
@@ -8,9 +13,47 @@ import (
 	"github.com/pkg/errors"
 )
 
-var pngHeaderBytes = []byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0A, 0x1A, 0x0A}
+// Signature is the 8 byte signature every png datastream starts with.
+var Signature = [8]byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0A, 0x1A, 0x0A}
+
+var pngHeaderBytes = Signature[:]
 var pngHeader = string(pngHeaderBytes)
 
+// ErrInvalidSignature is matched by every error ValidateSignature returns.
+var ErrInvalidSignature = errors.New("invalid png signature")
+
+// SignatureError reports the first byte of the signature that differs, Offset is the
+// length of the input when it is shorter than the signature.
+type SignatureError struct {
+	Offset    int
+	Got, Want byte
+	Truncated bool
+}
+
+func (e *SignatureError) Error() string {
+	if e.Truncated {
+		return fmt.Sprintf("invalid png signature: truncated after %d bytes", e.Offset)
+	}
+	return fmt.Sprintf("invalid png signature: byte %d is %#02x, want %#02x", e.Offset, e.Got, e.Want)
+}
+
+func (e *SignatureError) Unwrap() error {
+	return ErrInvalidSignature
+}
+
+// ValidateSignature checks that b starts with Signature, a mismatch is reported as a *SignatureError.
+func ValidateSignature(b []byte) error {
+	for i, want := range Signature {
+		if i >= len(b) {
+			return &SignatureError{Offset: i, Want: want, Truncated: true}
+		}
+		if b[i] != want {
+			return &SignatureError{Offset: i, Got: b[i], Want: want}
+		}
+	}
+	return nil
+}
+
 type Png struct {
 	sync.RWMutex
 	IHDR  *IHDR
@@ -21,79 +64,429 @@ type Png struct {
 	GAMA  *GAMA
 	HIST  *HIST
 	PHYS  *PHYS
+	OFFS  *OFFS
+	SCAL  *SCAL
 	SBIT  *SBIT
+	PCAL  *PCAL
+	ICCP  *ICCP
+	ACTL  *ACTL
 
 	TEXTs []*TEXT
 	TRNS  *TRNS
 	TIME  *TIME
-	ZTXTs []*ZTXT
+	// AllTIME holds every tIME chunk, TIME is the first of them
+	AllTIME []*TIME
+	ZTXTs   []*ZTXT
+	ITXTs   []*ITXT
 
 	IEND       *IEND
 	OtherChunk map[ChunkName][]ChunkParse
 	chunks     []*chunk
 	bs         []byte
+	// modified is set once the image data is replaced, see keepOnWrite
+	modified bool
+	// metadataOnly is set by ParseMetadata, the IDAT data was never read
+	metadataOnly bool
+	// released is set by Release
+	released bool
 }
 
-func ParsePng(r io.Reader) (*Png, error) {
-	var p = &Png{}
-	var hex = make([]byte, 8)
-	read, err := r.Read(hex)
-	if err != nil {
-		return nil, errors.WithStack(err)
+const defaultGarbageWindow = 1024
+
+type parseConfig struct {
+	strict        bool
+	garbageWindow int
+	keepRaw       bool
+	logger        Logger
+	// skipIDAT discards the IDAT data as it is read, see ParseMetadata
+	skipIDAT   bool
+	keepBadCRC bool
+}
+
+// Logger receives the parser's debug output, *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, args ...any)
+}
+
+func (c *parseConfig) logf(format string, args ...any) {
+	if c.logger != nil {
+		c.logger.Printf(format, args...)
+	}
+}
+
+// skipped logs an ancillary chunk that failed to parse and was left out of the typed fields.
+func (c *parseConfig) skipped(name ChunkName, err error) {
+	if !errors.Is(err, chunkNotFoundErr) {
+		c.logf("warning: skipping %s chunk: %v", name, err)
+	}
+}
+
+type ParseOption func(*parseConfig)
+
+// Strict makes ParsePng reject files that fail Validate or carry data after IEND. Without it a
+// fixed-layout chunk padded past its size, such as a 14-byte IHDR, is read from its first bytes
+// and the padding is only logged, while a truncated one is logged and skipped.
+func Strict() ParseOption {
+	return func(c *parseConfig) {
+		c.strict = true
+	}
+}
+
+// SkipLeadingGarbage scans forward for the png signature, skipping at most window stray bytes
+// (1KB when window isn't positive). Without it any byte before the signature is an error.
+func SkipLeadingGarbage(window int) ParseOption {
+	return func(c *parseConfig) {
+		if window <= 0 {
+			window = defaultGarbageWindow
+		}
+		c.garbageWindow = window
+	}
+}
+
+// KeepRaw makes ParsePng keep the on-disk bytes of every chunk, WritePng then emits the chunks
+// that weren't replaced verbatim, IDAT chunks included unless IDATChunkSize is given.
+func KeepRaw() ParseOption {
+	return func(c *parseConfig) {
+		c.keepRaw = true
+	}
+}
+
+// KeepBadCRC makes ParsePng keep chunks whose crc doesn't match their data, logging a warning,
+// in place of failing with ErrCRCMismatch. WritePng writes their crc back as read, wrong as it
+// is, unless the chunk is edited or RecomputeCRC is given. IDAT chunks are re-split when written,
+// so they keep a bad crc only with KeepRaw.
+func KeepBadCRC() ParseOption {
+	return func(c *parseConfig) {
+		c.keepBadCRC = true
+	}
+}
+
+// WithLogger makes ParsePng log every chunk read and every ancillary chunk it couldn't parse to l,
+// nothing is logged by default.
+func WithLogger(l Logger) ParseOption {
+	return func(c *parseConfig) {
+		c.logger = l
+	}
+}
+
+// NewPng returns a png holding only the IHDR and IEND chunks, ready for SetImage or SetIDAT
+// and WritePng.
+func NewPng(ihdr *IHDR) *Png {
+	data, _ := ihdr.Serialize()
+	return &Png{
+		IHDR:       ihdr,
+		IEND:       &IEND{},
+		OtherChunk: map[ChunkName][]ChunkParse{},
+		chunks:     []*chunk{newChunk(IHDRChunk, data), newChunk(IENDChunk, nil)},
+	}
+}
+
+func ParsePng(r io.Reader, opts ...ParseOption) (*Png, error) {
+	var conf = &parseConfig{}
+	for _, opt := range opts {
+		opt(conf)
+	}
+	var p = &Png{OtherChunk: map[ChunkName][]ChunkParse{}, metadataOnly: conf.skipIDAT}
+	var err error
+	var offset = int64(len(pngHeaderBytes))
+	if conf.garbageWindow > 0 {
+		var skipped int
+		skipped, err = skipToSignature(r, conf.garbageWindow)
+		offset += int64(skipped)
+		if skipped > 0 {
+			conf.logf("skipped %d bytes before the signature", skipped)
+		}
+	} else {
+		err = readSignature(r)
 	}
-	if read != 8 || string(hex) != pngHeader {
-		return nil, errors.WithStack(errors.New("invalid png"))
+	if err != nil {
+		return nil, err
 	}
 	for {
-		chunk, err := readChunk(r)
+		chunk, err := readChunkHeader(r)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		var length = by.Uint32(chunk.len[:])
+		var skip = conf.skipIDAT && ChunkName(chunk.code[:]) == IDATChunk
+		if skip {
+			err = skipChunkBody(r, chunk)
+		} else {
+			err = readChunkBody(r, chunk)
+		}
+		if conf.keepBadCRC && errors.Is(err, ErrCRCMismatch) {
+			conf.logf("warning: %v at offset %d, kept", err, offset)
+			err = nil
+		}
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
+		chunk.offset = offset
+		conf.logf("read %s chunk, length %d at offset %d", chunk.code[:], length, offset)
+		if conf.keepRaw && !skip {
+			chunk.raw = slices.Concat(chunk.len[:], chunk.code[:], chunk.data, chunk.crc[:])
+		}
+		if size, ok := fixedChunkSizes[ChunkName(chunk.code[:])]; ok && int(length) > size {
+			conf.logf("warning: %s chunk is %d bytes, using the first %d", chunk.code[:], length, size)
+		} else if ok && int(length) < size {
+			conf.logf("warning: %s chunk is %d bytes, want %d", chunk.code[:], length, size)
+		}
+		offset += int64(length) + 12
 		p.chunks = append(p.chunks, chunk)
 		if ChunkName(chunk.code[:]) == IENDChunk {
 			break
 		}
 	}
-	err = p.parseBaseChunk()
+	if conf.strict {
+		// IEND marks the end of the datastream, nothing may follow it
+		n, err := io.ReadFull(r, make([]byte, 1))
+		if n > 0 {
+			return nil, errors.Errorf("data after IEND at offset %d", offset)
+		}
+		if err != io.EOF {
+			return nil, errors.WithStack(err)
+		}
+	}
+	err = p.parseBaseChunk(conf)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	if conf.strict {
+		if err = p.Validate(); err != nil {
+			return nil, err
+		}
+	}
 	return p, nil
 }
 
-func readChunk(r io.Reader) (*chunk, error) {
-	var l = make([]byte, 4)
-	var name = make([]byte, 4)
-	var crc = make([]byte, 4)
+// ParsePngSection parses the png datastream stored in the length bytes of r starting at offset,
+// such as an image embedded in an ICO file or an atlas. The datastream must end within the section,
+// and with Strict nothing but the datastream may fill it. Chunk offsets are relative to the section.
+func ParsePngSection(r io.ReaderAt, offset, length int64, opts ...ParseOption) (*Png, error) {
+	if offset < 0 || length <= 0 {
+		return nil, errors.Errorf("invalid section of %d bytes at offset %d", length, offset)
+	}
+	return ParsePng(io.NewSectionReader(r, offset, length), opts...)
+}
+
+// ErrNoPixelData is returned when decoding or writing a png parsed by ParseMetadata.
+var ErrNoPixelData = errors.New("image data skipped by ParseMetadata")
+
+// ParseMetadata is ParsePng for reading metadata only: the IDAT data is discarded as it is read,
+// seeked over when r is an io.Seeker, and its crc goes unchecked. IHDR and every ancillary chunk
+// are parsed as usual and IDATs keep their Length, but decoding, analysing or writing the image
+// fails with ErrNoPixelData until SetImage or SetIDAT provide new data. RawSize and
+// CompressionRatio only need IHDR and the IDAT lengths, they work all the same.
+func ParseMetadata(r io.Reader, opts ...ParseOption) (*Png, error) {
+	return ParsePng(r, append(opts, func(c *parseConfig) {
+		c.skipIDAT = true
+	})...)
+}
+
+// ErrReleased is returned when decoding or writing a png after Release.
+var ErrReleased = errors.New("png used after Release")
+
+// pixelErr reports why the image data is unavailable, nil when it's there.
+func (p *Png) pixelErr() error {
+	switch {
+	case p.released:
+		return ErrReleased
+	case p.metadataOnly:
+		return ErrNoPixelData
+	}
+	return nil
+}
 
-	_, err := r.Read(l)
+// Release drops every chunk and typed field so their memory, the IDAT data first of all, can be
+// reclaimed even while p itself is still referenced. p is unusable afterwards: the typed fields are
+// nil, writing fails with ErrReleased and decoding fails as it does without an IHDR.
+func (p *Png) Release() {
+	p.Lock()
+	defer p.Unlock()
+	p.IHDR, p.IDATs, p.PLTE, p.BKGD, p.CHRM, p.GAMA = nil, nil, nil, nil, nil, nil
+	p.HIST, p.PHYS, p.OFFS, p.SCAL, p.SBIT, p.PCAL, p.ICCP, p.ACTL = nil, nil, nil, nil, nil, nil, nil, nil
+	p.TEXTs, p.TRNS, p.TIME, p.AllTIME, p.ZTXTs, p.ITXTs = nil, nil, nil, nil, nil, nil
+	p.IEND, p.OtherChunk, p.chunks, p.bs = nil, nil, nil, nil
+	p.released = true
+}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// ParsePngAutoDecompress is ParsePng for a datastream that may be gzip compressed,
+// a leading gzip magic number makes r be read through a gzip reader.
+func ParsePngAutoDecompress(r io.Reader, opts ...ParseOption) (*Png, error) {
+	var br = bufio.NewReader(r)
+	magic, err := br.Peek(len(gzipMagic))
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	_, err = r.Read(name)
+	if !bytes.Equal(magic, gzipMagic) {
+		return ParsePng(br, opts...)
+	}
+	zr, err := gzip.NewReader(br)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	length := by.Uint32(l)
-	var content = make([]byte, length)
-	_, err = r.Read(content)
+	defer zr.Close()
+	return ParsePng(zr, opts...)
+}
+
+// lineEndingSignatures are the signature after the text-mode translations it is designed to detect,
+// CRLF to LF, LF to CRLF and LF to CR.
+var lineEndingSignatures = [][]byte{
+	{0x89, 0x50, 0x4E, 0x47, 0x0A, 0x1A, 0x0A},
+	{0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0D, 0x0A, 0x1A, 0x0D, 0x0A},
+	{0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0D, 0x1A, 0x0D},
+}
+
+// DetectLineEndingCorruption reports whether b starts with a png signature mangled by a text-mode
+// transfer that translated line endings.
+func DetectLineEndingCorruption(b []byte) bool {
+	return lineEndingSignature(b) != nil
+}
+
+func lineEndingSignature(b []byte) []byte {
+	if ValidateSignature(b) == nil {
+		return nil
+	}
+	for _, sig := range lineEndingSignatures {
+		if bytes.HasPrefix(b, sig) {
+			return sig
+		}
+	}
+	return nil
+}
+
+// RepairLineEndings returns a copy of b with a mangled signature restored. Only the signature is
+// repaired, line endings translated in the chunks still fail their CRC when parsed.
+func RepairLineEndings(b []byte) ([]byte, error) {
+	var sig = lineEndingSignature(b)
+	if sig == nil {
+		if err := ValidateSignature(b); err != nil {
+			return nil, err
+		}
+		return bytes.Clone(b), nil
+	}
+	return append(Signature[:len(Signature):len(Signature)], b[len(sig):]...), nil
+}
+
+func readSignature(r io.Reader) error {
+	var hex = make([]byte, 8)
+	read, err := io.ReadFull(r, hex)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(ValidateSignature(hex[:read]))
+}
+
+// skipToSignature consumes r up to and including the signature, which must start within window bytes.
+// It returns the number of bytes skipped.
+func skipToSignature(r io.Reader, window int) (int, error) {
+	var buf = make([]byte, 0, 9)
+	var b = make([]byte, 1)
+	for skipped := 0; skipped <= window; {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return skipped, errors.WithStack(err)
+		}
+		buf = append(buf, b[0])
+		if len(buf) > 8 {
+			buf = append(buf[:0], buf[1:]...)
+			skipped++
+		}
+		if string(buf) == pngHeader {
+			return skipped, nil
+		}
+	}
+	return window, errors.New("png signature not found")
+}
+
+func readChunk(r io.Reader) (*chunk, error) {
+	c, err := readChunkHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if err = readChunkBody(r, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// readChunkHeader reads the length and chunk type code fields.
+func readChunkHeader(r io.Reader) (*chunk, error) {
+	var l = make([]byte, 4)
+	var name = make([]byte, 4)
+
+	_, err := io.ReadFull(r, l)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	_, err = r.Read(crc)
+	_, err = io.ReadFull(r, name)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return &chunk{
 		len:  [4]byte(l),
 		code: [4]byte(name),
-		data: content,
-		crc:  [4]byte(crc),
 	}, nil
 }
 
+// readChunkBody reads the chunk data and crc fields following readChunkHeader.
+func readChunkBody(r io.Reader, c *chunk) error {
+	var crc = make([]byte, 4)
+	length := by.Uint32(c.len[:])
+	var content = make([]byte, length)
+	_, err := io.ReadFull(r, content)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = io.ReadFull(r, crc)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	c.data = content
+	c.crc = [4]byte(crc)
+	if by.Uint32(crc) != ComputeCRC(ChunkName(c.code[:]), content) {
+		return errors.Wrapf(ErrCRCMismatch, "%s chunk", c.code[:])
+	}
+	return nil
+}
+
+// ErrCRCMismatch is returned by ParsePng for a chunk whose crc doesn't match its data, unless
+// KeepBadCRC is given.
+var ErrCRCMismatch = errors.New("crc mismatch")
+
+// skipChunkBody is readChunkBody for a chunk whose data isn't needed, c keeps no data.
+func skipChunkBody(r io.Reader, c *chunk) error {
+	var length = int64(by.Uint32(c.len[:]))
+	var err error
+	if s, ok := r.(io.Seeker); ok {
+		_, err = s.Seek(length, io.SeekCurrent)
+	} else {
+		_, err = io.CopyN(io.Discard, r, length)
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = io.ReadFull(r, c.crc[:])
+	return errors.WithStack(err)
+}
+
 var chunkNotFoundErr = errors.New("chunk not found")
 
+// ErrMissingIHDR is returned by ParsePng when the datastream has no IHDR chunk.
+var ErrMissingIHDR = errors.New("missing IHDR chunk")
+
+// ErrMissingIDAT is returned by ParsePng when the datastream has no IDAT chunk, such as a bare
+// signature, IHDR and IEND.
+var ErrMissingIDAT = errors.New("missing IDAT chunk")
+
+// ErrMissingPLTE is returned when decoding an indexed-color image without a PLTE chunk, and
+// reported by Validate and Renderable for it.
+var ErrMissingPLTE = errors.New("PLTE is required for indexed-color")
+
+// ParseChunk parses the first chunk named c.ChunkName() into c and consumes it: the chunk is
+// removed from p, so WritePng no longer writes it, and unless notSave is true c is appended to
+// OtherChunk. It is what ParsePng is built on, DecodeChunk only reads.
 func (p *Png) ParseChunk(c ChunkParse, notSave ...bool) error {
 	var nChunks = slices.Clone(p.chunks)
 	for i := range p.chunks {
@@ -114,33 +507,60 @@ func (p *Png) ParseChunk(c ChunkParse, notSave ...bool) error {
 			nChunks = nChunks[:i]
 		}
 		p.chunks = nChunks
-		return nil
-	}
-	if !(len(notSave) > 0 && notSave[0]) {
-		p.RLock()
-		x, ok := p.OtherChunk[c.ChunkName()]
-		p.RUnlock()
-		if ok {
-			x = append(x, c)
-			p.Lock()
-			p.OtherChunk[c.ChunkName()] = x
-			p.Unlock()
-		} else {
+		if !(len(notSave) > 0 && notSave[0]) {
 			p.Lock()
-			p.OtherChunk[c.ChunkName()] = []ChunkParse{c}
+			if p.OtherChunk == nil {
+				p.OtherChunk = map[ChunkName][]ChunkParse{}
+			}
+			p.OtherChunk[c.ChunkName()] = append(p.OtherChunk[c.ChunkName()], c)
 			p.Unlock()
 		}
+		return nil
 	}
 	return chunkNotFoundErr
+}
+
+// DecodeChunk parses the first chunk named c.ChunkName() into c, leaving p untouched: unlike
+// ParseChunk the chunk stays in p and OtherChunk isn't changed.
+func (p *Png) DecodeChunk(c ChunkParse) error {
+	p.RLock()
+	defer p.RUnlock()
+	for _, ch := range p.chunks {
+		if ChunkName(ch.code[:]) == c.ChunkName() {
+			return errors.WithStack(c.Parse(ch))
+		}
+	}
+	return errors.Wrapf(chunkNotFoundErr, "%s", c.ChunkName())
+}
 
+// reparse parses chunks as ParsePng does, then makes them and their typed fields those of p.
+// On error p is left as it was. The caller holds the write lock.
+func (p *Png) reparse(chunks []*chunk) error {
+	var np = &Png{OtherChunk: map[ChunkName][]ChunkParse{}, chunks: chunks}
+	if err := np.parseBaseChunk(&parseConfig{}); err != nil {
+		return errors.WithStack(err)
+	}
+	p.IHDR, p.IDATs, p.PLTE, p.BKGD, p.CHRM, p.GAMA = np.IHDR, np.IDATs, np.PLTE, np.BKGD, np.CHRM, np.GAMA
+	p.HIST, p.PHYS, p.OFFS, p.SCAL, p.SBIT, p.PCAL, p.ICCP, p.ACTL = np.HIST, np.PHYS, np.OFFS, np.SCAL, np.SBIT, np.PCAL, np.ICCP, np.ACTL
+	p.TEXTs, p.TRNS, p.TIME, p.AllTIME, p.ZTXTs, p.ITXTs = np.TEXTs, np.TRNS, np.TIME, np.AllTIME, np.ZTXTs, np.ITXTs
+	p.IEND, p.OtherChunk, p.chunks = np.IEND, np.OtherChunk, np.chunks
+	return nil
 }
 
-func (p *Png) parseBaseChunk() error {
+func (p *Png) parseBaseChunk(conf *parseConfig) error {
 	p.Lock()
 	defer p.Unlock()
+	// ParseChunk consumes p.chunks, keep them in file order for writing
+	var chunks = slices.Clone(p.chunks)
+	defer func() { p.chunks = chunks }()
+	p.parseRegisteredChunks(chunks, conf)
+
 	var IHDR = &IHDR{}
 	err := p.ParseChunk(IHDR, true)
 	if err != nil {
+		if errors.Is(err, chunkNotFoundErr) {
+			return ErrMissingIHDR
+		}
 		return errors.WithStack(err)
 	}
 	p.IHDR = IHDR
@@ -159,58 +579,114 @@ func (p *Png) parseBaseChunk() error {
 		IDATs = append(IDATs, idat)
 	}
 	if len(IDATs) == 0 {
-		return errors.New("no IDAT found")
+		return ErrMissingIDAT
 	}
 	p.IDATs = IDATs
 
+	if n := p.chunkCount(PLTEChunk); n > 1 {
+		// the first PLTE is the one parsed, Validate reports the duplicates
+		conf.logf("warning: %d PLTE chunks, ignoring all but the first", n)
+	}
 	var PLTE = &PLTE{}
 	err = p.ParseChunk(PLTE, true)
 	if err == nil {
 		p.PLTE = PLTE
+	} else {
+		conf.skipped(PLTEChunk, err)
 	}
 
 	var BKGD = &BKGD{}
 	err = p.ParseChunk(BKGD, true)
 	if err == nil {
 		p.BKGD = BKGD
+	} else {
+		conf.skipped(BKGDChunk, err)
 	}
 
 	var CHRM = &CHRM{}
 	err = p.ParseChunk(CHRM, true)
 	if err == nil {
 		p.CHRM = CHRM
+	} else {
+		conf.skipped(CHRMChunk, err)
 	}
 	var GAMA = &GAMA{}
 	err = p.ParseChunk(GAMA, true)
 	if err == nil {
 		p.GAMA = GAMA
+	} else {
+		conf.skipped(GAMAChunk, err)
 	}
 	var HIST = &HIST{}
 	err = p.ParseChunk(HIST, true)
 	if err == nil {
 		p.HIST = HIST
+	} else {
+		conf.skipped(HISTChunk, err)
 	}
 	var PHYS = &PHYS{}
 	err = p.ParseChunk(PHYS, true)
 	if err == nil {
 		p.PHYS = PHYS
+	} else {
+		conf.skipped(PHYSChunk, err)
+	}
+	var OFFS = &OFFS{}
+	err = p.ParseChunk(OFFS, true)
+	if err == nil {
+		p.OFFS = OFFS
+	} else {
+		conf.skipped(OFFSChunk, err)
+	}
+	var SCAL = &SCAL{}
+	err = p.ParseChunk(SCAL, true)
+	if err == nil {
+		p.SCAL = SCAL
+	} else {
+		conf.skipped(SCALChunk, err)
 	}
 
 	var SBIT = &SBIT{}
 	err = p.ParseChunk(SBIT, true)
 	if err == nil {
 		p.SBIT = SBIT
+	} else {
+		conf.skipped(SBITChunk, err)
+	}
+	var ICCP = &ICCP{}
+	err = p.ParseChunk(ICCP, true)
+	if err == nil {
+		p.ICCP = ICCP
+	} else {
+		conf.skipped(ICCPChunk, err)
+	}
+	var PCAL = &PCAL{}
+	err = p.ParseChunk(PCAL, true)
+	if err == nil {
+		p.PCAL = PCAL
+	} else {
+		conf.skipped(PCALChunk, err)
+	}
+	var ACTL = &ACTL{}
+	err = p.ParseChunk(ACTL, true)
+	if err == nil {
+		p.ACTL = ACTL
+	} else {
+		conf.skipped(ACTLChunk, err)
 	}
+	// like iTXt below, a malformed tEXt or zTXt is only fatal under Strict
 	var TEXTs []*TEXT
-	for {
+	for _, c := range p.chunks {
+		if ChunkName(c.code[:]) != TEXTChunk {
+			continue
+		}
 		var text = &TEXT{}
-		err := p.ParseChunk(text, true)
-		if err != nil {
-			if errors.Is(err, chunkNotFoundErr) {
-				break
-			} else {
+		if err := text.Parse(c); err != nil {
+			if conf.strict {
 				return errors.WithStack(err)
 			}
+			conf.skipped(TEXTChunk, err)
+			continue
 		}
 		TEXTs = append(TEXTs, text)
 	}
@@ -220,29 +696,62 @@ func (p *Png) parseBaseChunk() error {
 	err = p.ParseChunk(TRNS, true)
 	if err == nil {
 		p.TRNS = TRNS
+	} else {
+		conf.skipped(TRNSChunk, err)
 	}
 
-	var TIME = &TIME{}
-	err = p.ParseChunk(TIME, true)
-	if err == nil {
-		p.TIME = TIME
+	// only one tIME is allowed, all of them are kept for inspection
+	p.AllTIME = nil
+	for _, c := range p.chunks {
+		if ChunkName(c.code[:]) != TIMEChunk {
+			continue
+		}
+		var TIME = &TIME{}
+		if err := TIME.Parse(c); err != nil {
+			conf.skipped(TIMEChunk, err)
+			continue
+		}
+		p.AllTIME = append(p.AllTIME, TIME)
+	}
+	if len(p.AllTIME) > 0 {
+		p.TIME = p.AllTIME[0]
 	}
 
 	var ZTXTs []*ZTXT
-	for {
+	for _, c := range p.chunks {
+		if ChunkName(c.code[:]) != ZTXTChunk {
+			continue
+		}
 		var text = &ZTXT{}
-		err := p.ParseChunk(text, true)
-		if err != nil {
-			if errors.Is(err, chunkNotFoundErr) {
-				break
-			} else {
+		if err := text.Parse(c); err != nil {
+			if conf.strict {
 				return errors.WithStack(err)
 			}
+			conf.skipped(ZTXTChunk, err)
+			continue
 		}
 		ZTXTs = append(ZTXTs, text)
 	}
 	p.ZTXTs = ZTXTs
 
+	// a malformed iTXt, such as one with invalid UTF-8, is only fatal under Strict
+	var ITXTs []*ITXT
+	for _, c := range p.chunks {
+		if ChunkName(c.code[:]) != ITXTChunk {
+			continue
+		}
+		var text = &ITXT{}
+		if err := text.Parse(c); err != nil {
+			if conf.strict {
+				return errors.WithStack(err)
+			}
+			conf.skipped(ITXTChunk, err)
+			continue
+		}
+		ITXTs = append(ITXTs, text)
+	}
+	p.ITXTs = ITXTs
+
 	var IEND = &IEND{}
 	err = p.ParseChunk(IEND, true)
 	if err != nil {
@@ -252,6 +761,137 @@ func (p *Png) parseBaseChunk() error {
 	return nil
 }
 
+// knownChunks are the chunks parsed into typed fields by ParsePng.
+var knownChunks = map[ChunkName]bool{
+	IHDRChunk: true, PLTEChunk: true, IDATChunk: true, IENDChunk: true,
+	BKGDChunk: true, CHRMChunk: true, GAMAChunk: true, HISTChunk: true, SBITChunk: true,
+	TRNSChunk: true, PHYSChunk: true, OFFSChunk: true, SCALChunk: true, TEXTChunk: true, ZTXTChunk: true, ITXTChunk: true, TIMEChunk: true,
+	PCALChunk: true, ICCPChunk: true, ACTLChunk: true, FCTLChunk: true, FDATChunk: true,
+}
+
+var (
+	registryLock sync.RWMutex
+	registry     = map[ChunkName]func() ChunkParse{}
+)
+
+// RegisterChunk makes ParsePng parse every chunk named name with a ChunkParse made by factory,
+// the parsed values are available from GetOtherChunkByName. Chunks that fail to parse are left
+// out, and the chunks ParsePng parses into typed fields are never dispatched to the registry.
+func RegisterChunk(name ChunkName, factory func() ChunkParse) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	registry[name] = factory
+}
+
+func (p *Png) parseRegisteredChunks(chunks []*chunk, conf *parseConfig) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	for _, c := range chunks {
+		var name = ChunkName(c.code[:])
+		factory, ok := registry[name]
+		if !ok || knownChunks[name] {
+			continue
+		}
+		var cp = factory()
+		if err := cp.Parse(c); err != nil {
+			conf.skipped(name, err)
+			continue
+		}
+		p.OtherChunk[name] = append(p.OtherChunk[name], cp)
+	}
+}
+
+// UnknownChunkNames returns the names of the chunks ParsePng doesn't parse, in first-seen order.
+func (p *Png) UnknownChunkNames() []ChunkName {
+	p.RLock()
+	defer p.RUnlock()
+	var names []ChunkName
+	for _, c := range p.chunks {
+		name := ChunkName(c.code[:])
+		if !knownChunks[name] && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ChunkOffset locates a chunk in the input, Size includes the 12 bytes of length, type and crc fields.
+type ChunkOffset struct {
+	Name   ChunkName
+	Offset int64
+	Size   int64
+}
+
+// ChunkOffsets returns the position of every chunk in file order, chunks added after parsing have Offset -1.
+// Sizes come from the length fields, so they hold for the IDATs of a png parsed by ParseMetadata.
+func (p *Png) ChunkOffsets() []ChunkOffset {
+	p.RLock()
+	defer p.RUnlock()
+	var offsets = make([]ChunkOffset, 0, len(p.chunks))
+	for _, c := range p.chunks {
+		offsets = append(offsets, ChunkOffset{
+			Name:   ChunkName(c.code[:]),
+			Offset: c.offset,
+			Size:   int64(by.Uint32(c.len[:])) + 12,
+		})
+	}
+	return offsets
+}
+
+// PresentChunks returns the set of chunk types in p, standard or not, so a caller can check for
+// gAMA, iCCP or tRNS in one call. Chunks that failed to parse into their typed field still count.
+func (p *Png) PresentChunks() map[ChunkName]bool {
+	p.RLock()
+	defer p.RUnlock()
+	var present = make(map[ChunkName]bool)
+	for _, c := range p.chunks {
+		present[ChunkName(c.code[:])] = true
+	}
+	return present
+}
+
+// HasICCProfile reports whether the png embeds an ICC profile in an iCCP chunk.
+func (p *Png) HasICCProfile() bool {
+	p.RLock()
+	defer p.RUnlock()
+	return p.ICCP != nil
+}
+
+// WriteICCProfile writes the decompressed ICC profile of the iCCP chunk to w.
+func (p *Png) WriteICCProfile(w io.Writer) error {
+	p.RLock()
+	defer p.RUnlock()
+	if p.ICCP == nil {
+		return errors.New("no iCCP found")
+	}
+	_, err := w.Write(p.ICCP.Profile)
+	return errors.WithStack(err)
+}
+
+// ChunkReader returns a reader over the data of the first chunk named name. The compressed part
+// of zTXt and iCCP is inflated while reading, every other chunk gives its data as stored.
+func (p *Png) ChunkReader(name ChunkName) (io.Reader, error) {
+	p.RLock()
+	defer p.RUnlock()
+	var i = p.chunkIndex(name)
+	if i < 0 {
+		return nil, chunkNotFoundErr
+	}
+	var data = p.chunks[i].data
+	if name != ZTXTChunk && name != ICCPChunk {
+		return bytes.NewReader(data), nil
+	}
+	_, rest, ok := bytes.Cut(data, []byte(nullSep))
+	if !ok || len(rest) < 1 {
+		return nil, errors.Errorf("invalid %s chunk data", name)
+	}
+	if err := checkCompressionMethod(name, rest[0]); err != nil {
+		return nil, err
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(rest[1:]))
+	return zr, errors.WithStack(err)
+}
+
 func (p *Png) GetOtherChunkByName(name ChunkName) ([]ChunkParse, error) {
 	p.RLock()
 	defer p.RUnlock()
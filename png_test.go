@@ -1,9 +1,21 @@
 package simple_png
 
 import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"image"
+	"io"
 	"log"
+	"math"
 	"os"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 func TestParsePng(t *testing.T) {
@@ -16,13 +28,17 @@ func TestParsePng(t *testing.T) {
 		panic(err)
 	}
 	log.Println(*p.IDATs[0])
-	log.Println(*p.TEXTs[0])
-	for i := range p.chunks {
-		log.Println(string(p.chunks[i].code[:]))
+	for _, text := range p.TEXTs {
+		log.Println(*text)
+	}
+	names := p.UnknownChunkNames()
+	if len(names) != 1 || names[0] != "sRGB" {
+		t.Fatalf("unknown chunks %v, want [sRGB]", names)
 	}
 }
 
 type CustomChunkParse struct {
+	Data string
 }
 
 func (c *CustomChunkParse) ChunkName() ChunkName {
@@ -31,21 +47,807 @@ func (c *CustomChunkParse) ChunkName() ChunkName {
 
 func (c *CustomChunkParse) Parse(chunk *chunk) error {
 	// your custom parse
+	c.Data = string(chunk.data)
 	return nil
 }
 
+func customPng() []byte {
+	var ihdr = &IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Grayscale}
+	return buildPng(ihdrChunk(ihdr), newChunk("cust", []byte("hello")), blankIDAT(ihdr), newChunk(IENDChunk, nil))
+}
+
 func TestCustomChunk(t *testing.T) {
-	open, err := os.Open("./png-format.png")
+	p, err := ParsePng(bytes.NewReader(customPng()))
 	if err != nil {
-		panic(err)
-	}
-	p, err := ParsePng(open)
-	if err != nil {
-		panic(err)
+		t.Fatal(err)
 	}
 	c := &CustomChunkParse{}
 	err = p.ParseChunk(c)
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
+	}
+	if c.Data != "hello" {
+		t.Fatalf("got %q", c.Data)
+	}
+	if err = p.ParseChunk(&CustomChunkParse{}); !errors.Is(err, chunkNotFoundErr) {
+		t.Fatalf("second ParseChunk = %v", err)
+	}
+}
+
+// unregisterChunk undoes RegisterChunk, the registry outlives a test.
+func unregisterChunk(name ChunkName) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	delete(registry, name)
+}
+
+func TestRegisterChunk(t *testing.T) {
+	RegisterChunk("cust", func() ChunkParse { return &CustomChunkParse{} })
+	t.Cleanup(func() { unregisterChunk("cust") })
+	p, err := ParsePng(bytes.NewReader(customPng()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := p.GetOtherChunkByName("cust")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].(*CustomChunkParse).Data != "hello" {
+		t.Fatalf("got %+v", list)
+	}
+}
+
+func TestSkipLeadingGarbage(t *testing.T) {
+	raw, err := os.ReadFile("./demo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bom = append([]byte{0xEF, 0xBB, 0xBF}, raw...)
+	if _, err = ParsePng(bytes.NewReader(bom)); err == nil {
+		t.Fatal("leading bytes accepted without SkipLeadingGarbage")
+	}
+	p, err := ParsePng(bytes.NewReader(bom), SkipLeadingGarbage(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.IHDR.Width != 256 {
+		t.Fatalf("width %d", p.IHDR.Width)
+	}
+	if _, err = ParsePng(bytes.NewReader(raw), SkipLeadingGarbage(0)); err != nil {
+		t.Fatal(err)
+	}
+	var far = append(bytes.Repeat([]byte{'x'}, 2000), raw...)
+	if _, err = ParsePng(bytes.NewReader(far), SkipLeadingGarbage(0)); err == nil {
+		t.Fatal("signature beyond the window accepted")
+	}
+	if _, err = ParsePng(bytes.NewReader(far), SkipLeadingGarbage(4096)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChunkOffsets(t *testing.T) {
+	raw, err := os.ReadFile("./demo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, parse := range []func(io.Reader, ...ParseOption) (*Png, error){ParsePng, ParseMetadata} {
+		for _, garbage := range [][]byte{nil, []byte("junk")} {
+			var in = append(append([]byte(nil), garbage...), raw...)
+			p, err := parse(bytes.NewReader(in), SkipLeadingGarbage(0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var next = int64(len(garbage) + 8)
+			for _, o := range p.ChunkOffsets() {
+				if o.Offset != next {
+					t.Fatalf("%s at %d, want %d", o.Name, o.Offset, next)
+				}
+				if string(in[o.Offset+4:o.Offset+8]) != string(o.Name) {
+					t.Fatalf("no %s at %d", o.Name, o.Offset)
+				}
+				next += o.Size
+			}
+			if next != int64(len(in)) {
+				t.Fatalf("chunks end at %d, file is %d bytes", next, len(in))
+			}
+		}
+	}
+}
+
+func TestPresentChunks(t *testing.T) {
+	var ihdr = &IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Grayscale}
+	// the malformed gAMA isn't parsed into p.GAMA but is present all the same
+	p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), newChunk(GAMAChunk, []byte{0, 1}), newChunk(TEXTChunk, []byte("a\x00b")),
+		blankIDAT(ihdr), newChunk(TEXTChunk, []byte("c\x00d")), newChunk("prVt", nil), newChunk(IENDChunk, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want = map[ChunkName]bool{IHDRChunk: true, GAMAChunk: true, TEXTChunk: true, IDATChunk: true, "prVt": true, IENDChunk: true}
+	if got := p.PresentChunks(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if p.PresentChunks()[ICCPChunk] || p.PresentChunks()[TRNSChunk] {
+		t.Fatal("absent chunk reported present")
+	}
+}
+
+func TestParsePngCRCMismatch(t *testing.T) {
+	var ihdr = &IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Grayscale}
+	var idat = blankIDAT(ihdr)
+	idat.crc[3]++
+	if _, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), idat, newChunk(IENDChunk, nil)))); err == nil {
+		t.Fatal("crc mismatch accepted")
+	}
+}
+
+func TestParsePCAL(t *testing.T) {
+	var want = &PCAL{CalibrationName: "temperature", X0: -10, X1: 65535, EquationType: 0, UnitName: "K", Params: []string{"0", "1.5e2"}}
+	data, err := want.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ihdr = &IHDR{Width: 1, Height: 1, BitDepth: 16, ColorType: Grayscale}
+	p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), newChunk(PCALChunk, data), blankIDAT(ihdr), newChunk(IENDChunk, nil))), Strict())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p.PCAL, want) {
+		t.Fatalf("got %+v, want %+v", p.PCAL, want)
+	}
+
+	data[len("temperature")+10]++ // parameter count no longer matches the equation type
+	if err = (&PCAL{}).Parse(newChunk(PCALChunk, data)); err == nil {
+		t.Fatal("invalid parameter count accepted")
+	}
+}
+
+func TestParseOFFS(t *testing.T) {
+	var want = &OFFS{X: -1200, Y: math.MaxInt32, UnitSpecifier: 1}
+	data, err := want.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data[:4], []byte{0xff, 0xff, 0xfb, 0x50}) {
+		t.Fatalf("x serialized as % x", data[:4])
+	}
+	var ihdr = &IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Grayscale}
+	p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), newChunk(OFFSChunk, data), blankIDAT(ihdr), newChunk(IENDChunk, nil))), Strict())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p.OFFS, want) {
+		t.Fatalf("got %+v, want %+v", p.OFFS, want)
+	}
+	if err = (&OFFS{}).Parse(newChunk(OFFSChunk, data[:8])); err == nil {
+		t.Fatal("short offs accepted")
+	}
+}
+
+func TestParseTIME(t *testing.T) {
+	var cases = []struct {
+		data []byte
+		want time.Time
+	}{
+		{[]byte{0x07, 0xe8, 2, 29, 13, 45, 30}, time.Date(2024, 2, 29, 13, 45, 30, 0, time.UTC)},
+		// leap seconds are normalized to the next minute
+		{[]byte{0x07, 0xdf, 6, 30, 23, 59, 60}, time.Date(2015, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{[]byte{0x07, 0xe8, 3, 1, 10, 0, 60}, time.Date(2024, 3, 1, 10, 1, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		var tm TIME
+		if err := tm.Parse(newChunk(TIMEChunk, c.data)); err != nil {
+			t.Fatal(err)
+		}
+		if got := tm.ToTime(); !got.Equal(c.want) {
+			t.Fatalf("% x: got %v, want %v", c.data, got, c.want)
+		}
+	}
+
+	for _, data := range [][]byte{
+		{0x07, 0xe8, 0, 1, 0, 0, 0},
+		{0x07, 0xe8, 13, 1, 0, 0, 0},
+		{0x07, 0xe8, 1, 0, 0, 0, 0},
+		{0x07, 0xe8, 1, 32, 0, 0, 0},
+		{0x07, 0xe8, 1, 1, 24, 0, 0},
+		{0x07, 0xe8, 1, 1, 0, 60, 0},
+		{0x07, 0xe8, 1, 1, 0, 0, 61},
+		{0x07, 0xe8, 1, 1, 0, 0},
+	} {
+		if err := new(TIME).Parse(newChunk(TIMEChunk, data)); err == nil {
+			t.Fatalf("% x accepted", data)
+		}
+	}
+}
+
+func TestIHDRPixelSize(t *testing.T) {
+	var cases = []struct {
+		ct                     ColorType
+		depth                  uint8
+		channels, bits, nbytes int
+	}{
+		{Grayscale, 1, 1, 1, 1},
+		{Grayscale, 4, 1, 4, 1},
+		{Grayscale, 16, 1, 16, 2},
+		{Truecolor, 8, 3, 24, 3},
+		{Truecolor, 16, 3, 48, 6},
+		{Indexed, 2, 1, 2, 1},
+		{GrayscaleAlpha, 8, 2, 16, 2},
+		{GrayscaleAlpha, 16, 2, 32, 4},
+		{TruecolorAlpha, 8, 4, 32, 4},
+		{TruecolorAlpha, 16, 4, 64, 8},
+	}
+	for _, c := range cases {
+		var ihdr = &IHDR{ColorType: c.ct, BitDepth: c.depth}
+		if ihdr.Channels() != c.channels || ihdr.BitsPerPixel() != c.bits || ihdr.BytesPerPixel() != c.nbytes {
+			t.Errorf("%s/%d: got %d, %d, %d, want %d, %d, %d", c.ct, c.depth,
+				ihdr.Channels(), ihdr.BitsPerPixel(), ihdr.BytesPerPixel(), c.channels, c.bits, c.nbytes)
+		}
+	}
+}
+
+func TestParsePngAutoDecompress(t *testing.T) {
+	raw, err := os.ReadFile("./demo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, _ = zw.Write(raw)
+	_ = zw.Close()
+	for _, r := range []io.Reader{bytes.NewReader(raw), &gz} {
+		p, err := ParsePngAutoDecompress(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.IHDR.Width != 256 || p.IHDR.Height != 81 {
+			t.Fatalf("got %dx%d", p.IHDR.Width, p.IHDR.Height)
+		}
+	}
+}
+
+func TestParsePngMissingIHDR(t *testing.T) {
+	_, err := ParsePng(bytes.NewReader(buildPng(newChunk(IENDChunk, nil))))
+	if !errors.Is(err, ErrMissingIHDR) {
+		t.Fatalf("got %v, want ErrMissingIHDR", err)
+	}
+}
+
+func TestParsePngMissingIDAT(t *testing.T) {
+	var ihdr = &IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Grayscale}
+	_, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), newChunk(IENDChunk, nil))))
+	if !errors.Is(err, ErrMissingIDAT) {
+		t.Fatalf("got %v, want ErrMissingIDAT", err)
+	}
+}
+
+func TestParseMetadata(t *testing.T) {
+	var ihdr = &IHDR{Width: 3, Height: 2, BitDepth: 8, ColorType: Grayscale}
+	var idat = blankIDAT(ihdr)
+	var file = buildPng(ihdrChunk(ihdr), newChunk(GAMAChunk, []byte{0, 0, 0xb1, 0x8f}), idat, idat,
+		newChunk(TEXTChunk, []byte("Title\x00metadata")), newChunk(IENDChunk, nil))
+	// with and without io.Seeker
+	for _, r := range []io.Reader{bytes.NewReader(file), io.MultiReader(bytes.NewReader(file))} {
+		p, err := ParseMetadata(r, Strict())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.GAMA == nil || len(p.TEXTs) != 1 || p.TEXTs[0].Text != "metadata" || p.IHDR.Width != 3 {
+			t.Fatalf("metadata %+v %+v %+v", p.IHDR, p.GAMA, p.TEXTs)
+		}
+		if len(p.IDATs) != 2 || p.IDATs[1].Length != uint32(len(idat.data)) || p.IDATs[1].Data != nil {
+			t.Fatalf("IDATs %+v", p.IDATs)
+		}
+		if _, err = p.ToImage(); !errors.Is(err, ErrNoPixelData) {
+			t.Fatalf("ToImage: %v", err)
+		}
+		if err = p.WritePng(io.Discard); !errors.Is(err, ErrNoPixelData) {
+			t.Fatalf("WritePng: %v", err)
+		}
+		if err = p.Renderable(); !errors.Is(err, ErrNoPixelData) {
+			t.Fatalf("Renderable: %v", err)
+		}
+		if err = p.SetImage(image.NewGray(image.Rect(0, 0, 3, 2))); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = p.ToImage(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRelease(t *testing.T) {
+	p, err := newPngFromImage(testImage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Release()
+	if p.IHDR != nil || p.IDATs != nil || len(p.chunks) != 0 {
+		t.Fatalf("released png keeps %+v, %d IDATs, %d chunks", p.IHDR, len(p.IDATs), len(p.chunks))
+	}
+	if _, err = p.ToImage(); err == nil {
+		t.Fatal("ToImage after Release")
+	}
+	if err = p.WritePng(io.Discard); !errors.Is(err, ErrReleased) {
+		t.Fatalf("WritePng: %v", err)
+	}
+	if _, err = p.FilterHistogram(); err == nil {
+		t.Fatalf("FilterHistogram: %v", err)
+	}
+	p.Release()
+}
+
+func TestParsePngSection(t *testing.T) {
+	var ihdr = &IHDR{Width: 2, Height: 1, BitDepth: 8, ColorType: Grayscale}
+	var embedded = buildPng(ihdrChunk(ihdr), blankIDAT(ihdr), newChunk(IENDChunk, nil))
+	var container = slices.Concat([]byte("header.."), embedded, []byte("trailer"))
+	var r = bytes.NewReader(container)
+	p, err := ParsePngSection(r, 8, int64(len(embedded)), Strict())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.IHDR.Width != 2 || p.ChunkOffsets()[0].Offset != 8 {
+		t.Fatalf("IHDR %+v, offsets %+v", p.IHDR, p.ChunkOffsets())
+	}
+	// the trailer is outside the section unless it's made longer
+	if _, err = ParsePngSection(r, 8, int64(len(embedded))+1, Strict()); err == nil {
+		t.Fatal("data after IEND in the section accepted")
+	}
+	if _, err = ParsePngSection(r, 8, int64(len(embedded))-1); err == nil {
+		t.Fatal("truncated section accepted")
+	}
+	if _, err = ParsePngSection(r, 7, int64(len(embedded))); err == nil {
+		t.Fatal("section off the signature accepted")
+	}
+}
+
+func TestDecodeChunk(t *testing.T) {
+	var ihdr = &IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Grayscale}
+	p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), newChunk(GAMAChunk, []byte{0, 0, 0xb1, 0x8f}),
+		blankIDAT(ihdr), newChunk(IENDChunk, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chunks, others = len(p.chunks), len(p.OtherChunk)
+	for range 2 {
+		var gama GAMA
+		if err = p.DecodeChunk(&gama); err != nil {
+			t.Fatal(err)
+		}
+		if gama.ImageGamma != 45455 {
+			t.Fatalf("gAMA %+v", gama)
+		}
+	}
+	if len(p.chunks) != chunks || len(p.OtherChunk) != others {
+		t.Fatalf("%d chunks and %d others after DecodeChunk, want %d and %d", len(p.chunks), len(p.OtherChunk), chunks, others)
+	}
+	if err = p.DecodeChunk(&PHYS{}); err == nil {
+		t.Fatal("missing pHYs decoded")
+	}
+}
+
+func TestWriteICCProfile(t *testing.T) {
+	var profile = bytes.Repeat([]byte("not really an icc profile "), 20)
+	data, err := (&ICCP{ProfileName: "test profile", Profile: profile}).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ihdr = &IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Truecolor}
+	p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), newChunk(ICCPChunk, data), blankIDAT(ihdr), newChunk(IENDChunk, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.HasICCProfile() || p.ICCP.ProfileName != "test profile" {
+		t.Fatalf("iCCP %+v", p.ICCP)
+	}
+	var buf bytes.Buffer
+	if err = p.WriteICCProfile(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), profile) {
+		t.Fatal("profile differs")
+	}
+
+	p, err = ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), blankIDAT(ihdr), newChunk(IENDChunk, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.HasICCProfile() || p.WriteICCProfile(&buf) == nil {
+		t.Fatal("profile found in a png without iCCP")
+	}
+}
+
+func TestChunkReader(t *testing.T) {
+	var profile = bytes.Repeat([]byte("not really an icc profile "), 20)
+	iccp, _ := (&ICCP{ProfileName: "p", Profile: profile}).Serialize()
+	ztxt, _ := (&ZTXT{Keyword: "Comment", Text: "compressed"}).Serialize()
+	var ihdr = &IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Truecolor}
+	p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), newChunk(ICCPChunk, iccp), newChunk(ZTXTChunk, ztxt),
+		newChunk("prVt", []byte("private")), blankIDAT(ihdr), newChunk(IENDChunk, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[ChunkName][]byte{ICCPChunk: profile, ZTXTChunk: []byte("compressed"), "prVt": []byte("private")} {
+		r, err := p.ChunkReader(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%s: %q", name, got)
+		}
+	}
+	if _, err = p.ChunkReader(TIMEChunk); err == nil {
+		t.Fatal("reader over a missing chunk")
+	}
+}
+
+func TestValidateSignature(t *testing.T) {
+	if err := ValidateSignature(Signature[:]); err != nil {
+		t.Fatal(err)
+	}
+	var cases = []struct {
+		in        []byte
+		offset    int
+		truncated bool
+	}{
+		{[]byte{0x89, 'P', 'N', 'G', 0x0A, 0x1A, 0x0A, 0}, 4, false},
+		{[]byte{0x89, 'P', 'N', 'G', 0x0D, 0x0A, 0x1A}, 7, true},
+		{nil, 0, true},
+		{[]byte("GIF89a.."), 0, false},
+	}
+	for _, c := range cases {
+		err := ValidateSignature(c.in)
+		var se *SignatureError
+		if !errors.Is(err, ErrInvalidSignature) || !errors.As(err, &se) {
+			t.Fatalf("%q: got %v", c.in, err)
+		}
+		if se.Offset != c.offset || se.Truncated != c.truncated {
+			t.Fatalf("%q: got %+v", c.in, se)
+		}
+	}
+
+	raw, err := os.ReadFile("./demo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw = append(raw[:5:5], raw[6:]...) // CRLF translated to LF
+	if _, err = ParsePng(bytes.NewReader(raw)); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("ParsePng = %v", err)
+	}
+}
+
+func TestRepairLineEndings(t *testing.T) {
+	var ihdr = &IHDR{Width: 2, Height: 2, BitDepth: 8, ColorType: Grayscale}
+	var raw = buildPng(ihdrChunk(ihdr), blankIDAT(ihdr), newChunk(IENDChunk, nil))
+	var body = raw[len(Signature):]
+	for _, sig := range lineEndingSignatures {
+		var mangled = append(append([]byte(nil), sig...), body...)
+		if !DetectLineEndingCorruption(mangled) {
+			t.Fatalf("% x not detected", sig)
+		}
+		repaired, err := RepairLineEndings(mangled)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(repaired, raw) {
+			t.Fatalf("% x not repaired", sig)
+		}
+		if _, err = ParsePng(bytes.NewReader(repaired)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if DetectLineEndingCorruption(raw) {
+		t.Fatal("valid signature detected as corrupt")
+	}
+	if _, err := RepairLineEndings([]byte("GIF89a")); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("RepairLineEndings = %v", err)
+	}
+}
+
+func TestSerializeFor(t *testing.T) {
+	var gray8 = &IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Grayscale}
+	data, err := (&TRNS{Gray: 0x00FF}).SerializeFor(gray8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte{0x00, 0xFF}) {
+		t.Fatalf("tRNS % x", data)
+	}
+	p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(gray8), newChunk(TRNSChunk, data), blankIDAT(gray8), newChunk(IENDChunk, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.TRNS.Gray != 0x00FF {
+		t.Fatalf("tRNS gray %#04x", p.TRNS.Gray)
+	}
+	if _, err = (&TRNS{Gray: 0x0100}).SerializeFor(gray8); err == nil {
+		t.Fatal("tRNS key out of range accepted")
+	}
+	if _, err = (&TRNS{}).SerializeFor(&IHDR{BitDepth: 8, ColorType: TruecolorAlpha}); err == nil {
+		t.Fatal("tRNS for truecolor with alpha accepted")
+	}
+
+	var rgb16 = &IHDR{Width: 1, Height: 1, BitDepth: 16, ColorType: Truecolor}
+	var bkgd = &BKGD{Red: 0xFFFF, Green: 0x0102, Blue: 0x00FF}
+	if data, err = bkgd.SerializeFor(rgb16); err != nil {
+		t.Fatal(err)
+	}
+	var got = &BKGD{}
+	if err = got.Parse(newChunk(BKGDChunk, data)); err != nil {
+		t.Fatal(err)
+	}
+	if *got != *bkgd {
+		t.Fatalf("bKGD %+v, want %+v", got, bkgd)
+	}
+	if _, err = bkgd.SerializeFor(&IHDR{BitDepth: 8, ColorType: Truecolor}); err == nil {
+		t.Fatal("bKGD sample out of range accepted")
+	}
+	if data, _ = (&BKGD{Palette: 7}).SerializeFor(&IHDR{BitDepth: 4, ColorType: Indexed}); !bytes.Equal(data, []byte{7}) {
+		t.Fatalf("bKGD % x", data)
+	}
+
+	if data, err = (&SBIT{OrgData: [4]byte{5, 6, 5, 9}}).SerializeFor(&IHDR{BitDepth: 2, ColorType: Indexed}); err != nil || !bytes.Equal(data, []byte{5, 6, 5}) {
+		t.Fatalf("sBIT % x %v", data, err)
+	}
+	if _, err = (&SBIT{OrgData: [4]byte{12}}).SerializeFor(&IHDR{BitDepth: 8, ColorType: Grayscale}); err == nil {
+		t.Fatal("sBIT above the sample depth accepted")
+	}
+}
+
+type recordLogger []string
+
+func (l *recordLogger) Printf(format string, args ...any) {
+	*l = append(*l, fmt.Sprintf(format, args...))
+}
+
+func TestWithLogger(t *testing.T) {
+	var ihdr = &IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Grayscale}
+	var raw = buildPng(ihdrChunk(ihdr), newChunk(GAMAChunk, []byte{0, 1}), blankIDAT(ihdr), newChunk(IENDChunk, nil))
+	var logs recordLogger
+	p, err := ParsePng(bytes.NewReader(raw), WithLogger(&logs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.GAMA != nil {
+		t.Fatal("malformed gAMA parsed")
+	}
+	var want = []string{
+		"read IHDR chunk, length 13 at offset 8",
+		"read gAMA chunk, length 2 at offset 33",
+		"warning: gAMA chunk is 2 bytes, want 4",
+		"read IDAT chunk, length",
+		"read IEND chunk, length 0",
+		"warning: skipping gAMA chunk: invalid gama chunk data",
+	}
+	if len(logs) != len(want) {
+		t.Fatalf("logged %q", logs)
+	}
+	for i := range want {
+		if !strings.HasPrefix(logs[i], want[i]) {
+			t.Fatalf("log %d is %q, want %q", i, logs[i], want[i])
+		}
+	}
+}
+
+func TestPaddedFixedChunk(t *testing.T) {
+	var ihdr = &IHDR{Width: 3, Height: 2, BitDepth: 8, ColorType: Grayscale}
+	var padded = ihdrChunk(ihdr)
+	padded = newChunk(IHDRChunk, append(padded.data, 0))
+	var raw = buildPng(padded, newChunk(GAMAChunk, []byte{0, 1, 0x86, 0xa0, 0, 0}), blankIDAT(ihdr), newChunk(IENDChunk, nil))
+
+	if _, err := ParsePng(bytes.NewReader(raw), Strict()); err == nil || !strings.Contains(err.Error(), "IHDR chunk is 14 bytes") {
+		t.Fatalf("strict parse: %v", err)
+	}
+	var logs recordLogger
+	p, err := ParsePng(bytes.NewReader(raw), WithLogger(&logs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *p.IHDR != *ihdr || p.GAMA == nil || p.GAMA.ImageGamma != 100000 {
+		t.Fatalf("got %+v, %+v", p.IHDR, p.GAMA)
+	}
+	var warnings []string
+	for _, l := range logs {
+		if strings.HasPrefix(l, "warning:") {
+			warnings = append(warnings, l)
+		}
+	}
+	if !slices.Equal(warnings, []string{
+		"warning: IHDR chunk is 14 bytes, using the first 13",
+		"warning: gAMA chunk is 6 bytes, using the first 4",
+	}) {
+		t.Fatalf("logged %q", warnings)
+	}
+	if _, err = p.ToImage(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a truncated chunk is skipped, and rejected by Strict
+	raw = buildPng(ihdrChunk(ihdr), newChunk(GAMAChunk, []byte{0, 1, 0x86}), blankIDAT(ihdr), newChunk(IENDChunk, nil))
+	if _, err = ParsePng(bytes.NewReader(raw), Strict()); err == nil || !strings.Contains(err.Error(), "gAMA chunk is 3 bytes, want 4") {
+		t.Fatalf("strict parse: %v", err)
+	}
+	logs = nil
+	if p, err = ParsePng(bytes.NewReader(raw), WithLogger(&logs)); err != nil {
+		t.Fatal(err)
+	}
+	if p.GAMA != nil || !slices.Contains(logs, "warning: gAMA chunk is 3 bytes, want 4") {
+		t.Fatalf("got %+v, logged %q", p.GAMA, logs)
+	}
+}
+
+func TestPHYSUnitSpecifier(t *testing.T) {
+	var ihdr = &IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Grayscale}
+	var raw = buildPng(ihdrChunk(ihdr), newChunk(PHYSChunk, []byte{0, 0, 0x0e, 0xc4, 0, 0, 0x0e, 0xc4, 2}), blankIDAT(ihdr), newChunk(IENDChunk, nil))
+	if _, err := ParsePng(bytes.NewReader(raw), Strict()); err == nil || !strings.Contains(err.Error(), "unknown phys unit specifier 2") {
+		t.Fatalf("strict parse: %v", err)
+	}
+	var logs recordLogger
+	p, err := ParsePng(bytes.NewReader(raw), WithLogger(&logs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.PHYS != nil {
+		t.Fatalf("pHYs with unit 2 parsed: %+v", p.PHYS)
+	}
+	if !slices.Contains(logs, "warning: skipping pHYs chunk: unknown phys unit specifier 2") {
+		t.Fatalf("logged %q", logs)
+	}
+	for _, unit := range []uint8{0, 1} {
+		var phys PHYS
+		if err = phys.Parse(newChunk(PHYSChunk, []byte{0, 0, 0, 1, 0, 0, 0, 1, unit})); err != nil || phys.UnitSpecifier != unit {
+			t.Fatalf("unit %d: %+v, %v", unit, phys, err)
+		}
+	}
+}
+
+func TestCompressionMethod(t *testing.T) {
+	var ihdr = &IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Grayscale, CompressionMethod: 1}
+	if _, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), blankIDAT(ihdr), newChunk(IENDChunk, nil)))); err == nil {
+		t.Fatal("IHDR compression method 1 accepted")
+	}
+
+	compressed, err := deflate([]byte("text"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cases = []ChunkParse{&ZTXT{}, &ITXT{}, &ICCP{}}
+	var data = [][]byte{
+		append([]byte("Comment\x00\x01"), compressed...),
+		append([]byte("Comment\x00\x01\x01\x00\x00"), compressed...),
+		append([]byte("Profile\x00\x01"), compressed...),
+	}
+	var method = []int{8, 9, 8}
+	for i, c := range cases {
+		if err = c.Parse(newChunk(c.ChunkName(), data[i])); err == nil {
+			t.Fatalf("%s compression method 1 accepted", c.ChunkName())
+		}
+		data[i][method[i]] = 0
+		if err = c.Parse(newChunk(c.ChunkName(), data[i])); err != nil {
+			t.Fatalf("%s: %v", c.ChunkName(), err)
+		}
+	}
+}
+
+func TestChunkNameProperties(t *testing.T) {
+	var cases = []struct {
+		name                                ChunkName
+		critical, public, reserved, safeCpy bool
+	}{
+		{IHDRChunk, true, true, false, false},
+		{IDATChunk, true, true, false, false},
+		{TEXTChunk, false, true, false, true},
+		{GAMAChunk, false, true, false, false},
+		{"prVt", false, false, false, true},
+		{"prVT", false, false, false, false},
+		{"abcd", false, false, true, true},
+	}
+	for _, c := range cases {
+		if c.name.IsCritical() != c.critical || c.name.IsPublic() != c.public ||
+			c.name.IsReserved() != c.reserved || c.name.IsSafeToCopy() != c.safeCpy {
+			t.Fatalf("%s: critical %v, public %v, reserved %v, safe to copy %v", c.name,
+				c.name.IsCritical(), c.name.IsPublic(), c.name.IsReserved(), c.name.IsSafeToCopy())
+		}
+	}
+}
+
+func TestInvalidTypeCode(t *testing.T) {
+	for _, name := range []ChunkName{IHDRChunk, "prVt", "abcd", "ZZzz"} {
+		if !name.IsValidTypeCode() {
+			t.Fatalf("%q reported invalid", name)
+		}
+	}
+	var ihdr = &IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Grayscale}
+	for _, name := range []ChunkName{"\xff\x00ab", "a b\n", "ab[d", "ab@d", "\xe4\xb8\xad\x00", "abc"} {
+		if name.IsValidTypeCode() {
+			t.Fatalf("%q reported valid", name)
+		}
+		if len(name) != 4 {
+			continue
+		}
+		var raw = buildPng(ihdrChunk(ihdr), newChunk(name, []byte("x")), blankIDAT(ihdr), newChunk(IENDChunk, nil))
+		p, err := ParsePng(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = p.Validate(); err == nil || !strings.Contains(err.Error(), "invalid chunk type code") {
+			t.Fatalf("%q: Validate() = %v", name, err)
+		}
+		if _, err = ParsePng(bytes.NewReader(raw), Strict()); err == nil {
+			t.Fatalf("%q accepted by strict parsing", name)
+		}
+		var buf bytes.Buffer
+		if err = p.WritePng(&buf); err != nil || !bytes.Equal(buf.Bytes(), raw) {
+			t.Fatalf("%q not written back as read: %v", name, err)
+		}
+	}
+}
+
+// splitReader returns the data in reads of the sizes cycled through, a size of 0 giving an
+// empty read, and the last bytes together with io.EOF.
+type splitReader struct {
+	data  []byte
+	sizes []int
+	n     int
+}
+
+func (r *splitReader) Read(b []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	var size = min(r.sizes[r.n%len(r.sizes)], len(b), len(r.data))
+	r.n++
+	copy(b, r.data[:size])
+	r.data = r.data[size:]
+	if len(r.data) == 0 {
+		return size, io.EOF
+	}
+	return size, nil
+}
+
+func TestAwkwardReader(t *testing.T) {
+	raw, err := os.ReadFile("./demo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var garbage = append([]byte("junk"), raw...)
+	want, err := ParsePng(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var readers = map[string]func([]byte) io.Reader{
+		"one byte": func(b []byte) io.Reader { return iotest.OneByteReader(bytes.NewReader(b)) },
+		"half":     func(b []byte) io.Reader { return iotest.HalfReader(bytes.NewReader(b)) },
+		"data err": func(b []byte) io.Reader { return iotest.DataErrReader(bytes.NewReader(b)) },
+		// the length and half the type code, the rest of the header, then odd sizes
+		"chunk boundaries": func(b []byte) io.Reader { return &splitReader{data: b, sizes: []int{8, 6, 0, 2, 5, 3, 4097}} },
+	}
+	for name, reader := range readers {
+		p, err := ParsePng(reader(raw))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(p.ChunkOffsets(), want.ChunkOffsets()) {
+			t.Fatalf("%s: chunks %v, want %v", name, p.ChunkOffsets(), want.ChunkOffsets())
+		}
+		var buf bytes.Buffer
+		if err = p.WritePng(&buf); err != nil || !bytes.Equal(buf.Bytes(), raw) {
+			t.Fatalf("%s: not written back as read: %v", name, err)
+		}
+		if _, err = ParsePng(reader(garbage), SkipLeadingGarbage(0)); err != nil {
+			t.Fatalf("%s, leading garbage: %v", name, err)
+		}
+		if _, err = ParseMetadata(reader(raw)); err != nil {
+			t.Fatalf("%s, metadata: %v", name, err)
+		}
+		if _, err = Info(reader(raw)); err != nil {
+			t.Fatalf("%s, info: %v", name, err)
+		}
+		if errs := Lint(reader(raw)); errs != nil {
+			t.Fatalf("%s, lint: %v", name, errs)
+		}
 	}
 }
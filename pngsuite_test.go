@@ -0,0 +1,154 @@
+package simple_png
+
+import (
+	"bytes"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// PngSuite  http://www.schaik.com/pngsuite/
+// testdata/pngsuite holds the basic and filter images of the suite, see its README. go generate
+// extracts the full release over them, the corrupt x* files included.
+//
+//go:generate sh -c "curl -sSfL http://www.schaik.com/pngsuite/PngSuite-2017jul19.tgz | tar -xzf - -C testdata/pngsuite"
+const pngSuiteDir = "testdata/pngsuite"
+
+func pngSuiteFiles(t *testing.T) []string {
+	files, _ := filepath.Glob(filepath.Join(pngSuiteDir, "*.png"))
+	if len(files) == 0 {
+		t.Fatalf("PngSuite not found in %s", pngSuiteDir)
+	}
+	return files
+}
+
+// pngSuiteCorruptions rebuilds corrupt files of the full suite from the vendored images, so the
+// corrupt cases run without it. Each func gets the base image and its chunk offsets.
+var pngSuiteCorruptions = map[string]struct {
+	base    string
+	corrupt func(raw []byte, offsets []ChunkOffset) []byte
+}{
+	"xs1n0g01.png": {"basn0g01.png", setByte(0, 0x09)},
+	"xs2n0g01.png": {"basn0g01.png", setByte(1, 'Q')},
+	"xs4n0g01.png": {"basn0g01.png", setByte(3, 'g')},
+	"xs7n0g01.png": {"basn0g01.png", setByte(6, ' ')},
+	"xc1n0g08.png": {"basn0g08.png", setIHDRByte(9, 1)},
+	"xc9n2c08.png": {"basn2c08.png", setIHDRByte(9, 9)},
+	"xd0n2c08.png": {"basn2c08.png", setIHDRByte(8, 0)},
+	"xd3n2c08.png": {"basn2c08.png", setIHDRByte(8, 3)},
+	"xd9n2c08.png": {"basn2c08.png", setIHDRByte(8, 9)},
+	"xhdn0g08.png": {"basn0g08.png", func(raw []byte, offsets []ChunkOffset) []byte {
+		raw[offsets[0].Offset+offsets[0].Size-1] ^= 0xff
+		return raw
+	}},
+	"xcsn0g01.png": {"basn0g01.png", func(raw []byte, offsets []ChunkOffset) []byte {
+		for _, o := range offsets {
+			if o.Name == IDATChunk {
+				raw[o.Offset+o.Size-1] ^= 0xff
+			}
+		}
+		return raw
+	}},
+	"xdtn0g01.png": {"basn0g01.png", func(raw []byte, offsets []ChunkOffset) []byte {
+		var out = raw[:8:8]
+		for _, o := range offsets {
+			if o.Name != IDATChunk {
+				out = append(out, raw[o.Offset:o.Offset+o.Size]...)
+			}
+		}
+		return out
+	}},
+}
+
+// setByte sets the i-th byte of the file.
+func setByte(i int, b byte) func([]byte, []ChunkOffset) []byte {
+	return func(raw []byte, _ []ChunkOffset) []byte {
+		raw[i] = b
+		return raw
+	}
+}
+
+// setIHDRByte sets the i-th byte of the IHDR data and fixes up its crc.
+func setIHDRByte(i int, b byte) func([]byte, []ChunkOffset) []byte {
+	return func(raw []byte, offsets []ChunkOffset) []byte {
+		var data = raw[offsets[0].Offset+8 : offsets[0].Offset+offsets[0].Size-4]
+		data[i] = b
+		by.PutUint32(raw[offsets[0].Offset+offsets[0].Size-4:], ComputeCRC(IHDRChunk, data))
+		return raw
+	}
+}
+
+func TestPngSuiteValid(t *testing.T) {
+	for _, file := range pngSuiteFiles(t) {
+		if strings.HasPrefix(filepath.Base(file), "x") {
+			continue
+		}
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			raw, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			config, err := png.DecodeConfig(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := ParsePng(bytes.NewReader(raw), Strict())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int(p.IHDR.Width) != config.Width || int(p.IHDR.Height) != config.Height {
+				t.Fatalf("size %dx%d, want %dx%d", p.IHDR.Width, p.IHDR.Height, config.Width, config.Height)
+			}
+			want, err := png.Decode(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := p.ToImage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertSamePixels(t, want, got)
+			if err = p.VerifyLosslessReencode(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestPngSuiteCorrupt(t *testing.T) {
+	var files = map[string]func(t *testing.T) []byte{}
+	for name, c := range pngSuiteCorruptions {
+		files[name] = func(t *testing.T) []byte {
+			raw, err := os.ReadFile(filepath.Join(pngSuiteDir, c.base))
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := ParsePng(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return c.corrupt(raw, p.ChunkOffsets())
+		}
+	}
+	// the files of the full suite, when extracted, take over from the rebuilt ones
+	for _, file := range pngSuiteFiles(t) {
+		if strings.HasPrefix(filepath.Base(file), "x") {
+			files[filepath.Base(file)] = func(t *testing.T) []byte {
+				raw, err := os.ReadFile(file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return raw
+			}
+		}
+	}
+	for name, read := range files {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParsePng(bytes.NewReader(read(t)), Strict()); err == nil {
+				t.Fatal("corrupt file accepted")
+			}
+		})
+	}
+}
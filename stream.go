@@ -0,0 +1,231 @@
+package simple_png
+
+import (
+	"bytes"
+	"compress/zlib"
+	"image"
+	"image/color"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// StreamRGBA writes the image as raw 8-bit non-premultiplied RGBA, row by row, without holding
+// the decoded image. Interlaced images can't be produced by row and are fully decoded first.
+func (p *Png) StreamRGBA(w io.Writer) error {
+	if p.IHDR != nil && p.IHDR.InterlaceMethod != 0 {
+		img, err := p.ToImage()
+		if err != nil {
+			return err
+		}
+		var b = img.Bounds()
+		var buf = make([]byte, b.Dx()*4)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			nrgbaRow(img, y, buf)
+			if _, err = w.Write(buf); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		return nil
+	}
+
+	p.RLock()
+	defer p.RUnlock()
+	if p.IHDR == nil {
+		return errors.New("no IHDR found")
+	}
+	if err := p.IHDR.checkDecodable(); err != nil {
+		return err
+	}
+	var width = int(p.IHDR.Width)
+	d, err := p.newDecoder(image.Rect(0, 0, width, 1))
+	if err != nil {
+		return err
+	}
+	zr, err := zlib.NewReader(p.idatReader())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer zr.Close()
+	var ps = p.IHDR.passes()[0]
+	var sr = newScanlineReader(zr, p.IHDR, width)
+	var buf = make([]byte, width*4)
+	for y := 0; y < ps.height; y++ {
+		row, err := sr.next()
+		if err != nil {
+			return err
+		}
+		if err = d.putRow(row, ps, 0); err != nil {
+			return err
+		}
+		nrgbaRow(d.img, 0, buf)
+		if _, err = w.Write(buf); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// nrgbaRow converts row y of img into 8-bit non-premultiplied RGBA samples.
+func nrgbaRow(img image.Image, y int, dst []byte) {
+	var b = img.Bounds()
+	dst = dst[:b.Dx()*4]
+	switch m := img.(type) {
+	case *image.NRGBA:
+		i := m.PixOffset(b.Min.X, y)
+		copy(dst, m.Pix[i:i+b.Dx()*4])
+	case *image.NRGBA64:
+		i := m.PixOffset(b.Min.X, y)
+		for j := range dst {
+			dst[j] = m.Pix[i+2*j]
+		}
+	default:
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			j := (x - b.Min.X) * 4
+			dst[j], dst[j+1], dst[j+2], dst[j+3] = c.R, c.G, c.B, c.A
+		}
+	}
+}
+
+// DecodeRegion decodes only the pixels inside r, clipped to the image bounds. For non-interlaced
+// images the datastream is decompressed up to the last scanline of r and the following rows are
+// never read. Every scanline above r is still unfiltered, as each one depends on the row before,
+// and each needed scanline is unfiltered across its full width, so narrow regions save little
+// more than the memory of the full image. Interlaced images are fully decoded first.
+func (p *Png) DecodeRegion(r image.Rectangle) (image.Image, error) {
+	p.RLock()
+	if p.IHDR == nil {
+		p.RUnlock()
+		return nil, errors.New("no IHDR found")
+	}
+	var bounds = image.Rect(0, 0, int(p.IHDR.Width), int(p.IHDR.Height))
+	var interlaced = p.IHDR.InterlaceMethod != 0
+	p.RUnlock()
+	r = r.Intersect(bounds)
+	if r.Empty() {
+		return nil, errors.New("region outside the image")
+	}
+	if interlaced {
+		img, err := p.ToImage()
+		if err != nil {
+			return nil, err
+		}
+		return img.(interface {
+			SubImage(image.Rectangle) image.Image
+		}).SubImage(r), nil
+	}
+
+	p.RLock()
+	defer p.RUnlock()
+	if err := p.IHDR.checkDecodable(); err != nil {
+		return nil, err
+	}
+	d, err := p.newDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zlib.NewReader(p.idatReader())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer zr.Close()
+	var ps = p.IHDR.passes()[0]
+	var sr = newScanlineReader(zr, p.IHDR, ps.width)
+	for y := 0; y < r.Max.Y; y++ {
+		row, err := sr.next()
+		if err != nil {
+			return nil, err
+		}
+		if y < r.Min.Y {
+			continue
+		}
+		if err = d.putRow(row, ps, y); err != nil {
+			return nil, err
+		}
+	}
+	return d.img, nil
+}
+
+// DecodeBands decodes the image as horizontal bands of bandHeight rows, the last one possibly shorter.
+// Every scanline depends on the one above through its filter, so decompressing and unfiltering stay
+// sequential; only the conversion of each band's samples into its image runs on its own goroutine.
+// Interlaced images are fully decoded first and split afterwards.
+func (p *Png) DecodeBands(bandHeight int) ([]image.Image, error) {
+	if bandHeight <= 0 {
+		return nil, errors.New("invalid band height")
+	}
+	p.RLock()
+	if p.IHDR == nil {
+		p.RUnlock()
+		return nil, errors.New("no IHDR found")
+	}
+	var width, height = int(p.IHDR.Width), int(p.IHDR.Height)
+	var interlaced = p.IHDR.InterlaceMethod != 0
+	p.RUnlock()
+	if interlaced {
+		img, err := p.ToImage()
+		if err != nil {
+			return nil, err
+		}
+		var bands []image.Image
+		for y := 0; y < height; y += bandHeight {
+			bands = append(bands, img.(interface {
+				SubImage(image.Rectangle) image.Image
+			}).SubImage(image.Rect(0, y, width, min(y+bandHeight, height))))
+		}
+		return bands, nil
+	}
+
+	p.RLock()
+	defer p.RUnlock()
+	if err := p.IHDR.checkDecodable(); err != nil {
+		return nil, err
+	}
+	zr, err := zlib.NewReader(p.idatReader())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer zr.Close()
+	var ps = p.IHDR.passes()[0]
+	var sr = newScanlineReader(zr, p.IHDR, width)
+	var n = (height + bandHeight - 1) / bandHeight
+	var bands = make([]image.Image, n)
+	var errs = make([]error, n)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for i := range bands {
+		var rect = image.Rect(0, i*bandHeight, width, min((i+1)*bandHeight, height))
+		d, err := p.newDecoder(rect)
+		if err != nil {
+			return nil, err
+		}
+		// rows outlive the scanline reader buffers, so each band keeps a copy of its raster
+		var rows = make([][]byte, rect.Dy())
+		for y := range rows {
+			row, err := sr.next()
+			if err != nil {
+				return nil, err
+			}
+			rows[y] = bytes.Clone(row)
+		}
+		bands[i] = d.img
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for y, row := range rows {
+				if errs[i] = d.putRow(row, ps, rect.Min.Y+y); errs[i] != nil {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return bands, nil
+}
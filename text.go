@@ -0,0 +1,285 @@
+package simple_png
+
+import (
+	"bytes"
+	"compress/zlib"
+	"slices"
+
+	"github.com/pkg/errors"
+)
+
+// SetText stores value under keyword in a tEXt chunk, or a zTXt chunk when compress is set.
+// An existing tEXt or zTXt with the same keyword is overwritten in place, otherwise the chunk is added before IEND.
+// The TextCompressionLevel option sets how hard the zTXt text is compressed.
+func (p *Png) SetText(keyword, value string, compress bool, opts ...TextWriteOption) error {
+	var c ChunkSerialize = &TEXT{Keyword: keyword, Separator: " ", Text: value}
+	if compress {
+		c = &ZTXT{Keyword: keyword, Separator: " ", Text: value, Level: newTextWriteConfig(opts).level}
+	}
+	cc, err := serializeChunk(c)
+	if err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.replaceText(cc, keyword, TEXTChunk, ZTXTChunk)
+	return p.syncTexts()
+}
+
+// SetITXt stores value under keyword in an iTXt chunk, overwriting an existing iTXt with the same keyword.
+// The TextCompressionLevel option sets how hard the text is compressed when compress is set.
+func (p *Png) SetITXt(keyword, lang, translated, value string, compress bool, opts ...TextWriteOption) error {
+	var c = &ITXT{Keyword: keyword, LanguageTag: lang, TranslatedKeyword: translated, Text: value,
+		Level: newTextWriteConfig(opts).level}
+	if compress {
+		c.CompressionFlag = 1
+	}
+	cc, err := serializeChunk(c)
+	if err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.replaceText(cc, keyword, ITXTChunk)
+	return p.syncTexts()
+}
+
+// XMPKeyword is the iTXt keyword XMP metadata is stored under.
+const XMPKeyword = "XML:com.adobe.xmp"
+
+// SetXMP stores the XMP packet xml in an uncompressed iTXt chunk keyed XMPKeyword, as XMP readers
+// expect, replacing any XMP already there.
+func (p *Png) SetXMP(xml string) error {
+	return p.SetITXt(XMPKeyword, "", "", xml, false)
+}
+
+// XMP returns the XMP packet of the first iTXt chunk keyed XMPKeyword, ok is false without one.
+func (p *Png) XMP() (xml string, ok bool) {
+	p.RLock()
+	defer p.RUnlock()
+	for _, t := range p.ITXTs {
+		if t.Keyword == XMPKeyword {
+			return t.Text, true
+		}
+	}
+	return "", false
+}
+
+// replaceText puts c at the position of the first text chunk of one of names holding keyword and
+// drops the others, if there is none c is inserted before IEND.
+func (p *Png) replaceText(c *chunk, keyword string, names ...ChunkName) {
+	var chunks = make([]*chunk, 0, len(p.chunks)+1)
+	var placed bool
+	for _, old := range p.chunks {
+		if slices.Contains(names, ChunkName(old.code[:])) && textKeyword(old) == keyword {
+			if !placed {
+				chunks = append(chunks, c)
+				placed = true
+			}
+			continue
+		}
+		if !placed && ChunkName(old.code[:]) == IENDChunk {
+			chunks = append(chunks, c)
+			placed = true
+		}
+		chunks = append(chunks, old)
+	}
+	if !placed {
+		chunks = append(chunks, c)
+	}
+	p.chunks = chunks
+}
+
+// textKeyword the keyword of tEXt, zTXt and iTXt is the data up to the first null separator.
+func textKeyword(c *chunk) string {
+	keyword, _, _ := bytes.Cut(c.data, []byte(nullSep))
+	return string(keyword)
+}
+
+// syncTexts re-parses the text chunks so TEXTs, ZTXTs and ITXTs follow p.chunks.
+func (p *Png) syncTexts() error {
+	p.TEXTs, p.ZTXTs, p.ITXTs = nil, nil, nil
+	for _, c := range p.chunks {
+		var err error
+		switch ChunkName(c.code[:]) {
+		case TEXTChunk:
+			var t = &TEXT{}
+			if err = t.Parse(c); err == nil {
+				p.TEXTs = append(p.TEXTs, t)
+			}
+		case ZTXTChunk:
+			var t = &ZTXT{}
+			if err = t.Parse(c); err == nil {
+				p.ZTXTs = append(p.ZTXTs, t)
+			}
+		case ITXTChunk:
+			var t = &ITXT{}
+			if err = t.Parse(c); err == nil {
+				p.ITXTs = append(p.ITXTs, t)
+			}
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// DuplicateTextKeywords lists, in order of first appearance, the keywords held by more than one
+// tEXt, zTXt or iTXt chunk. Repeated keywords are legal, but surprise code assuming one value each.
+func (p *Png) DuplicateTextKeywords() []string {
+	p.RLock()
+	defer p.RUnlock()
+	var counts = map[string]int{}
+	var order []string
+	for _, c := range p.chunks {
+		switch ChunkName(c.code[:]) {
+		case TEXTChunk, ZTXTChunk, ITXTChunk:
+			var keyword = textKeyword(c)
+			if counts[keyword] == 0 {
+				order = append(order, keyword)
+			}
+			counts[keyword]++
+		}
+	}
+	var dups []string
+	for _, keyword := range order {
+		if counts[keyword] > 1 {
+			dups = append(dups, keyword)
+		}
+	}
+	return dups
+}
+
+// TextEntry is a tEXt, zTXt or iTXt chunk in a form common to the three, Kind tells which.
+type TextEntry struct {
+	Kind    ChunkName
+	Keyword string
+	// LanguageTag and TranslatedKeyword are only set for iTXt
+	LanguageTag       string
+	TranslatedKeyword string
+	// Value is the decompressed text, empty until Decode for an entry left compressed by LazyText
+	Value string
+	// pending is the chunk Decode inflates, nil once Value holds the text
+	pending *chunk
+}
+
+// Compressed reports whether the text is still compressed, Decode must be called to read it.
+func (e *TextEntry) Compressed() bool {
+	return e.pending != nil
+}
+
+// Decode returns Value, decompressing it first for an entry left compressed by LazyText.
+func (e *TextEntry) Decode() (string, error) {
+	if e.pending == nil {
+		return e.Value, nil
+	}
+	var c ChunkParse = &ZTXT{}
+	if e.Kind == ITXTChunk {
+		c = &ITXT{}
+	}
+	if err := c.Parse(e.pending); err != nil {
+		return "", errors.WithStack(err)
+	}
+	switch t := c.(type) {
+	case *ZTXT:
+		e.Value = t.Text
+	case *ITXT:
+		e.Value = t.Text
+	}
+	e.pending = nil
+	return e.Value, nil
+}
+
+type textConfig struct {
+	lazy bool
+}
+
+type TextOption func(*textConfig)
+
+// LazyText makes TextEntries leave compressed text as it is, each entry's Decode inflates it
+// when needed. Without it every zTXt and compressed iTXt is inflated up front.
+func LazyText() TextOption {
+	return func(c *textConfig) {
+		c.lazy = true
+	}
+}
+
+func newTextConfig(opts []TextOption) *textConfig {
+	var c = &textConfig{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+type textWriteConfig struct {
+	level int
+}
+
+// TextWriteOption configures how SetText and SetITXt store text, TextOption how TextEntries reads it.
+type TextWriteOption func(*textWriteConfig)
+
+// TextCompressionLevel sets the compress/zlib level SetText and SetITXt compress text with,
+// zlib.BestCompression for the smallest chunks or zlib.BestSpeed for the fastest writes. The
+// default is zlib.DefaultCompression, as is zlib.NoCompression: uncompressed text belongs in tEXt
+// or an uncompressed iTXt.
+func TextCompressionLevel(level int) TextWriteOption {
+	return func(c *textWriteConfig) {
+		c.level = level
+	}
+}
+
+func newTextWriteConfig(opts []TextWriteOption) *textWriteConfig {
+	var c = &textWriteConfig{level: zlib.DefaultCompression}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// TextEntries returns the tEXt, zTXt and iTXt chunks in file order as TextEntry, for callers that
+// don't care how the text was stored. Chunks that fail to parse are left out.
+func (p *Png) TextEntries(opts ...TextOption) []TextEntry {
+	var conf = newTextConfig(opts)
+	p.RLock()
+	defer p.RUnlock()
+	var entries []TextEntry
+	for _, c := range p.chunks {
+		var e = TextEntry{Kind: ChunkName(c.code[:]), Keyword: textKeyword(c)}
+		switch e.Kind {
+		case TEXTChunk:
+			var t = &TEXT{}
+			if t.Parse(c) != nil {
+				continue
+			}
+			e.Value = t.Text
+		case ZTXTChunk:
+			e.pending = c
+		case ITXTChunk:
+			// keyword, compression flag and method, language tag, translated keyword, text
+			_, rest, _ := bytes.Cut(c.data, []byte(nullSep))
+			if len(rest) < 2 {
+				continue
+			}
+			lang, rest, _ := bytes.Cut(rest[2:], []byte(nullSep))
+			translated, _, ok := bytes.Cut(rest, []byte(nullSep))
+			if !ok {
+				continue
+			}
+			e.LanguageTag, e.TranslatedKeyword = string(lang), string(translated)
+			e.pending = c
+		default:
+			continue
+		}
+		// uncompressed iTXt only needs its utf-8 checked, that is never deferred
+		var compressed = e.Kind == ZTXTChunk || e.Kind == ITXTChunk && c.data[len(e.Keyword)+1] != 0
+		if e.pending != nil && (!conf.lazy || !compressed) {
+			if _, err := e.Decode(); err != nil {
+				continue
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
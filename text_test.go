@@ -0,0 +1,348 @@
+package simple_png
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSetText(t *testing.T) {
+	raw, err := os.ReadFile("./demo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ParsePng(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.SetText("Software", "simple-png", false); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.SetText("Comment", "a long comment, a long comment", true); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.SetITXt("Title", "en", "Title", "héllo wörld", true); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.SetITXt("Title", "en", "Title", "hello world", false); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.TEXTs) != 1 || p.TEXTs[0].Text != "simple-png" {
+		t.Fatalf("tEXt not overwritten: %+v", p.TEXTs)
+	}
+
+	var buf bytes.Buffer
+	if err = p.WritePng(&buf); err != nil {
+		t.Fatal(err)
+	}
+	p, err = ParsePng(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.TEXTs) != 1 || p.TEXTs[0].Keyword != "Software" || p.TEXTs[0].Text != "simple-png" {
+		t.Fatalf("tEXt %+v", p.TEXTs)
+	}
+	if len(p.ZTXTs) != 1 || p.ZTXTs[0].Keyword != "Comment" || p.ZTXTs[0].Text != "a long comment, a long comment" {
+		t.Fatalf("zTXt %+v", p.ZTXTs)
+	}
+	if len(p.ITXTs) != 1 || p.ITXTs[0].LanguageTag != "en" || p.ITXTs[0].Text != "hello world" || p.ITXTs[0].CompressionFlag != 0 {
+		t.Fatalf("iTXt %+v", p.ITXTs)
+	}
+
+	// compressing an existing keyword turns the tEXt into a zTXt
+	if err = p.SetText("Software", "simple-png", true); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.TEXTs) != 0 || len(p.ZTXTs) != 2 {
+		t.Fatalf("tEXt %d zTXt %d", len(p.TEXTs), len(p.ZTXTs))
+	}
+	if err = p.SetText("", "empty keyword", false); err == nil {
+		t.Fatal("empty keyword accepted")
+	}
+}
+
+func TestITXTUTF8(t *testing.T) {
+	for _, compress := range []uint8{0, 1} {
+		var want = &ITXT{Keyword: "Title", CompressionFlag: compress, LanguageTag: "ja", TranslatedKeyword: "タイトル", Text: "smile 😀"}
+		data, err := want.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got = &ITXT{}
+		if err = got.Parse(newChunk(ITXTChunk, data)); err != nil {
+			t.Fatal(err)
+		}
+		if *got != *want {
+			t.Fatalf("got %+v, want %+v", got, want)
+		}
+	}
+
+	var invalid = append([]byte("Title\x00\x00\x00en\x00\x00"), 0xf0, 0x9f, 0x98)
+	if err := (&ITXT{}).Parse(newChunk(ITXTChunk, invalid)); err == nil {
+		t.Fatal("truncated utf-8 text accepted")
+	}
+	if err := (&ITXT{}).Parse(newChunk(ITXTChunk, []byte("Title\x00\x00\x00en\x00\xff\x00text"))); err == nil {
+		t.Fatal("invalid translated keyword accepted")
+	}
+	if _, err := (&ITXT{Keyword: "Title", Text: "\xff"}).Serialize(); err == nil {
+		t.Fatal("invalid utf-8 serialized")
+	}
+}
+
+func TestITXTUTF8Lenient(t *testing.T) {
+	var ihdr = &IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Grayscale}
+	var raw = buildPng(ihdrChunk(ihdr), newChunk(ITXTChunk, []byte("Bad\x00\x00\x00\x00\x00\xff")),
+		newChunk(ITXTChunk, []byte("Title\x00\x00\x00ja\x00\x00smile 😀")), blankIDAT(ihdr), newChunk(IENDChunk, nil))
+	var logs recordLogger
+	p, err := ParsePng(bytes.NewReader(raw), WithLogger(&logs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.ITXTs) != 1 || p.ITXTs[0].Text != "smile 😀" {
+		t.Fatalf("iTXt %+v", p.ITXTs)
+	}
+	if !slices.Contains(logs, "warning: skipping iTXt chunk: itxt text is not valid utf-8") {
+		t.Fatalf("logs %q", logs)
+	}
+	if _, err = ParsePng(bytes.NewReader(raw), Strict()); err == nil {
+		t.Fatal("invalid utf-8 iTXt accepted by Strict")
+	}
+}
+
+func TestITXTCompressionFlag(t *testing.T) {
+	compressed, err := deflate([]byte("zipped"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cases = []struct {
+		data []byte
+		want *ITXT
+	}{
+		{append([]byte("Title\x00\x01\x00en\x00\x00"), compressed...),
+			&ITXT{Keyword: "Title", CompressionFlag: 1, LanguageTag: "en", Text: "zipped"}},
+		// the method of uncompressed text is ignored
+		{[]byte("Title\x00\x00\x07en\x00\x00x\x01"), &ITXT{Keyword: "Title", CompressionMethod: 7, LanguageTag: "en", Text: "x\x01"}},
+		{[]byte("Title\x00\x00\x00\x00\x00plain"), &ITXT{Keyword: "Title", Text: "plain"}},
+	}
+	for i, c := range cases {
+		var got = &ITXT{}
+		if err = got.Parse(newChunk(ITXTChunk, c.data)); err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		if *got != *c.want {
+			t.Fatalf("case %d: got %+v, want %+v", i, got, c.want)
+		}
+	}
+
+	for _, data := range [][]byte{
+		append([]byte("Title\x00\x01\x01en\x00\x00"), compressed...),
+		append([]byte("Title\x00\x02\x00en\x00\x00"), compressed...),
+		[]byte("Title\x00\x01\x00en\x00\x00plain"),
+	} {
+		if err = (&ITXT{}).Parse(newChunk(ITXTChunk, data)); err == nil {
+			t.Fatalf("%q accepted", data)
+		}
+	}
+	for _, c := range []*ITXT{
+		{Keyword: "Title", CompressionFlag: 1, CompressionMethod: 1, Text: "x"},
+		{Keyword: "Title", CompressionFlag: 2, Text: "x"},
+	} {
+		if _, err = c.Serialize(); err == nil {
+			t.Fatalf("%+v serialized", c)
+		}
+	}
+}
+
+func TestTextCompressionLevel(t *testing.T) {
+	var ihdr = &IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Grayscale}
+	var value = strings.Repeat("a fairly repetitive comment, ", 40)
+	var sizes = map[int]int{}
+	for _, level := range []int{zlib.NoCompression, zlib.BestSpeed, zlib.DefaultCompression, zlib.BestCompression, zlib.HuffmanOnly} {
+		p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), blankIDAT(ihdr), newChunk(IENDChunk, nil))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = p.SetText("Comment", value, true, TextCompressionLevel(level)); err != nil {
+			t.Fatal(err)
+		}
+		if err = p.SetITXt("Title", "en", "Title", value, true, TextCompressionLevel(level)); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err = p.WritePng(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if p, err = ParsePng(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if len(p.ZTXTs) != 1 || p.ZTXTs[0].Text != value || len(p.ITXTs) != 1 || p.ITXTs[0].Text != value {
+			t.Fatalf("level %d: text not restored", level)
+		}
+		for _, c := range p.chunks {
+			if ChunkName(c.code[:]) == ZTXTChunk {
+				sizes[level] = len(c.data)
+			}
+		}
+	}
+	if sizes[zlib.HuffmanOnly] <= sizes[zlib.BestCompression] {
+		t.Fatalf("huffman only %d bytes, best compression %d", sizes[zlib.HuffmanOnly], sizes[zlib.BestCompression])
+	}
+
+	p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), blankIDAT(ihdr), newChunk(IENDChunk, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.SetText("Comment", value, true, TextCompressionLevel(42)); err == nil {
+		t.Fatal("compression level 42 accepted")
+	}
+}
+
+func TestDuplicateTextKeywords(t *testing.T) {
+	var ihdr = &IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Grayscale}
+	ztxt, _ := (&ZTXT{Keyword: "Title", Text: "compressed"}).Serialize()
+	itxt, _ := (&ITXT{Keyword: "Comment", LanguageTag: "fr", Text: "bonjour"}).Serialize()
+	p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr),
+		newChunk(TEXTChunk, []byte("Title\x00a")), newChunk(TEXTChunk, []byte("Comment\x00b")), newChunk(TEXTChunk, []byte("Author\x00c")),
+		newChunk(ZTXTChunk, ztxt), blankIDAT(ihdr), newChunk(ITXTChunk, itxt), newChunk(IENDChunk, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.DuplicateTextKeywords(); !slices.Equal(got, []string{"Title", "Comment"}) {
+		t.Fatalf("duplicates %q", got)
+	}
+}
+
+func TestParseTEXT(t *testing.T) {
+	var cases = []struct {
+		data          string
+		keyword, text string
+	}{
+		{"Comment\x00", "Comment", ""},
+		{"Comment\x00 two lines\n", "Comment", " two lines\n"},
+	}
+	for _, c := range cases {
+		var text TEXT
+		if err := text.Parse(newChunk(TEXTChunk, []byte(c.data))); err != nil {
+			t.Fatalf("%q: %v", c.data, err)
+		}
+		if text.Keyword != c.keyword || text.Text != c.text {
+			t.Fatalf("%q: parsed %+v", c.data, text)
+		}
+	}
+
+	if err := new(TEXT).Parse(newChunk(TEXTChunk, []byte("Comment\x00a\x00b"))); !errors.Is(err, ErrTextNull) {
+		t.Fatalf("null in text: %v", err)
+	}
+	for _, data := range []string{"Comment", "\x00text"} {
+		if err := new(TEXT).Parse(newChunk(TEXTChunk, []byte(data))); err == nil {
+			t.Fatalf("%q accepted", data)
+		}
+	}
+	if _, err := (&TEXT{Keyword: "Comment", Text: "a\x00b"}).Serialize(); !errors.Is(err, ErrTextNull) {
+		t.Fatalf("serialized null in text: %v", err)
+	}
+
+	// lenient parsing skips the chunk and carries on, only Strict rejects the file
+	var ihdr = &IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Grayscale}
+	var raw = buildPng(ihdrChunk(ihdr), newChunk(TEXTChunk, []byte("Comment\x00a\x00b")), newChunk(TEXTChunk, []byte("Title\x00ok")),
+		newChunk(ZTXTChunk, []byte("Bad\x00\x00not zlib")), blankIDAT(ihdr), newChunk(IENDChunk, nil))
+	var logs recordLogger
+	p, err := ParsePng(bytes.NewReader(raw), WithLogger(&logs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.TEXTs) != 1 || p.TEXTs[0].Keyword != "Title" || len(p.ZTXTs) != 0 {
+		t.Fatalf("tEXt %+v zTXt %+v", p.TEXTs, p.ZTXTs)
+	}
+	if !slices.Contains(logs, "warning: skipping tEXt chunk: text must not contain a null character") {
+		t.Fatalf("logs %q", logs)
+	}
+	if _, err = ParsePng(bytes.NewReader(raw), Strict()); !errors.Is(err, ErrTextNull) {
+		t.Fatalf("strict: %v", err)
+	}
+}
+
+func TestTextEntries(t *testing.T) {
+	var ihdr = &IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Grayscale}
+	p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), blankIDAT(ihdr), newChunk(IENDChunk, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.SetText("Title", "plain", false); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.SetText("Comment", "squeezed", true); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.SetITXt("Author", "fr", "Auteur", "zoé", true); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.SetITXt("Source", "", "", "caméra", false); err != nil {
+		t.Fatal(err)
+	}
+	var want = []TextEntry{
+		{Kind: TEXTChunk, Keyword: "Title", Value: "plain"},
+		{Kind: ZTXTChunk, Keyword: "Comment", Value: "squeezed"},
+		{Kind: ITXTChunk, Keyword: "Author", LanguageTag: "fr", TranslatedKeyword: "Auteur", Value: "zoé"},
+		{Kind: ITXTChunk, Keyword: "Source", Value: "caméra"},
+	}
+	if got := p.TextEntries(); !slices.Equal(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	var lazy = p.TextEntries(LazyText())
+	if len(lazy) != len(want) {
+		t.Fatalf("%d lazy entries", len(lazy))
+	}
+	for i := range lazy {
+		var compressed = want[i].Kind == ZTXTChunk || want[i].Keyword == "Author"
+		if lazy[i].Compressed() != compressed || compressed && lazy[i].Value != "" {
+			t.Fatalf("lazy entry %d: %+v", i, lazy[i])
+		}
+		value, err := lazy[i].Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != want[i].Value || lazy[i] != want[i] {
+			t.Fatalf("decoded entry %d: %+v", i, lazy[i])
+		}
+	}
+}
+
+func TestXMP(t *testing.T) {
+	var ihdr = &IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Grayscale}
+	p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), blankIDAT(ihdr), newChunk(IENDChunk, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.XMP(); ok {
+		t.Fatal("XMP found in a png without it")
+	}
+	var xmp = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><dc:title>Café ☕</dc:title></x:xmpmeta>`
+	if err = p.SetXMP("<x:xmpmeta/>"); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.SetXMP(xmp); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.SetXMP("\xff"); err == nil {
+		t.Fatal("invalid utf-8 XMP accepted")
+	}
+	var buf bytes.Buffer
+	if err = p.WritePng(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if p, err = ParsePng(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := p.XMP(); !ok || got != xmp {
+		t.Fatalf("XMP() = %q, %v", got, ok)
+	}
+	if len(p.ITXTs) != 1 || p.ITXTs[0].CompressionFlag != 0 || p.ITXTs[0].LanguageTag != "" {
+		t.Fatalf("XMP stored as %+v", p.ITXTs)
+	}
+}
@@ -0,0 +1,318 @@
+package simple_png
+
+import (
+	stderrors "errors"
+	"hash/crc32"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Validate checks the png against the chunk constraints of the spec,
+// every violation found is joined into the returned error.
+func (p *Png) Validate() error {
+	p.RLock()
+	defer p.RUnlock()
+	return stderrors.Join(p.violations()...)
+}
+
+// Lint reads the datastream from r and reports every problem Validate would, plus bad CRCs, a
+// missing IEND and data after it, without building a Png: the IDAT data is checked against its
+// CRC but neither kept nor inflated, and text and iCCP chunks aren't decompressed. It's meant for
+// checking many files for conformance, a nil result means r holds a conformant png.
+func Lint(r io.Reader) []error {
+	if err := readSignature(r); err != nil {
+		return []error{err}
+	}
+	var p = &Png{}
+	var errs []error
+	var offset int64 = 8
+	for {
+		c, err := readChunkHeader(r)
+		if err != nil {
+			errs = append(errs, errors.Wrap(err, "missing IEND chunk"))
+			break
+		}
+		var name = ChunkName(c.code[:])
+		if name == IDATChunk {
+			err = checkChunkBody(r, c)
+		} else {
+			err = readChunkBody(r, c)
+		}
+		if errors.Is(err, ErrCRCMismatch) {
+			errs = append(errs, errors.Wrapf(err, "at offset %d", offset))
+			err = nil
+		}
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "%s chunk at offset %d", name, offset))
+			break
+		}
+		p.chunks = append(p.chunks, c)
+		offset += int64(by.Uint32(c.len[:])) + 12
+		if name == IENDChunk {
+			if n, _ := io.ReadFull(r, make([]byte, 1)); n > 0 {
+				errs = append(errs, errors.Errorf("data after IEND at offset %d", offset))
+			}
+			break
+		}
+	}
+
+	// violations only needs the header, the palette and the histogram
+	if i := p.chunkIndex(IHDRChunk); i < 0 {
+		errs = append(errs, ErrMissingIHDR)
+	} else if ihdr := new(IHDR); lintParse(&errs, ihdr, p.chunks[i]) {
+		p.IHDR = ihdr
+	}
+	if p.chunkIndex(IDATChunk) < 0 {
+		errs = append(errs, ErrMissingIDAT)
+	}
+	if i := p.chunkIndex(PLTEChunk); i >= 0 {
+		if plte := new(PLTE); lintParse(&errs, plte, p.chunks[i]) {
+			p.PLTE = plte
+		}
+	}
+	if i := p.chunkIndex(HISTChunk); i >= 0 {
+		if hist := new(HIST); lintParse(&errs, hist, p.chunks[i]) {
+			p.HIST = hist
+		}
+	}
+	return append(errs, p.violations()...)
+}
+
+// lintParse parses c into cp, appending the error to errs when it fails.
+func lintParse(errs *[]error, cp ChunkParse, c *chunk) bool {
+	if err := cp.Parse(c); err != nil {
+		*errs = append(*errs, errors.Wrapf(err, "%s chunk", cp.ChunkName()))
+		return false
+	}
+	return true
+}
+
+// checkChunkBody is readChunkBody for a chunk whose data is only checked against its crc.
+func checkChunkBody(r io.Reader, c *chunk) error {
+	var h = crc32.NewIEEE()
+	h.Write(c.code[:])
+	if _, err := io.CopyN(h, r, int64(by.Uint32(c.len[:]))); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(r, c.crc[:]); err != nil {
+		return err
+	}
+	if by.Uint32(c.crc[:]) != h.Sum32() {
+		return errors.Wrapf(ErrCRCMismatch, "%s chunk", c.code[:])
+	}
+	return nil
+}
+
+// Renderable returns the first problem that keeps the png from decoding: a missing or illegal
+// IHDR, a PLTE missing for indexed-color or present for grayscale, no image data. It's lighter
+// than Validate, which also reports ordering and conformance issues a decoder can live with.
+func (p *Png) Renderable() error {
+	p.RLock()
+	defer p.RUnlock()
+	if p.IHDR == nil {
+		return errors.New("no IHDR found")
+	}
+	if err := p.IHDR.checkDecodable(); err != nil {
+		return err
+	}
+	switch p.IHDR.ColorType {
+	case Indexed:
+		if p.PLTE == nil || len(p.PLTE.Colors) == 0 {
+			return ErrMissingPLTE
+		}
+	case Grayscale, GrayscaleAlpha:
+		if p.PLTE != nil {
+			return errors.Errorf("PLTE must not appear for color type %d", p.IHDR.ColorType)
+		}
+	}
+	if err := p.pixelErr(); err != nil {
+		return err
+	}
+	for _, c := range p.chunks {
+		if ChunkName(c.code[:]) == IDATChunk && len(c.data) > 0 {
+			return nil
+		}
+	}
+	return ErrMissingIDAT
+}
+
+func (p *Png) violations() []error {
+	var errs []error
+	if p.IHDR != nil {
+		if err := p.IHDR.checkDecodable(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if p.IHDR != nil && p.PLTE != nil {
+		switch p.IHDR.ColorType {
+		case Grayscale, GrayscaleAlpha:
+			errs = append(errs, errors.Errorf("PLTE must not appear for color type %d", p.IHDR.ColorType))
+		}
+	}
+
+	if p.chunkIndex(IHDRChunk) > 0 {
+		errs = append(errs, errors.New("IHDR must be the first chunk"))
+	}
+	var idat = p.chunkIndex(IDATChunk)
+	var plte = p.chunkIndex(PLTEChunk)
+	if idat >= 0 && p.lastChunkIndex(IDATChunk)-idat+1 != p.chunkCount(IDATChunk) {
+		errs = append(errs, errors.New("IDAT chunks must be consecutive"))
+	}
+	for _, rule := range orderRules {
+		var first, last = p.chunkIndex(rule.name), p.lastChunkIndex(rule.name)
+		if first < 0 {
+			continue
+		}
+		if rule.beforePLTE && plte >= 0 && last > plte {
+			errs = append(errs, errors.Errorf("%s must precede PLTE", rule.name))
+		}
+		if rule.afterPLTE && plte > first {
+			errs = append(errs, errors.Errorf("%s must follow PLTE", rule.name))
+		}
+		if rule.beforeIDAT && idat >= 0 && last > idat {
+			errs = append(errs, errors.Errorf("%s must precede the first IDAT", rule.name))
+		}
+	}
+	for _, name := range []ChunkName{PLTEChunk, TIMEChunk} {
+		if n := p.chunkCount(name); n > 1 {
+			errs = append(errs, errors.Errorf("%s appears %d times, at most one is allowed", name, n))
+		}
+	}
+	if i := p.chunkIndex(IENDChunk); i >= 0 && len(p.chunks[i].data) != 0 {
+		errs = append(errs, errors.Errorf("IEND chunk data must be empty, got %d bytes", len(p.chunks[i].data)))
+	}
+	for _, c := range p.chunks {
+		var name = ChunkName(c.code[:])
+		if !name.IsValidTypeCode() {
+			errs = append(errs, errors.Errorf("invalid chunk type code %q", name))
+			continue
+		}
+		if size, ok := fixedChunkSizes[name]; ok && len(c.data) != size {
+			errs = append(errs, errors.Errorf("%s chunk is %d bytes, want %d", name, len(c.data), size))
+		}
+	}
+	if i := p.chunkIndex(PHYSChunk); i >= 0 && len(p.chunks[i].data) >= 9 {
+		if err := checkPhysUnit(p.chunks[i].data[8]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if p.IHDR != nil {
+		errs = append(errs, p.transparencyViolations()...)
+	}
+	if p.chunkIndex(HISTChunk) >= 0 {
+		if p.PLTE == nil {
+			errs = append(errs, errors.New("hIST can appear only when PLTE appears"))
+		} else if p.HIST != nil && len(p.HIST.Elements) != len(p.PLTE.Colors) {
+			errs = append(errs, errors.Errorf("hIST has %d entries, PLTE has %d", len(p.HIST.Elements), len(p.PLTE.Colors)))
+		}
+	}
+	return errs
+}
+
+// transparencyViolations checks tRNS and bKGD against the color type and the palette.
+func (p *Png) transparencyViolations() []error {
+	var errs []error
+	var ct = p.IHDR.ColorType
+	var colors = -1
+	if p.PLTE != nil {
+		colors = len(p.PLTE.Colors)
+	}
+	if ct == Indexed && p.PLTE == nil {
+		errs = append(errs, ErrMissingPLTE)
+	}
+	if i := p.chunkIndex(TRNSChunk); i >= 0 {
+		var n = len(p.chunks[i].data)
+		switch ct {
+		case GrayscaleAlpha, TruecolorAlpha:
+			errs = append(errs, errors.Errorf("tRNS must not appear for color type %d, it has an alpha channel", ct))
+		case Grayscale:
+			if n != 2 {
+				errs = append(errs, errors.Errorf("tRNS is %d bytes, grayscale needs 2", n))
+			}
+		case Truecolor:
+			if n != 6 {
+				errs = append(errs, errors.Errorf("tRNS is %d bytes, truecolor needs 6", n))
+			}
+		case Indexed:
+			if colors >= 0 && n > colors {
+				errs = append(errs, errors.Errorf("tRNS has %d entries, PLTE has %d", n, colors))
+			}
+		}
+	}
+	if i := p.chunkIndex(BKGDChunk); i >= 0 {
+		var data = p.chunks[i].data
+		var want = map[ColorType]int{Indexed: 1, Grayscale: 2, GrayscaleAlpha: 2, Truecolor: 6, TruecolorAlpha: 6}[ct]
+		switch {
+		case want != 0 && len(data) != want:
+			errs = append(errs, errors.Errorf("bKGD is %d bytes, color type %d needs %d", len(data), ct, want))
+		case ct == Indexed && colors >= 0 && int(data[0]) >= colors:
+			errs = append(errs, errors.Errorf("bKGD palette index %d out of range, PLTE has %d entries", data[0], colors))
+		}
+	}
+	return errs
+}
+
+// fixedChunkSizes are the data lengths of the chunks with a fixed layout. Their parsers read the
+// first bytes and ignore any padding after them, and reject shorter data. Validate reports both
+// mismatches, so Strict parsing rejects them while lenient parsing logs a warning and carries on.
+var fixedChunkSizes = map[ChunkName]int{
+	IHDRChunk: 13, CHRMChunk: 32, GAMAChunk: 4, PHYSChunk: 9, OFFSChunk: 9, TIMEChunk: 7,
+	ACTLChunk: 8, FCTLChunk: 26,
+}
+
+// orderRules lists the chunk ordering constraints of the spec, chunks not
+// listed here (tEXt, zTXt, iTXt, tIME and unknown chunks) may appear anywhere
+// between IHDR and IEND.
+var orderRules = []struct {
+	name                              ChunkName
+	beforePLTE, afterPLTE, beforeIDAT bool
+}{
+	{name: PLTEChunk, beforeIDAT: true},
+	{name: CHRMChunk, beforePLTE: true, beforeIDAT: true},
+	{name: GAMAChunk, beforePLTE: true, beforeIDAT: true},
+	{name: ICCPChunk, beforePLTE: true, beforeIDAT: true},
+	{name: SBITChunk, beforePLTE: true, beforeIDAT: true},
+	{name: "sRGB", beforePLTE: true, beforeIDAT: true},
+	{name: BKGDChunk, afterPLTE: true, beforeIDAT: true},
+	{name: HISTChunk, afterPLTE: true, beforeIDAT: true},
+	{name: TRNSChunk, afterPLTE: true, beforeIDAT: true},
+	{name: PHYSChunk, beforeIDAT: true},
+	{name: OFFSChunk, beforeIDAT: true},
+	{name: SCALChunk, beforeIDAT: true},
+	{name: PCALChunk, beforeIDAT: true},
+	{name: "sPLT", beforeIDAT: true},
+	{name: "eXIf", beforeIDAT: true},
+	{name: ACTLChunk, beforeIDAT: true},
+}
+
+// chunkIndex returns the position of the first chunk named name in file order, or -1 if absent.
+func (p *Png) chunkIndex(name ChunkName) int {
+	for i, c := range p.chunks {
+		if ChunkName(c.code[:]) == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// lastChunkIndex returns the position of the last chunk named name in file order, or -1 if absent.
+func (p *Png) lastChunkIndex(name ChunkName) int {
+	for i := len(p.chunks) - 1; i >= 0; i-- {
+		if ChunkName(p.chunks[i].code[:]) == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// chunkCount returns the number of chunks named name.
+func (p *Png) chunkCount(name ChunkName) int {
+	var n int
+	for _, c := range p.chunks {
+		if ChunkName(c.code[:]) == name {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,346 @@
+package simple_png
+
+import (
+	"bytes"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// buildPng assembles a png datastream from raw chunks, without any checks.
+func buildPng(chunks ...*chunk) []byte {
+	var buf bytes.Buffer
+	buf.Write(pngHeaderBytes)
+	for _, c := range chunks {
+		_ = c.writeTo(&buf)
+	}
+	return buf.Bytes()
+}
+
+func ihdrChunk(ihdr *IHDR) *chunk {
+	data, _ := ihdr.Serialize()
+	return newChunk(IHDRChunk, data)
+}
+
+// blankIDAT returns an IDAT holding an all zero raster for ihdr.
+func blankIDAT(ihdr *IHDR) *chunk {
+	var rows = make([][]byte, ihdr.Height)
+	for i := range rows {
+		rows[i] = make([]byte, ihdr.rowBytes(int(ihdr.Width)))
+	}
+	stream, _ := compressRaster(ihdr, rows)
+	return newChunk(IDATChunk, stream)
+}
+
+func TestValidatePLTEForGrayscale(t *testing.T) {
+	var plte = newChunk(PLTEChunk, []byte{1, 2, 3})
+	for _, ct := range []ColorType{Grayscale, GrayscaleAlpha, Truecolor} {
+		var ihdr = &IHDR{Width: 2, Height: 2, BitDepth: 8, ColorType: ct}
+		var raw = buildPng(ihdrChunk(ihdr), plte, blankIDAT(ihdr), newChunk(IENDChunk, nil))
+		p, err := ParsePng(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var invalid = ct != Truecolor
+		if err = p.Validate(); (err != nil) != invalid {
+			t.Fatalf("color type %d: Validate() = %v", ct, err)
+		}
+		if _, err = ParsePng(bytes.NewReader(raw), Strict()); (err != nil) != invalid {
+			t.Fatalf("color type %d: strict ParsePng = %v", ct, err)
+		}
+	}
+}
+
+func TestValidateBKGDOrder(t *testing.T) {
+	var ihdr = &IHDR{Width: 2, Height: 2, BitDepth: 8, ColorType: Indexed}
+	var plte = newChunk(PLTEChunk, []byte{1, 2, 3})
+	var bkgd = newChunk(BKGDChunk, []byte{0})
+	var idat = blankIDAT(ihdr)
+	var iend = newChunk(IENDChunk, nil)
+	var cases = []struct {
+		chunks []*chunk
+		valid  bool
+	}{
+		{[]*chunk{ihdrChunk(ihdr), plte, bkgd, idat, iend}, true},
+		{[]*chunk{ihdrChunk(ihdr), bkgd, plte, idat, iend}, false},
+		{[]*chunk{ihdrChunk(ihdr), plte, idat, bkgd, iend}, false},
+	}
+	for i, c := range cases {
+		p, err := ParsePng(bytes.NewReader(buildPng(c.chunks...)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = p.Validate(); (err == nil) != c.valid {
+			t.Fatalf("case %d: Validate() = %v", i, err)
+		}
+	}
+}
+
+func TestValidateHIST(t *testing.T) {
+	var ihdr = &IHDR{Width: 2, Height: 2, BitDepth: 8, ColorType: Indexed}
+	var plte = newChunk(PLTEChunk, []byte{1, 2, 3, 4, 5, 6})
+	var cases = []struct {
+		chunks []*chunk
+		valid  bool
+	}{
+		{[]*chunk{plte, newChunk(HISTChunk, []byte{0, 1, 0, 2})}, true},
+		{[]*chunk{plte, newChunk(HISTChunk, []byte{0, 1})}, false},
+		{[]*chunk{plte, newChunk(HISTChunk, []byte{0, 1, 0, 2, 0, 3})}, false},
+	}
+	for i, c := range cases {
+		var chunks = append([]*chunk{ihdrChunk(ihdr)}, c.chunks...)
+		chunks = append(chunks, blankIDAT(ihdr), newChunk(IENDChunk, nil))
+		p, err := ParsePng(bytes.NewReader(buildPng(chunks...)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = p.Validate(); (err == nil) != c.valid {
+			t.Fatalf("case %d: Validate() = %v", i, err)
+		}
+	}
+
+	ihdr.ColorType = Truecolor
+	p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), newChunk(HISTChunk, []byte{0, 1}), blankIDAT(ihdr), newChunk(IENDChunk, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Validate(); err == nil {
+		t.Fatal("hIST without PLTE passed Validate")
+	}
+}
+
+func TestValidateTransparency(t *testing.T) {
+	var plte = newChunk(PLTEChunk, []byte{1, 2, 3, 4, 5, 6})
+	var cases = []struct {
+		ct     ColorType
+		chunks []*chunk
+		valid  bool
+	}{
+		{Truecolor, []*chunk{newChunk(TRNSChunk, []byte{0, 1, 0, 2, 0, 3})}, true},
+		{TruecolorAlpha, []*chunk{newChunk(TRNSChunk, []byte{0, 1, 0, 2, 0, 3})}, false},
+		{GrayscaleAlpha, []*chunk{newChunk(TRNSChunk, []byte{0, 1})}, false},
+		{Grayscale, []*chunk{newChunk(TRNSChunk, []byte{0, 1, 0, 2, 0, 3})}, false},
+		{Indexed, []*chunk{plte, newChunk(TRNSChunk, []byte{0, 0x80})}, true},
+		{Indexed, []*chunk{plte, newChunk(TRNSChunk, []byte{0, 0x80, 0xff})}, false},
+		{Indexed, []*chunk{plte, newChunk(BKGDChunk, []byte{1})}, true},
+		{Indexed, []*chunk{plte, newChunk(BKGDChunk, []byte{2})}, false},
+		{Truecolor, []*chunk{newChunk(BKGDChunk, []byte{0, 1})}, false},
+		{Indexed, nil, false},
+	}
+	for i, c := range cases {
+		var ihdr = &IHDR{Width: 2, Height: 2, BitDepth: 8, ColorType: c.ct}
+		var chunks = append([]*chunk{ihdrChunk(ihdr)}, c.chunks...)
+		chunks = append(chunks, blankIDAT(ihdr), newChunk(IENDChunk, nil))
+		p, err := ParsePng(bytes.NewReader(buildPng(chunks...)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = p.Validate(); (err == nil) != c.valid {
+			t.Fatalf("case %d: Validate() = %v", i, err)
+		}
+	}
+}
+
+func TestValidateIHDR(t *testing.T) {
+	for _, ihdr := range []*IHDR{
+		{Width: 1, Height: 1, BitDepth: 8, ColorType: 1},
+		{Width: 1, Height: 1, BitDepth: 3, ColorType: Truecolor},
+		{Width: 1, Height: 1, BitDepth: 16, ColorType: Indexed},
+		{Width: 1, Height: 1, BitDepth: 8, ColorType: Grayscale, InterlaceMethod: 2},
+	} {
+		var raw = buildPng(ihdrChunk(ihdr), newChunk(IDATChunk, []byte{0x78, 0x9c, 0x63, 0, 0, 0, 1, 0, 1}), newChunk(IENDChunk, nil))
+		if _, err := ParsePng(bytes.NewReader(raw), Strict()); err == nil {
+			t.Fatalf("%+v accepted", *ihdr)
+		}
+	}
+}
+
+func TestValidateAfterIDAT(t *testing.T) {
+	var ihdr = &IHDR{Width: 2, Height: 2, BitDepth: 8, ColorType: Truecolor}
+	var cases = []struct {
+		chunk *chunk
+		valid bool
+	}{
+		{newChunk(TEXTChunk, []byte("Comment\x00late")), true},
+		{newChunk(TIMEChunk, []byte{7, 232, 1, 1, 0, 0, 0}), true},
+		{newChunk(GAMAChunk, []byte{0, 0, 0xb1, 0x8f}), false},
+		{newChunk(PHYSChunk, []byte{0, 0, 0x0e, 0xc4, 0, 0, 0x0e, 0xc4, 1}), false},
+		{newChunk(PLTEChunk, []byte{1, 2, 3}), false},
+	}
+	for i, c := range cases {
+		p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(ihdr), blankIDAT(ihdr), c.chunk, newChunk(IENDChunk, nil))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = p.Validate(); (err == nil) != c.valid {
+			t.Fatalf("case %d: Validate() = %v", i, err)
+		}
+	}
+}
+
+func TestValidateDuplicateTIME(t *testing.T) {
+	var ihdr = &IHDR{Width: 2, Height: 2, BitDepth: 8, ColorType: Grayscale}
+	var first = newChunk(TIMEChunk, []byte{0x07, 0xe8, 1, 2, 3, 4, 5})
+	var second = newChunk(TIMEChunk, []byte{0x07, 0xe9, 6, 7, 8, 9, 10})
+	var raw = buildPng(ihdrChunk(ihdr), first, blankIDAT(ihdr), second, newChunk(IENDChunk, nil))
+	p, err := ParsePng(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.AllTIME) != 2 || p.TIME != p.AllTIME[0] || p.AllTIME[1].Year != 2025 {
+		t.Fatalf("tIME %+v, all %+v", p.TIME, p.AllTIME)
+	}
+	if err = p.Validate(); err == nil {
+		t.Fatal("duplicate tIME passed Validate")
+	}
+	if _, err = ParsePng(bytes.NewReader(raw), Strict()); err == nil {
+		t.Fatal("duplicate tIME passed strict ParsePng")
+	}
+}
+
+func TestIHDRZeroSize(t *testing.T) {
+	for _, ihdr := range []*IHDR{
+		{Width: 0, Height: 1, BitDepth: 8, ColorType: Grayscale},
+		{Width: 1, Height: 0, BitDepth: 8, ColorType: Grayscale},
+		{Width: 1 << 31, Height: 1, BitDepth: 8, ColorType: Grayscale},
+	} {
+		var raw = buildPng(ihdrChunk(ihdr), newChunk(IDATChunk, []byte{0x78, 0x9c, 0x63, 0, 0, 0, 1, 0, 1}), newChunk(IENDChunk, nil))
+		if _, err := ParsePng(bytes.NewReader(raw)); err == nil {
+			t.Fatalf("%dx%d accepted", ihdr.Width, ihdr.Height)
+		}
+		if err := NewPng(ihdr).Validate(); err == nil {
+			t.Fatalf("%dx%d passed Validate", ihdr.Width, ihdr.Height)
+		}
+	}
+}
+
+func TestStrictIEND(t *testing.T) {
+	var ihdr = &IHDR{Width: 2, Height: 2, BitDepth: 8, ColorType: Grayscale}
+	var valid = buildPng(ihdrChunk(ihdr), blankIDAT(ihdr), newChunk(IENDChunk, nil))
+	for i, raw := range [][]byte{
+		append(slices.Clone(valid), buildPng(newChunk("prVt", []byte("bogus")))[len(pngHeaderBytes):]...),
+		append(slices.Clone(valid), 0),
+		buildPng(ihdrChunk(ihdr), blankIDAT(ihdr), newChunk(IENDChunk, []byte{1})),
+	} {
+		if _, err := ParsePng(bytes.NewReader(raw)); err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		if _, err := ParsePng(bytes.NewReader(raw), Strict()); err == nil {
+			t.Fatalf("case %d: strict ParsePng accepted it", i)
+		}
+	}
+	if _, err := ParsePng(bytes.NewReader(valid), Strict()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRenderable(t *testing.T) {
+	var gray = &IHDR{Width: 2, Height: 2, BitDepth: 8, ColorType: Grayscale}
+	var indexed = &IHDR{Width: 2, Height: 2, BitDepth: 8, ColorType: Indexed}
+	var plte = newChunk(PLTEChunk, []byte{1, 2, 3})
+	var cases = []struct {
+		chunks []*chunk
+		ok     bool
+	}{
+		{[]*chunk{ihdrChunk(gray), blankIDAT(gray)}, true},
+		{[]*chunk{ihdrChunk(indexed), plte, blankIDAT(indexed)}, true},
+		// out of order, Validate complains but it still decodes
+		{[]*chunk{ihdrChunk(indexed), blankIDAT(indexed), plte}, true},
+		{[]*chunk{ihdrChunk(indexed), blankIDAT(indexed)}, false},
+		{[]*chunk{ihdrChunk(gray), plte, blankIDAT(gray)}, false},
+		{[]*chunk{ihdrChunk(gray), newChunk(IDATChunk, nil)}, false},
+	}
+	for i, c := range cases {
+		p, err := ParsePng(bytes.NewReader(buildPng(append(c.chunks, newChunk(IENDChunk, nil))...)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = p.Renderable(); (err == nil) != c.ok {
+			t.Fatalf("case %d: Renderable() = %v", i, err)
+		}
+	}
+	if err := NewPng(gray).Renderable(); err == nil {
+		t.Fatal("png without IDAT is renderable")
+	}
+}
+
+func TestDuplicatePLTE(t *testing.T) {
+	var ihdr = &IHDR{Width: 2, Height: 2, BitDepth: 8, ColorType: Indexed}
+	var raw = buildPng(ihdrChunk(ihdr), newChunk(PLTEChunk, []byte{1, 2, 3}), newChunk(PLTEChunk, []byte{4, 5, 6, 7, 8, 9}),
+		blankIDAT(ihdr), newChunk(IENDChunk, nil))
+	var logs recordLogger
+	p, err := ParsePng(bytes.NewReader(raw), WithLogger(&logs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.PLTE.Colors) != 1 || p.PLTE.Colors[0].Red != 1 {
+		t.Fatalf("PLTE %+v, want the first one", p.PLTE.Colors)
+	}
+	if !slices.ContainsFunc(logs, func(l string) bool { return strings.Contains(l, "2 PLTE chunks") }) {
+		t.Fatalf("no warning logged: %q", logs)
+	}
+	if _, err = ParsePng(bytes.NewReader(raw), Strict()); err == nil {
+		t.Fatal("duplicate PLTE passed strict ParsePng")
+	}
+}
+
+func TestLint(t *testing.T) {
+	var ihdr = &IHDR{Width: 4, Height: 4, BitDepth: 8, ColorType: TruecolorAlpha}
+	var valid = buildPng(ihdrChunk(ihdr), newChunk(TEXTChunk, []byte("Comment\x00hello")), blankIDAT(ihdr), newChunk(IENDChunk, nil))
+	if errs := Lint(bytes.NewReader(valid)); errs != nil {
+		t.Fatalf("valid png: %v", errs)
+	}
+
+	var idat = blankIDAT(ihdr)
+	var raw = buildPng(ihdrChunk(ihdr), newChunk(TRNSChunk, []byte{0, 1}), newChunk(IDATChunk, idat.data[:5]),
+		newChunk(TEXTChunk, []byte("Comment\x00hello")), newChunk(IDATChunk, idat.data[5:]))
+	raw[bytes.Index(raw, []byte("hello"))] = 'j'
+	var errs = Lint(bytes.NewReader(raw))
+	for _, want := range []string{
+		"at offset 64: tEXt chunk: crc mismatch",
+		"missing IEND chunk",
+		"tRNS must not appear for color type 6",
+		"IDAT chunks must be consecutive",
+	} {
+		if !slices.ContainsFunc(errs, func(err error) bool { return strings.Contains(err.Error(), want) }) {
+			t.Fatalf("%q not reported in %v", want, errs)
+		}
+	}
+
+	errs = Lint(bytes.NewReader(buildPng(newChunk(TEXTChunk, []byte("Comment\x00hello")), ihdrChunk(ihdr), newChunk(IENDChunk, []byte{0}))))
+	for _, want := range []string{"IHDR must be the first chunk", "missing IDAT chunk", "IEND chunk data must be empty"} {
+		if !slices.ContainsFunc(errs, func(err error) bool { return strings.Contains(err.Error(), want) }) {
+			t.Fatalf("%q not reported in %v", want, errs)
+		}
+	}
+	if errs = Lint(bytes.NewReader(append(slices.Clone(valid), 0))); len(errs) != 1 || !strings.Contains(errs[0].Error(), "data after IEND") {
+		t.Fatalf("trailing data: %v", errs)
+	}
+	if errs = Lint(strings.NewReader("GIF89a")); len(errs) != 1 {
+		t.Fatalf("not a png: %v", errs)
+	}
+}
+
+func BenchmarkLint(b *testing.B) {
+	var rnd = rand.New(rand.NewSource(1))
+	var raw = randomPng(rnd, &IHDR{Width: 512, Height: 512, BitDepth: 8, ColorType: TruecolorAlpha}, false)
+	b.Run("Lint", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if errs := Lint(bytes.NewReader(raw)); errs != nil {
+				b.Fatal(errs)
+			}
+		}
+	})
+	b.Run("ParsePng+Validate", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			p, err := ParsePng(bytes.NewReader(raw))
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err = p.Validate(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
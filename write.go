@@ -0,0 +1,721 @@
+package simple_png
+
+import (
+	"bytes"
+	"compress/zlib"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"io"
+	"slices"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultIDATChunkSize = 8192
+	// maxChunkLength the chunk length is a 4-byte unsigned integer, but it is restricted to (2^31)-1 bytes
+	maxChunkLength = 1<<31 - 1
+)
+
+type writeConfig struct {
+	idatChunkSize int
+	// resplit is set by IDATChunkSize, IDAT chunks kept by KeepRaw are re-split only then
+	resplit      bool
+	recomputeCRC bool
+}
+
+type WriteOption func(*writeConfig)
+
+// IDATChunkSize controls how the compressed datastream is fragmented across IDAT chunks,
+// non-positive sizes fall back to the default 8192 and sizes above (2^31)-1 are clamped.
+func IDATChunkSize(size int) WriteOption {
+	return func(c *writeConfig) {
+		c.resplit = true
+		switch {
+		case size <= 0:
+			c.idatChunkSize = defaultIDATChunkSize
+		case size > maxChunkLength:
+			c.idatChunkSize = maxChunkLength
+		default:
+			c.idatChunkSize = size
+		}
+	}
+}
+
+// RecomputeCRC makes WritePng compute the crc of every chunk afresh. By default only edited chunks
+// get a new crc and the others are written with the crc they were read with, see KeepBadCRC.
+func RecomputeCRC() WriteOption {
+	return func(c *writeConfig) {
+		c.recomputeCRC = true
+	}
+}
+
+// writeChunk writes c, with a fresh crc when RecomputeCRC is given.
+func (c *writeConfig) writeChunk(w io.Writer, ch *chunk) error {
+	if c.recomputeCRC {
+		ch = ch.withData(ch.data)
+	}
+	return ch.writeTo(w)
+}
+
+func newWriteConfig(opts []WriteOption) *writeConfig {
+	var c = &writeConfig{idatChunkSize: defaultIDATChunkSize}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// ComputeCRC calculates the chunk crc over the chunk type code and chunk data fields,
+// using the ISO_3309_CRC polynomial.
+func ComputeCRC(name ChunkName, data []byte) uint32 {
+	var crc = crc32.Update(0, crc32.IEEETable, []byte(name))
+	return crc32.Update(crc, crc32.IEEETable, data)
+}
+
+func newChunk(name ChunkName, data []byte) *chunk {
+	var c = &chunk{data: data, offset: -1}
+	by.PutUint32(c.len[:], uint32(len(data)))
+	copy(c.code[:], name)
+	by.PutUint32(c.crc[:], ComputeCRC(name, data))
+	return c
+}
+
+func serializeChunk(c ChunkSerialize) (*chunk, error) {
+	data, err := c.Serialize()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(data) > maxChunkLength {
+		return nil, errors.New("chunk data too long")
+	}
+	return newChunk(c.ChunkName(), data), nil
+}
+
+// withData returns a copy of c holding data, marked dirty so its crc is computed when written.
+func (c *chunk) withData(data []byte) *chunk {
+	var e = *c
+	by.PutUint32(e.len[:], uint32(len(data)))
+	e.data, e.raw, e.dirty = data, nil, true
+	return &e
+}
+
+func (c *chunk) writeTo(w io.Writer) error {
+	if c.raw != nil && !c.dirty {
+		_, err := w.Write(c.raw)
+		return errors.WithStack(err)
+	}
+	var crc = c.crc
+	if c.dirty {
+		by.PutUint32(crc[:], ComputeCRC(ChunkName(c.code[:]), c.data))
+	}
+	for _, b := range [][]byte{c.len[:], c.code[:], c.data, crc[:]} {
+		if _, err := w.Write(b); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// WritePng writes the png datastream, chunks are emitted in p.chunks order and
+// the concatenated IDAT datastream is re-split according to IDATChunkSize.
+// The compressed image data is written back verbatim, only SetImage recompresses it.
+// Once the image has been replaced, unknown chunks that aren't safe to copy are dropped.
+// Chunks keep the crc they were read with, edited ones get a fresh one, see RecomputeCRC.
+func (p *Png) WritePng(w io.Writer, opts ...WriteOption) error {
+	p.RLock()
+	defer p.RUnlock()
+	var conf = newWriteConfig(opts)
+	if err := p.pixelErr(); err != nil {
+		return err
+	}
+	if len(p.chunks) == 0 {
+		return errors.New("no chunk to write")
+	}
+	if _, err := w.Write(pngHeaderBytes); err != nil {
+		return errors.WithStack(err)
+	}
+	var idatWritten bool
+	for _, c := range p.chunks {
+		if !p.keepOnWrite(c) {
+			continue
+		}
+		if ChunkName(c.code[:]) != IDATChunk {
+			if err := conf.writeChunk(w, c); err != nil {
+				return err
+			}
+			continue
+		}
+		if idatWritten {
+			continue
+		}
+		idatWritten = true
+		if p.rawIDATs(conf) {
+			for _, c := range p.chunks {
+				if ChunkName(c.code[:]) == IDATChunk {
+					if err := conf.writeChunk(w, c); err != nil {
+						return err
+					}
+				}
+			}
+			continue
+		}
+		if err := writeIDATs(w, p.idatStream(), conf.idatChunkSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Png) idatStream() []byte {
+	var stream []byte
+	for _, c := range p.chunks {
+		if ChunkName(c.code[:]) == IDATChunk {
+			stream = append(stream, c.data...)
+		}
+	}
+	return stream
+}
+
+// EstimateSize returns the number of bytes WritePng writes with the same options. The IDAT
+// datastream is already compressed, so the size is exact unless the png changes in between.
+func (p *Png) EstimateSize(opts ...WriteOption) (int, error) {
+	p.RLock()
+	defer p.RUnlock()
+	var conf = newWriteConfig(opts)
+	if err := p.pixelErr(); err != nil {
+		return 0, err
+	}
+	if len(p.chunks) == 0 {
+		return 0, errors.New("no chunk to write")
+	}
+	var size = len(pngHeaderBytes)
+	var idat int
+	for _, c := range p.chunks {
+		if ChunkName(c.code[:]) == IDATChunk {
+			idat += len(c.data)
+			continue
+		}
+		if !p.keepOnWrite(c) {
+			continue
+		}
+		size += len(c.data) + 12
+	}
+	var n = (idat + conf.idatChunkSize - 1) / conf.idatChunkSize
+	if p.rawIDATs(conf) {
+		n = 0
+		for _, c := range p.chunks {
+			if ChunkName(c.code[:]) == IDATChunk {
+				n++
+			}
+		}
+	}
+	return size + idat + n*12, nil
+}
+
+// keepOnWrite reports whether WritePng writes c. Following the spec's rules for editors, once the
+// image data has been replaced, unknown ancillary chunks are only kept when they are safe to copy.
+func (p *Png) keepOnWrite(c *chunk) bool {
+	var name = ChunkName(c.code[:])
+	if !p.modified || name.IsCritical() || name.IsSafeToCopy() || knownChunks[name] {
+		return true
+	}
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	_, ok := registry[name]
+	return ok
+}
+
+// rawIDATs reports whether the IDAT chunks are written as read, which needs all of them kept by KeepRaw.
+func (p *Png) rawIDATs(conf *writeConfig) bool {
+	if conf.resplit {
+		return false
+	}
+	for _, c := range p.chunks {
+		if ChunkName(c.code[:]) == IDATChunk && c.raw == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func writeIDATs(w io.Writer, stream []byte, size int) error {
+	for len(stream) > 0 {
+		n := min(size, len(stream))
+		if err := newChunk(IDATChunk, stream[:n]).writeTo(w); err != nil {
+			return err
+		}
+		stream = stream[n:]
+	}
+	return nil
+}
+
+// Encode writes the image m to w in png format. Paletted and gray images are stored at the
+// smallest bit depth of 1, 2, 4 and 8 that holds their samples exactly.
+func Encode(w io.Writer, m image.Image, opts ...WriteOption) error {
+	p, err := newPngFromImage(m)
+	if err != nil {
+		return err
+	}
+	return p.WritePng(w, opts...)
+}
+
+// WriteCanonical writes only the pixels in a canonical form: IHDR, IDAT and IEND, truecolor
+// with alpha only when a pixel isn't opaque, 8 bit unless a sample needs 16, fixed compression
+// and IDAT chunk size. Pngs with identical pixels give byte-identical output whatever their
+// color type, bit depth, interlacing or ancillary chunks.
+func (p *Png) WriteCanonical(w io.Writer) error {
+	img, err := p.DecodeAs(color.NRGBA64Model)
+	if err != nil {
+		return err
+	}
+	var m image.Image = img
+	if fitsIn8Bits(img.(*image.NRGBA64)) {
+		if m, err = p.DecodeAs(color.NRGBAModel); err != nil {
+			return err
+		}
+	}
+	return Encode(w, m)
+}
+
+func fitsIn8Bits(m *image.NRGBA64) bool {
+	for i := 0; i < len(m.Pix); i += 2 {
+		if m.Pix[i] != m.Pix[i+1] {
+			return false
+		}
+	}
+	return true
+}
+
+// SetImage replaces the image data with m, IHDR, PLTE and IDAT are rebuilt the way Encode
+// builds them. tRNS, bKGD, sBIT and hIST depend on the old color type and are dropped,
+// every other chunk is kept in place.
+func (p *Png) SetImage(m image.Image) error {
+	np, err := newPngFromImage(m)
+	if err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	return p.replaceImage(np)
+}
+
+// replaceImage swaps in the IHDR, PLTE, tRNS and IDAT of np, np.chunks must be laid out the way
+// newPngFromRaster lays them out.
+func (p *Png) replaceImage(np *Png) error {
+	var chunks = make([]*chunk, 0, len(p.chunks))
+	var idatDone bool
+	for _, c := range p.chunks {
+		switch ChunkName(c.code[:]) {
+		case IHDRChunk:
+			chunks = append(chunks, np.chunks[0])
+		case IDATChunk, IENDChunk:
+			if !idatDone {
+				chunks = append(chunks, np.chunks[1:len(np.chunks)-1]...)
+				idatDone = true
+			}
+			if ChunkName(c.code[:]) == IENDChunk {
+				chunks = append(chunks, c)
+			}
+		case PLTEChunk, TRNSChunk, BKGDChunk, SBITChunk, HISTChunk:
+		default:
+			chunks = append(chunks, c)
+		}
+	}
+	if !idatDone {
+		return errors.New("no IEND found")
+	}
+	p.chunks = chunks
+	p.IHDR, p.PLTE, p.TRNS, p.IDATs = np.IHDR, np.PLTE, np.TRNS, np.IDATs
+	p.BKGD, p.SBIT, p.HIST = nil, nil, nil
+	p.modified, p.metadataOnly = true, false
+	return nil
+}
+
+// SetIDAT replaces the image data with data, a zlib datastream of the filtered scanlines
+// described by IHDR. WritePng splits it into IDAT chunks.
+func (p *Png) SetIDAT(data []byte) error {
+	p.Lock()
+	defer p.Unlock()
+	var chunks = make([]*chunk, 0, len(p.chunks))
+	var placed bool
+	for _, c := range p.chunks {
+		switch ChunkName(c.code[:]) {
+		case IDATChunk, IENDChunk:
+			if !placed {
+				chunks = append(chunks, newChunk(IDATChunk, data))
+				placed = true
+			}
+			if ChunkName(c.code[:]) == IENDChunk {
+				chunks = append(chunks, c)
+			}
+		default:
+			chunks = append(chunks, c)
+		}
+	}
+	if !placed {
+		return errors.New("no IEND found")
+	}
+	p.chunks = chunks
+	p.IDATs = []*IDAT{{Length: uint32(len(data)), ChunkTypeCode: string(IDATChunk), Data: data}}
+	p.modified, p.metadataOnly = true, false
+	return nil
+}
+
+// SetChunkData replaces the data of the index-th chunk named name, counting from 0 in file order,
+// in place. The typed fields follow the new data, on a parse error p is left as it was. The chunk's
+// crc is computed when written, every other chunk keeps the crc it was read with.
+func (p *Png) SetChunkData(name ChunkName, index int, data []byte) error {
+	p.Lock()
+	defer p.Unlock()
+	var chunks = slices.Clone(p.chunks)
+	var seen int
+	for i, c := range chunks {
+		if ChunkName(c.code[:]) != name {
+			continue
+		}
+		if seen == index {
+			chunks[i] = c.withData(slices.Clone(data))
+			if err := p.reparse(chunks); err != nil {
+				return err
+			}
+			p.modified = p.modified || name.IsCritical()
+			return nil
+		}
+		seen++
+	}
+	return errors.Errorf("%s chunk %d not found", name, index)
+}
+
+// CoalesceIDAT merges the IDAT chunks into chunks of targetSize bytes, the last one holding the
+// remainder, without recompressing the datastream. targetSize is interpreted as by IDATChunkSize.
+// WritePng keeps the merged chunks as they are unless given IDATChunkSize. It does nothing to a
+// png parsed by ParseMetadata or released.
+func (p *Png) CoalesceIDAT(targetSize int) {
+	p.Lock()
+	defer p.Unlock()
+	if p.pixelErr() != nil {
+		return
+	}
+	var size = newWriteConfig([]WriteOption{IDATChunkSize(targetSize)}).idatChunkSize
+	var stream = p.idatStream()
+	var chunks = make([]*chunk, 0, len(p.chunks))
+	var idats []*IDAT
+	var placed bool
+	for _, c := range p.chunks {
+		if ChunkName(c.code[:]) != IDATChunk {
+			chunks = append(chunks, c)
+			continue
+		}
+		if placed {
+			continue
+		}
+		placed = true
+		for rest := stream; len(rest) > 0; rest = rest[min(size, len(rest)):] {
+			var data = rest[:min(size, len(rest))]
+			var c = newChunk(IDATChunk, data)
+			var raw bytes.Buffer
+			_ = c.writeTo(&raw)
+			c.raw = raw.Bytes()
+			chunks = append(chunks, c)
+			idats = append(idats, &IDAT{Length: uint32(len(data)), ChunkTypeCode: string(IDATChunk), Data: data})
+		}
+	}
+	p.chunks = chunks
+	p.IDATs = idats
+}
+
+// Minimize strips the png down to the chunks needed to render it: IHDR, IDAT and IEND, plus PLTE
+// for indexed-color and tRNS whenever the image has one, every other chunk and typed field is
+// dropped. Animation chunks go too, leaving the default image.
+func (p *Png) Minimize() {
+	p.Lock()
+	defer p.Unlock()
+	var chunks = make([]*chunk, 0, len(p.chunks))
+	for _, c := range p.chunks {
+		switch ChunkName(c.code[:]) {
+		case IHDRChunk, IDATChunk, IENDChunk, TRNSChunk:
+			chunks = append(chunks, c)
+		case PLTEChunk:
+			if p.IHDR != nil && p.IHDR.ColorType == Indexed {
+				chunks = append(chunks, c)
+			}
+		}
+	}
+	p.chunks = chunks
+	if p.IHDR == nil || p.IHDR.ColorType != Indexed {
+		p.PLTE = nil
+	}
+	p.BKGD, p.CHRM, p.GAMA, p.HIST, p.PHYS, p.OFFS, p.SCAL, p.SBIT, p.PCAL, p.ICCP, p.ACTL = nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil
+	p.TEXTs, p.TIME, p.AllTIME, p.ZTXTs, p.ITXTs = nil, nil, nil, nil, nil
+	p.OtherChunk = map[ChunkName][]ChunkParse{}
+}
+
+// orderRanks groups the chunks NormalizeOrder sorts by, chunks not listed rank before the
+// image data when found before the first IDAT, and after it otherwise.
+var orderRanks = map[ChunkName]int{
+	IHDRChunk: 0,
+	CHRMChunk: 1, GAMAChunk: 1, ICCPChunk: 1, "sRGB": 1, SBITChunk: 1,
+	PLTEChunk: 2,
+	BKGDChunk: 3, HISTChunk: 3, TRNSChunk: 3,
+	PHYSChunk: 4, "sPLT": 4, OFFSChunk: 4, SCALChunk: 4, PCALChunk: 4, "eXIf": 4, ACTLChunk: 4,
+	IDATChunk: 6,
+	TIMEChunk: 7, TEXTChunk: 7, ZTXTChunk: 7, ITXTChunk: 7,
+	IENDChunk: 8,
+}
+
+// NormalizeOrder sorts the chunks into a canonical order: IHDR, the color space chunks (cHRM,
+// gAMA, iCCP, sRGB, sBIT), PLTE, bKGD, hIST and tRNS, the physical and other chunks that must
+// precede the image data, the IDATs, then tIME and the text chunks, and IEND. Chunks within a
+// group keep their relative order. Unknown chunks stay on their side of the image data, and
+// APNG frame chunks stay where they are relative to the IDATs, so every ordering constraint an
+// input met still holds.
+func (p *Png) NormalizeOrder() {
+	p.Lock()
+	defer p.Unlock()
+	var ranks = make(map[*chunk]int, len(p.chunks))
+	var seenIDAT bool
+	for _, c := range p.chunks {
+		var name = ChunkName(c.code[:])
+		rank, ok := orderRanks[name]
+		switch {
+		case ok:
+		case name == FCTLChunk || name == FDATChunk:
+			// the default image's fcTL precedes the IDATs, later frames follow them in order
+			rank = 5
+			if seenIDAT {
+				rank = 6
+			}
+		case seenIDAT:
+			rank = 7
+		default:
+			rank = 5
+		}
+		seenIDAT = seenIDAT || name == IDATChunk
+		ranks[c] = rank
+	}
+	var chunks = slices.Clone(p.chunks)
+	slices.SortStableFunc(chunks, func(a, b *chunk) int { return ranks[a] - ranks[b] })
+	p.chunks = chunks
+}
+
+func newPngFromImage(m image.Image) (*Png, error) {
+	var b = m.Bounds()
+	if b.Dx() <= 0 || b.Dy() <= 0 {
+		return nil, errors.New("invalid image size")
+	}
+	ihdr, plte, raw := rasterize(m)
+	ihdr.Width, ihdr.Height = uint32(b.Dx()), uint32(b.Dy())
+	return newPngFromRaster(ihdr, plte, nil, raw)
+}
+
+// newPngFromRaster builds a png holding IHDR, [PLTE], [tRNS], IDAT and IEND from unfiltered scanlines.
+func newPngFromRaster(ihdr *IHDR, plte *PLTE, trns []byte, raw [][]byte) (*Png, error) {
+	var p = &Png{IHDR: ihdr, PLTE: plte, IEND: &IEND{}, OtherChunk: map[ChunkName][]ChunkParse{}}
+	stream, err := compressRaster(ihdr, raw)
+	if err != nil {
+		return nil, err
+	}
+	var cs = []ChunkSerialize{ihdr}
+	if plte != nil {
+		cs = append(cs, plte)
+	}
+	for _, c := range cs {
+		cc, err := serializeChunk(c)
+		if err != nil {
+			return nil, err
+		}
+		p.chunks = append(p.chunks, cc)
+	}
+	if trns != nil {
+		var c = newChunk(TRNSChunk, trns)
+		p.TRNS = &TRNS{}
+		if err = p.TRNS.Parse(c); err != nil {
+			return nil, err
+		}
+		p.chunks = append(p.chunks, c)
+	}
+	var idat = newChunk(IDATChunk, stream)
+	p.IDATs = []*IDAT{{Length: uint32(len(stream)), ChunkTypeCode: string(IDATChunk), Data: stream}}
+	p.chunks = append(p.chunks, idat, newChunk(IENDChunk, nil))
+	return p, nil
+}
+
+// rasterize converts m into unfiltered scanlines, choosing the color type from the image type.
+func rasterize(m image.Image) (*IHDR, *PLTE, [][]byte) {
+	var b = m.Bounds()
+	var ihdr = &IHDR{BitDepth: 8}
+	var rows = make([][]byte, b.Dy())
+	switch img := m.(type) {
+	case *image.Gray:
+		ihdr.ColorType = Grayscale
+		for y := range rows {
+			i := img.PixOffset(b.Min.X, b.Min.Y+y)
+			rows[y] = img.Pix[i : i+b.Dx()]
+		}
+		ihdr.BitDepth = grayDepth(rows)
+		if ihdr.BitDepth < 8 {
+			var scale = 0xff / (uint8(1)<<ihdr.BitDepth - 1)
+			for y, row := range rows {
+				rows[y] = packSamples(row, ihdr.BitDepth, func(v uint8) uint8 { return v / scale })
+			}
+		}
+		return ihdr, nil, rows
+	case *image.Gray16:
+		ihdr.ColorType, ihdr.BitDepth = Grayscale, 16
+		for y := range rows {
+			i := img.PixOffset(b.Min.X, b.Min.Y+y)
+			rows[y] = img.Pix[i : i+b.Dx()*2]
+		}
+		return ihdr, nil, rows
+	case *image.Paletted:
+		if plte := opaquePalette(img.Palette); plte != nil {
+			ihdr.ColorType = Indexed
+			var maxIndex = len(img.Palette) - 1
+			for y := range rows {
+				i := img.PixOffset(b.Min.X, b.Min.Y+y)
+				rows[y] = img.Pix[i : i+b.Dx()]
+				maxIndex = max(maxIndex, int(slices.Max(rows[y])))
+			}
+			for ihdr.BitDepth > 1 && maxIndex < 1<<(ihdr.BitDepth/2) {
+				ihdr.BitDepth /= 2
+			}
+			if ihdr.BitDepth < 8 {
+				for y, row := range rows {
+					rows[y] = packSamples(row, ihdr.BitDepth, func(v uint8) uint8 { return v })
+				}
+			}
+			return ihdr, plte, rows
+		}
+	}
+
+	var deep = isDeep(m)
+	var opaque = isOpaque(m)
+	ihdr.ColorType = TruecolorAlpha
+	if opaque {
+		ihdr.ColorType = Truecolor
+	}
+	if deep {
+		ihdr.BitDepth = 16
+	}
+	return ihdr, nil, truecolorRows(m, deep, opaque)
+}
+
+// grayDepth returns the smallest bit depth holding every 8 bit gray sample of rows exactly, a
+// sample fits depth d when it's a multiple of 255/(2^d-1), the scaling the decoder applies.
+func grayDepth(rows [][]byte) uint8 {
+	var depth uint8 = 1
+	for _, row := range rows {
+		for _, v := range row {
+			for depth < 8 && v%(0xff/(uint8(1)<<depth-1)) != 0 {
+				depth *= 2
+			}
+			if depth == 8 {
+				return 8
+			}
+		}
+	}
+	return depth
+}
+
+// packSamples packs one sample per byte of row at depth bits each, most significant bits first,
+// the last byte padded with zero bits. conv maps a byte of row to its sample.
+func packSamples(row []byte, depth uint8, conv func(uint8) uint8) []byte {
+	var packed = make([]byte, (len(row)*int(depth)+7)/8)
+	for i, v := range row {
+		var bit = i * int(depth)
+		packed[bit/8] |= conv(v) << (8 - int(depth) - bit%8)
+	}
+	return packed
+}
+
+func isDeep(m image.Image) bool {
+	switch m.(type) {
+	case *image.RGBA64, *image.NRGBA64, *image.Gray16:
+		return true
+	}
+	return false
+}
+
+// truecolorRows converts m into truecolor scanlines, 16 bit when deep, with alpha unless opaque.
+func truecolorRows(m image.Image, deep, opaque bool) [][]byte {
+	var b = m.Bounds()
+	var ihdr = &IHDR{ColorType: TruecolorAlpha, BitDepth: 8}
+	if opaque {
+		ihdr.ColorType = Truecolor
+	}
+	if deep {
+		ihdr.BitDepth = 16
+	}
+	var rows = make([][]byte, b.Dy())
+	for y := range rows {
+		var row = make([]byte, 0, ihdr.rowBytes(b.Dx()))
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if deep {
+				c := color.NRGBA64Model.Convert(m.At(x, b.Min.Y+y)).(color.NRGBA64)
+				row = append(row, uint8(c.R>>8), uint8(c.R), uint8(c.G>>8), uint8(c.G), uint8(c.B>>8), uint8(c.B))
+				if !opaque {
+					row = append(row, uint8(c.A>>8), uint8(c.A))
+				}
+				continue
+			}
+			c := color.NRGBAModel.Convert(m.At(x, b.Min.Y+y)).(color.NRGBA)
+			row = append(row, c.R, c.G, c.B)
+			if !opaque {
+				row = append(row, c.A)
+			}
+		}
+		rows[y] = row
+	}
+	return rows
+}
+
+// opaquePalette returns nil if the palette can't be stored as a PLTE chunk alone.
+func opaquePalette(pal color.Palette) *PLTE {
+	if len(pal) == 0 || len(pal) > 256 {
+		return nil
+	}
+	var plte = &PLTE{}
+	for _, c := range pal {
+		nc := color.NRGBAModel.Convert(c).(color.NRGBA)
+		if nc.A != 0xff {
+			return nil
+		}
+		plte.Colors = append(plte.Colors, &PLTEColor{Red: nc.R, Green: nc.G, Blue: nc.B})
+	}
+	return plte
+}
+
+func isOpaque(m image.Image) bool {
+	if o, ok := m.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	var b = m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := m.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// compressRaster filters every scanline and compresses them into the zlib datastream stored in IDAT.
+func compressRaster(ihdr *IHDR, rows [][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	var bpp = ihdr.BytesPerPixel()
+	var prev = make([]byte, ihdr.rowBytes(int(ihdr.Width)))
+	for _, row := range rows {
+		if _, err := zw.Write(adaptiveFilter(row, prev, bpp)); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		prev = row
+	}
+	if err := zw.Close(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buf.Bytes(), nil
+}
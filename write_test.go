@@ -0,0 +1,661 @@
+package simple_png
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var idatChunkSizes = []int{1, 7, 100, 4096, 0, maxChunkLength + 1}
+
+func testImage() *image.NRGBA {
+	var m = image.NewNRGBA(image.Rect(0, 0, 37, 23))
+	for y := 0; y < 23; y++ {
+		for x := 0; x < 37; x++ {
+			m.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 7), G: uint8(y * 11), B: uint8(x * y), A: uint8(255 - x - y)})
+		}
+	}
+	return m
+}
+
+func assertSamePixels(t testing.TB, want, got image.Image) {
+	t.Helper()
+	if want.Bounds() != got.Bounds() {
+		t.Fatalf("bounds %v, want %v", got.Bounds(), want.Bounds())
+	}
+	var b = want.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			wr, wg, wb, wa := want.At(x, y).RGBA()
+			gr, gg, gb, ga := got.At(x, y).RGBA()
+			if wr != gr || wg != gg || wb != gb || wa != ga {
+				t.Fatalf("pixel (%d,%d) = %v, want %v", x, y, got.At(x, y), want.At(x, y))
+			}
+		}
+	}
+}
+
+func TestEncodeIDATChunkSize(t *testing.T) {
+	var m = testImage()
+	for _, size := range idatChunkSizes {
+		var buf bytes.Buffer
+		if err := Encode(&buf, m, IDATChunkSize(size)); err != nil {
+			t.Fatal(err)
+		}
+		p, err := ParsePng(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var limit = newWriteConfig([]WriteOption{IDATChunkSize(size)}).idatChunkSize
+		for _, idat := range p.IDATs {
+			if int(idat.Length) > limit || len(idat.Data) > limit {
+				t.Fatalf("size %d: IDAT of %d bytes", size, idat.Length)
+			}
+		}
+		got, err := png.Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertSamePixels(t, m, got)
+	}
+}
+
+func TestWritePngIDATChunkSize(t *testing.T) {
+	raw, err := os.ReadFile("./demo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := png.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ParsePng(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range idatChunkSizes {
+		var buf bytes.Buffer
+		if err := p.WritePng(&buf, IDATChunkSize(size)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := png.Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertSamePixels(t, want, got)
+	}
+}
+
+func TestComputeCRC(t *testing.T) {
+	// the IEND chunk is always AE 42 60 82
+	if crc := ComputeCRC(IENDChunk, nil); crc != 0xAE426082 {
+		t.Fatalf("IEND crc %08X", crc)
+	}
+}
+
+func TestWritePngKeepsIDAT(t *testing.T) {
+	raw, err := os.ReadFile("./demo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ParsePng(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stream = p.idatStream()
+	if err = p.SetText("Comment", "metadata only", true); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = p.WritePng(&buf); err != nil {
+		t.Fatal(err)
+	}
+	q, err := ParsePng(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(q.idatStream(), stream) {
+		t.Fatal("metadata edit changed the IDAT datastream")
+	}
+
+	var want = testImage()
+	if err = q.SetImage(want); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err = q.WritePng(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSamePixels(t, want, got)
+}
+
+func TestEstimateSize(t *testing.T) {
+	raw, err := os.ReadFile("./demo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ParsePng(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range idatChunkSizes {
+		var buf bytes.Buffer
+		if err = p.WritePng(&buf, IDATChunkSize(size)); err != nil {
+			t.Fatal(err)
+		}
+		n, err := p.EstimateSize(IDATChunkSize(size))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != buf.Len() {
+			t.Fatalf("IDATChunkSize(%d): estimated %d, wrote %d", size, n, buf.Len())
+		}
+	}
+}
+
+func TestNewPng(t *testing.T) {
+	var ihdr = &IHDR{Width: 4, Height: 3, BitDepth: 8, ColorType: Grayscale}
+	var want = image.NewGray(image.Rect(0, 0, 4, 3))
+	var rows [][]byte
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 4; x++ {
+			want.SetGray(x, y, color.Gray{Y: uint8(x*60 + y)})
+		}
+		rows = append(rows, want.Pix[y*want.Stride:y*want.Stride+4])
+	}
+	stream, err := compressRaster(ihdr, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p = NewPng(ihdr)
+	if err = p.SetIDAT(stream); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = p.WritePng(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSamePixels(t, want, got)
+
+	p = NewPng(ihdr)
+	if err = p.SetImage(testImage()); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err = p.WritePng(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = png.Decode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	assertSamePixels(t, testImage(), got)
+}
+
+func TestSetIDATSplits(t *testing.T) {
+	var ihdr = &IHDR{Width: 100, Height: 100, BitDepth: 8, ColorType: Truecolor}
+	src, err := ParsePng(bytes.NewReader(randomPng(rand.New(rand.NewSource(3)), ihdr, false)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stream = src.idatStream()
+	if len(stream) <= 8192 {
+		t.Fatalf("datastream of %d bytes fits one IDAT", len(stream))
+	}
+	var p = NewPng(ihdr)
+	if err = p.SetIDAT(stream); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = p.WritePng(&buf); err != nil {
+		t.Fatal(err)
+	}
+	q, err := ParsePng(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (len(stream) + 8191) / 8192; len(q.IDATs) != want {
+		t.Fatalf("%d IDATs, want %d", len(q.IDATs), want)
+	}
+	for _, idat := range q.IDATs {
+		if idat.Length > 8192 {
+			t.Fatalf("IDAT of %d bytes", idat.Length)
+		}
+	}
+}
+
+func TestKeepRaw(t *testing.T) {
+	var ihdr = &IHDR{Width: 20, Height: 20, BitDepth: 8, ColorType: Grayscale}
+	var stream = blankIDAT(ihdr).data
+	var raw = buildPng(ihdrChunk(ihdr), newChunk("prVt", []byte("private")),
+		newChunk(IDATChunk, stream[:5]), newChunk(IDATChunk, nil), newChunk(IDATChunk, stream[5:]), newChunk(IENDChunk, nil))
+
+	p, err := ParsePng(bytes.NewReader(raw), KeepRaw())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = p.WritePng(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), raw) {
+		t.Fatal("KeepRaw round trip isn't byte-identical")
+	}
+	if n, _ := p.EstimateSize(); n != len(raw) {
+		t.Fatalf("EstimateSize %d, want %d", n, len(raw))
+	}
+
+	buf.Reset()
+	if err = p.WritePng(&buf, IDATChunkSize(0)); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(buf.Bytes(), raw) {
+		t.Fatal("IDATChunkSize didn't re-split the kept IDAT chunks")
+	}
+
+	p, err = ParsePng(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err = p.WritePng(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(buf.Bytes(), raw) {
+		t.Fatal("IDAT chunks kept without KeepRaw")
+	}
+}
+
+func TestWriteCanonical(t *testing.T) {
+	var pixels = [][]uint16{{0, 0x55, 0xaa}, {0xff, 0x11, 0x22}}
+	var gray = &IHDR{Width: 3, Height: 2, BitDepth: 8, ColorType: Grayscale}
+	var gray2 = &IHDR{Width: 3, Height: 2, BitDepth: 2, ColorType: Grayscale}
+	var gray16 = &IHDR{Width: 3, Height: 2, BitDepth: 16, ColorType: Grayscale, InterlaceMethod: 1}
+	var rgba = &IHDR{Width: 3, Height: 2, BitDepth: 8, ColorType: TruecolorAlpha}
+	var wide = func(f func(uint16) []uint16) [][]uint16 {
+		var rows [][]uint16
+		for _, row := range pixels {
+			var r []uint16
+			for _, v := range row {
+				r = append(r, f(v)...)
+			}
+			rows = append(rows, r)
+		}
+		return rows
+	}
+	var inputs = [][]byte{
+		buildPng(ihdrChunk(gray), newChunk(IDATChunk, encodeSamples(gray, pixels)), newChunk(IENDChunk, nil)),
+		buildPng(ihdrChunk(gray16), newChunk(TEXTChunk, []byte("Comment\x00x")),
+			newChunk(IDATChunk, encodeSamples(gray16, wide(func(v uint16) []uint16 { return []uint16{v * 0x101} }))), newChunk(IENDChunk, nil)),
+		buildPng(ihdrChunk(rgba), newChunk(IDATChunk, encodeSamples(rgba, wide(func(v uint16) []uint16 { return []uint16{v, v, v, 0xff} }))), newChunk(IENDChunk, nil)),
+	}
+	var want []byte
+	for i, raw := range inputs {
+		p, err := ParsePng(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err = p.WriteCanonical(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			want = buf.Bytes()
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Fatalf("input %d: canonical output differs", i)
+		}
+	}
+
+	// different pixels give different output
+	p, err := ParsePng(bytes.NewReader(buildPng(ihdrChunk(gray2), newChunk(IDATChunk, encodeSamples(gray2, [][]uint16{{0, 1, 2}, {3, 0, 0}})), newChunk(IENDChunk, nil))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = p.WriteCanonical(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(buf.Bytes(), want) {
+		t.Fatal("different pixels gave identical output")
+	}
+}
+
+func TestCoalesceIDAT(t *testing.T) {
+	var ihdr = &IHDR{Width: 20, Height: 20, BitDepth: 8, ColorType: Grayscale}
+	var stream = blankIDAT(ihdr).data
+	var chunks = []*chunk{ihdrChunk(ihdr)}
+	for _, b := range stream {
+		chunks = append(chunks, newChunk(IDATChunk, []byte{b}))
+	}
+	chunks = append(chunks, newChunk(TEXTChunk, []byte("Comment\x00after")), newChunk(IENDChunk, nil))
+	p, err := ParsePng(bytes.NewReader(buildPng(chunks...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := p.ToImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.CoalesceIDAT(4)
+	if n := (len(stream) + 3) / 4; len(p.IDATs) != n || p.chunkCount(IDATChunk) != n {
+		t.Fatalf("%d IDATs, %d chunks, want %d", len(p.IDATs), p.chunkCount(IDATChunk), n)
+	}
+	if !bytes.Equal(p.idatStream(), stream) {
+		t.Fatal("datastream changed")
+	}
+	var buf bytes.Buffer
+	if err = p.WritePng(&buf); err != nil {
+		t.Fatal(err)
+	}
+	q, err := ParsePng(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.IDATs) != len(p.IDATs) {
+		t.Fatalf("wrote %d IDATs, want %d", len(q.IDATs), len(p.IDATs))
+	}
+	got, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSamePixels(t, want, got)
+
+	m, err := ParseMetadata(bytes.NewReader(buildPng(chunks...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.CoalesceIDAT(4)
+	if len(m.IDATs) != len(stream) || m.chunkCount(IDATChunk) != len(stream) {
+		t.Fatalf("metadata only png left with %d IDATs, %d chunks", len(m.IDATs), m.chunkCount(IDATChunk))
+	}
+}
+
+func TestWritePngUnsafeToCopy(t *testing.T) {
+	var ihdr = &IHDR{Width: 2, Height: 2, BitDepth: 8, ColorType: Grayscale}
+	var raw = buildPng(ihdrChunk(ihdr), newChunk("prVt", []byte("safe")), newChunk("prVT", []byte("unsafe")),
+		blankIDAT(ihdr), newChunk(IENDChunk, nil))
+	var names = func(p *Png) []ChunkName {
+		var buf bytes.Buffer
+		if err := p.WritePng(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if n, _ := p.EstimateSize(); n != buf.Len() {
+			t.Fatalf("EstimateSize %d, wrote %d", n, buf.Len())
+		}
+		q, err := ParsePng(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []ChunkName
+		for _, c := range q.ChunkOffsets() {
+			names = append(names, c.Name)
+		}
+		return names
+	}
+	p, err := ParsePng(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(p); !slices.Contains(got, "prVt") || !slices.Contains(got, "prVT") {
+		t.Fatalf("unmodified png wrote %v", got)
+	}
+	if err = p.SetImage(testImage()); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(p); !slices.Contains(got, "prVt") || slices.Contains(got, "prVT") {
+		t.Fatalf("modified png wrote %v", got)
+	}
+}
+
+func TestMinimize(t *testing.T) {
+	for _, ct := range []ColorType{Indexed, Truecolor} {
+		var ihdr = &IHDR{Width: 2, Height: 2, BitDepth: 8, ColorType: ct}
+		var trns = []byte{0}
+		if ct == Truecolor {
+			trns = make([]byte, 6)
+		}
+		var raw = buildPng(ihdrChunk(ihdr), newChunk(GAMAChunk, []byte{0, 0, 0xb1, 0x8f}), newChunk(PLTEChunk, []byte{1, 2, 3, 4, 5, 6}),
+			newChunk(TRNSChunk, trns), newChunk(TEXTChunk, []byte("Comment\x00x")),
+			blankIDAT(ihdr), newChunk("prVt", []byte("private")), newChunk(IENDChunk, nil))
+		p, err := ParsePng(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := p.ToImage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Minimize()
+		var names []ChunkName
+		for _, c := range p.ChunkOffsets() {
+			names = append(names, c.Name)
+		}
+		var keep = []ChunkName{IHDRChunk, TRNSChunk, IDATChunk, IENDChunk}
+		if ct == Indexed {
+			keep = []ChunkName{IHDRChunk, PLTEChunk, TRNSChunk, IDATChunk, IENDChunk}
+		}
+		if !slices.Equal(names, keep) {
+			t.Fatalf("color type %d: chunks %v, want %v", ct, names, keep)
+		}
+		if p.GAMA != nil || p.TEXTs != nil || (p.PLTE != nil) != (ct == Indexed) || p.TRNS == nil {
+			t.Fatalf("color type %d: typed fields kept", ct)
+		}
+		var buf bytes.Buffer
+		if err = p.WritePng(&buf); err != nil {
+			t.Fatal(err)
+		}
+		got, err := png.Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertSamePixels(t, want, got)
+	}
+}
+
+func TestNormalizeOrder(t *testing.T) {
+	var ihdr = &IHDR{Width: 2, Height: 2, BitDepth: 8, ColorType: Indexed}
+	var idat = blankIDAT(ihdr)
+	var raw = buildPng(ihdrChunk(ihdr), newChunk(TEXTChunk, []byte("Comment\x00x")), newChunk("prVb", nil),
+		newChunk(PHYSChunk, []byte{0, 0, 0, 1, 0, 0, 0, 1, 0}), newChunk(GAMAChunk, []byte{0, 0, 0xb1, 0x8f}),
+		newChunk(PLTEChunk, []byte{1, 2, 3, 4, 5, 6}), newChunk(TRNSChunk, []byte{0}), newChunk(TIMEChunk, []byte{0x07, 0xe8, 1, 1, 0, 0, 0}),
+		newChunk(IDATChunk, idat.data[:4]), newChunk("prVa", nil), newChunk(IDATChunk, idat.data[4:]),
+		newChunk(ZTXTChunk, []byte("Title\x00\x00\x78\x9c\x03\x00\x00\x00\x00\x01")), newChunk(IENDChunk, nil))
+	p, err := ParsePng(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.NormalizeOrder()
+	var names []ChunkName
+	for _, c := range p.ChunkOffsets() {
+		names = append(names, c.Name)
+	}
+	var want = []ChunkName{IHDRChunk, GAMAChunk, PLTEChunk, TRNSChunk, PHYSChunk, "prVb", IDATChunk, IDATChunk,
+		TEXTChunk, TIMEChunk, "prVa", ZTXTChunk, IENDChunk}
+	if !slices.Equal(names, want) {
+		t.Fatalf("chunks %v, want %v", names, want)
+	}
+	if err = p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err = ParsePng(bytes.NewReader(testAPNG()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var before []ChunkName
+	for _, c := range p.ChunkOffsets() {
+		before = append(before, c.Name)
+	}
+	p.NormalizeOrder()
+	names = names[:0]
+	for _, c := range p.ChunkOffsets() {
+		names = append(names, c.Name)
+	}
+	if !slices.Equal(names, before) {
+		t.Fatalf("apng chunks %v, want %v", names, before)
+	}
+}
+
+func TestEncodePackedDepth(t *testing.T) {
+	var rect = image.Rect(0, 0, 67, 41)
+	var paletted = image.NewPaletted(rect, color.Palette{color.Black, color.White})
+	var gray = image.NewGray(rect)
+	var gray4 = image.NewGray(rect)
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			paletted.SetColorIndex(x, y, uint8((x*x+y)%3%2))
+			gray.SetGray(x, y, color.Gray{Y: uint8((x + y) % 4 * 0x55)})
+			gray4.SetGray(x, y, color.Gray{Y: uint8(x * y % 16 * 0x11)})
+		}
+	}
+	for _, c := range []struct {
+		img   image.Image
+		depth uint8
+	}{{paletted, 1}, {gray, 2}, {gray4, 4}} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, c.img); err != nil {
+			t.Fatal(err)
+		}
+		p, err := ParsePng(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.IHDR.BitDepth != c.depth {
+			t.Fatalf("bit depth %d, want %d", p.IHDR.BitDepth, c.depth)
+		}
+		got, err := png.Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertSamePixels(t, c.img, got)
+	}
+
+	// the same 1 bit image stored 8 bit
+	var rows [][]byte
+	for y := 0; y < rect.Dy(); y++ {
+		rows = append(rows, paletted.Pix[y*paletted.Stride:][:rect.Dx()])
+	}
+	wide, err := newPngFromRaster(&IHDR{Width: 67, Height: 41, BitDepth: 8, ColorType: Indexed}, opaquePalette(paletted.Palette), nil, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var packed, unpacked bytes.Buffer
+	if err = Encode(&packed, paletted); err != nil {
+		t.Fatal(err)
+	}
+	if err = wide.WritePng(&unpacked); err != nil {
+		t.Fatal(err)
+	}
+	if packed.Len() >= unpacked.Len() {
+		t.Fatalf("1 bit output %d bytes, 8 bit %d", packed.Len(), unpacked.Len())
+	}
+}
+
+// TestKnownCRCs checks serialized chunks against crcs produced by zlib's crc32, the one libpng
+// uses, so a byte order or polynomial mistake can't go unnoticed.
+func TestKnownCRCs(t *testing.T) {
+	var cases = []struct {
+		c   ChunkSerialize
+		crc uint32
+	}{
+		{&IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Grayscale}, 0x3a7e9b55},
+		{&IDAT{Data: []byte{0x78, 0x9c, 0x63, 0x60, 0x00, 0x00, 0x00, 0x02, 0x00, 0x01}}, 0x48afa471},
+		{&TEXT{Keyword: "Comment", Text: "simple-png"}, 0x458228b5},
+	}
+	for _, c := range cases {
+		ch, err := serializeChunk(c.c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ComputeCRC(c.c.ChunkName(), ch.data); got != c.crc {
+			t.Fatalf("%s crc %#08x, want %#08x", c.c.ChunkName(), got, c.crc)
+		}
+		var buf bytes.Buffer
+		if err = ch.writeTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if got := binary.BigEndian.Uint32(buf.Bytes()[buf.Len()-4:]); got != c.crc {
+			t.Fatalf("%s written with crc %#08x, want %#08x", c.c.ChunkName(), got, c.crc)
+		}
+	}
+
+	// the IEND chunk is the same 12 bytes in every png
+	var buf bytes.Buffer
+	if err := newChunk(IENDChunk, nil).writeTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0, 0, 0, 0, 'I', 'E', 'N', 'D', 0xae, 0x42, 0x60, 0x82}; !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("IEND written as % x", buf.Bytes())
+	}
+	if got := ComputeCRC(GAMAChunk, []byte{0, 0, 0xb1, 0x8f}); got != 0x0bfc6105 {
+		t.Fatalf("gAMA crc %#08x", got)
+	}
+}
+
+func TestChunkCRC(t *testing.T) {
+	var ihdr = &IHDR{Width: 1, Height: 1, BitDepth: 8, ColorType: Grayscale}
+	var text = newChunk(TEXTChunk, []byte("Comment\x00x"))
+	text.crc[0]++
+	var file = buildPng(ihdrChunk(ihdr), newChunk(GAMAChunk, []byte{0, 0, 0xb1, 0x8f}), blankIDAT(ihdr), text, newChunk(IENDChunk, nil))
+	if _, err := ParsePng(bytes.NewReader(file)); !errors.Is(err, ErrCRCMismatch) {
+		t.Fatalf("got %v, want ErrCRCMismatch", err)
+	}
+	var log recordLogger
+	p, err := ParsePng(bytes.NewReader(file), KeepBadCRC(), WithLogger(&log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.ContainsFunc(log, func(s string) bool { return strings.HasPrefix(s, "warning: ") }) {
+		t.Fatalf("no warning logged: %q", log)
+	}
+
+	if err = p.SetChunkData(GAMAChunk, 0, []byte{0, 1, 0x86, 0xa0}); err != nil {
+		t.Fatal(err)
+	}
+	if p.GAMA.ImageGamma != 100000 {
+		t.Fatalf("gAMA %+v", p.GAMA)
+	}
+	if err = p.SetChunkData(GAMAChunk, 1, nil); err == nil {
+		t.Fatal("missing chunk edited")
+	}
+	var buf bytes.Buffer
+	if err = p.WritePng(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// the edited gAMA gets a valid crc, the broken tEXt keeps its own
+	if !bytes.Contains(buf.Bytes(), slices.Concat([]byte("Comment\x00x"), text.crc[:])) {
+		t.Fatal("bad tEXt crc not preserved")
+	}
+	q, err := ParsePng(bytes.NewReader(buf.Bytes()), KeepBadCRC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.GAMA == nil || q.GAMA.ImageGamma != 100000 {
+		t.Fatalf("gAMA %+v", q.GAMA)
+	}
+
+	buf.Reset()
+	if err = p.WritePng(&buf, RecomputeCRC()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ParsePng(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+}